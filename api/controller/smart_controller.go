@@ -2,22 +2,32 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/nevcodia/smarthub/domain"
 	"github.com/nevcodia/smarthub/service"
+	"io"
 	"net/http"
-	"os"
+	"path"
 	"strconv"
+	"strings"
+	"time"
 )
 
+// maxFormFieldSize limits the form fields read ahead of the file part of an upload.
+const maxFormFieldSize = 1 << 20
+
 type SmartController interface {
 	StorageTypes(ctx *gin.Context)
+	Connections(ctx *gin.Context)
 	StoreNames(ctx *gin.Context)
 	Objects(ctx *gin.Context)
 	ObjectsWithMetadata(ctx *gin.Context)
 	GetObject(ctx *gin.Context)
 	Upload(ctx *gin.Context)
 	PresignUploadLink(ctx *gin.Context)
+	PresignPost(ctx *gin.Context)
 	Download(ctx *gin.Context)
 	PresignDownloadLink(ctx *gin.Context)
 	DeleteAll(ctx *gin.Context)
@@ -25,6 +35,24 @@ type SmartController interface {
 	Copy(ctx *gin.Context)
 	CopyAll(ctx *gin.Context)
 	Move(ctx *gin.Context)
+	MoveAll(ctx *gin.Context)
+	MoveAllRecords(ctx *gin.Context)
+	ResumeMoveAll(ctx *gin.Context)
+	Versions(ctx *gin.Context)
+	RestoreVersion(ctx *gin.Context)
+	DeleteVersion(ctx *gin.Context)
+	Versioning(ctx *gin.Context)
+	SetVersioning(ctx *gin.Context)
+	CopyBetween(ctx *gin.Context)
+	MoveBetween(ctx *gin.Context)
+	CreateMultipartUpload(ctx *gin.Context)
+	UploadPart(ctx *gin.Context)
+	ListParts(ctx *gin.Context)
+	CompleteMultipartUpload(ctx *gin.Context)
+	AbortMultipartUpload(ctx *gin.Context)
+	PresignMultipartUpload(ctx *gin.Context)
+	PresignedDownload(ctx *gin.Context)
+	PresignedUpload(ctx *gin.Context)
 }
 
 type smartController struct {
@@ -38,17 +66,20 @@ func NewSmartController(service service.SmartService) SmartController {
 }
 
 func (s *smartController) StorageTypes(ctx *gin.Context) {
-	types := []string{
-		domain.S3.String(),
-		//string(domain.FTP),
-		//string(domain.SHAREPOINT),
+	var types []string
+	for _, storageType := range domain.StorageTypes() {
+		types = append(types, storageType.String())
 	}
 	ctx.JSON(http.StatusOK, types)
 }
 
+func (s *smartController) Connections(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.service.Connections())
+}
+
 func (s *smartController) StoreNames(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
-	storeNames, err := s.service.StoreNames(storageType)
+	connection := s.ExtractConnection(ctx)
+	storeNames, err := s.service.StoreNames(connection)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 	} else {
@@ -57,7 +88,7 @@ func (s *smartController) StoreNames(ctx *gin.Context) {
 }
 
 func (s *smartController) Objects(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	storeName := ctx.Query("storeName")
 	maxObjectPerPage := ctx.DefaultQuery("maxObjectPerPage", "1000")
 	maxKeys, err := strconv.ParseInt(maxObjectPerPage, 10, 32)
@@ -65,23 +96,33 @@ func (s *smartController) Objects(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
-	page := ctx.DefaultQuery("page", "0")
-	currentPage, err := strconv.ParseInt(page, 10, 32)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+	token := ctx.Query("token")
+	prefix := ctx.Query("prefix")
+	// with a delimiter the keys are listed level by level, grouped into folders
+	if delimiter := ctx.Query("delimiter"); delimiter != "" {
+		folderSizes, err := strconv.ParseBool(ctx.DefaultQuery("folderSizes", "false"))
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+			return
+		}
+		page, err := s.service.Browse(connection, storeName, int32(maxKeys), token, prefix, delimiter, folderSizes)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, page)
 		return
 	}
-	prefix := ctx.Query("prefix")
-	objects, err := s.service.Objects(storageType, storeName, int32(maxKeys), int32(currentPage), prefix)
+	page, err := s.service.Objects(connection, storeName, int32(maxKeys), token, prefix)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK, objects)
+	ctx.JSON(http.StatusOK, page)
 }
 
 func (s *smartController) ObjectsWithMetadata(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	storeName := ctx.Query("storeName")
 	maxObjectPerPage := ctx.DefaultQuery("maxObjectPerPage", "1000")
 	maxKeys, err := strconv.ParseInt(maxObjectPerPage, 10, 32)
@@ -89,66 +130,92 @@ func (s *smartController) ObjectsWithMetadata(ctx *gin.Context) {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
-	page := ctx.DefaultQuery("page", "0")
-	currentPage, err := strconv.ParseInt(page, 10, 32)
-	if err != nil {
-		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
-		return
-	}
+	token := ctx.Query("token")
 	prefix := ctx.Query("prefix")
-	objects, err := s.service.ObjectsWithMetadata(storageType, storeName, int32(maxKeys), int32(currentPage), prefix)
+	page, err := s.service.ObjectsWithMetadata(connection, storeName, int32(maxKeys), token, prefix)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
-	ctx.JSON(http.StatusOK, objects)
+	ctx.JSON(http.StatusOK, page)
 }
 
 func (s *smartController) GetObject(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	storeName := ctx.Query("storeName")
 	key := ctx.Query("key")
 	params := &domain.ObjectParams{
-		StoreName: storeName,
-		Key:       key,
+		StoreName:  storeName,
+		Key:        key,
+		Conditions: conditionsOf(ctx),
+		VersionID:  ctx.Query("versionId"),
 	}
-	objects, err := s.service.GetObject(storageType, params)
+	object, err := s.service.GetObject(connection, params)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		readError(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, objects)
+	setValidators(ctx, object)
+	ctx.JSON(http.StatusOK, object)
 }
 
+// Upload streams the file part of a multipart/form-data request straight into the storage, so
+// nothing of the file is held in memory or spooled to disk. The storeName, key and metadata
+// fields therefore have to precede the file part.
 func (s *smartController) Upload(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
-	file, err := ctx.FormFile("file")
-	storeName := ctx.Request.PostFormValue("storeName")
-	key := ctx.Request.PostFormValue("key")
-	metadataString := ctx.Request.PostFormValue("metadata")
-	var metadata map[string]string
-	if err = json.Unmarshal([]byte(metadataString), &metadata); err != nil {
-		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
-		return
-	}
+	connection := s.ExtractConnection(ctx)
+	reader, err := ctx.Request.MultipartReader()
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
-	params := &domain.ObjectParams{
-		StoreName: storeName,
-		Key:       key,
-	}
-	response, err := s.service.UploadMultiPart(storageType, params, metadata, file)
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, domain.ErrorResponse{Message: err.Error()})
+	fields := map[string]string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: "the request has no file part"})
+			return
+		}
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+			return
+		}
+		if part.FormName() != "file" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize))
+			if err != nil {
+				ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+				return
+			}
+			fields[part.FormName()] = string(value)
+			continue
+		}
+		if fields["storeName"] == "" || fields["key"] == "" {
+			ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: "storeName and key have to precede the file part"})
+			return
+		}
+		var metadata map[string]string
+		if metadataString := fields["metadata"]; metadataString != "" {
+			if err = json.Unmarshal([]byte(metadataString), &metadata); err != nil {
+				ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+				return
+			}
+		}
+		params := &domain.ObjectParams{
+			StoreName: fields["storeName"],
+			Key:       fields["key"],
+		}
+		response, err := s.service.Upload(connection, params, metadata, part)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, domain.ErrorResponse{Message: err.Error()})
+			return
+		}
+		ctx.JSON(http.StatusOK, response)
 		return
 	}
-	ctx.JSON(http.StatusOK, response)
 }
 
 func (s *smartController) PresignUploadLink(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	var body domain.PresignUploadRequest
 	if err := ctx.BindJSON(&body); err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
@@ -158,7 +225,7 @@ func (s *smartController) PresignUploadLink(ctx *gin.Context) {
 		StoreName: body.StoreName,
 		Key:       body.Key,
 	}
-	url, err := s.service.PresignUploadLink(storageType, params, body.MimeType, body.Metadata, body.ExpirationTime)
+	url, err := s.service.PresignUploadLink(connection, params, body.MimeType, body.Metadata, body.ExpirationTime)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
@@ -166,40 +233,99 @@ func (s *smartController) PresignUploadLink(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, url)
 }
 
+// PresignPost answers the URL and fields of an HTML form, which uploads straight to the storage
+// within the limits of the request.
+func (s *smartController) PresignPost(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.PresignPostRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	post, err := s.service.PresignPost(connection, body.StoreName, domain.PostPolicy{
+		Key:               body.Key,
+		KeyPrefix:         body.KeyPrefix,
+		ContentType:       body.ContentType,
+		ContentTypePrefix: body.ContentTypePrefix,
+		MinSize:           body.MinSize,
+		MaxSize:           body.MaxSize,
+		Metadata:          body.Metadata,
+		Expiration:        body.ExpirationTime,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, post)
+}
+
+// Download streams the object to the client. A single range in the Range header is answered with
+// 206 Partial Content, a request for several ranges or a range whose If-Range no longer holds
+// with the whole object.
 func (s *smartController) Download(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	storeName := ctx.Query("storeName")
 	key := ctx.Query("key")
 	params := &domain.ObjectParams{
-		StoreName: storeName,
-		Key:       key,
-	}
-	result, err := s.service.Download(storageType, params)
-	defer os.Remove(result.Filename)
+		StoreName:  storeName,
+		Key:        key,
+		Conditions: conditionsOf(ctx),
+		VersionID:  ctx.Query("versionId"),
+	}
+	var byteRange *domain.ByteRange
+	var size int64
+	if header := ctx.GetHeader("Range"); header != "" {
+		object, err := s.service.GetObject(connection, params)
+		if err != nil {
+			readError(ctx, err)
+			return
+		}
+		if ifRange := ctx.GetHeader("If-Range"); ifRange == "" || ifRangeMatches(ifRange, object) {
+			size = object.Size
+			byteRange, err = parseRange(header, size)
+			if err != nil {
+				ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", size))
+				ctx.JSON(http.StatusRequestedRangeNotSatisfiable, domain.ErrorResponse{Message: err.Error()})
+				return
+			}
+		}
+	}
+	content, err := s.service.Download(connection, params, byteRange)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		readError(ctx, err)
 		return
 	}
-	ctx.Writer.Header().Set("Content-Type", result.Type)
-	ctx.Writer.Header().Set("Content-Disposition", result.Disposition)
-	ctx.File(result.Filename)
+	defer content.Body.Close()
+	setValidators(ctx, content.Object)
+	headers := map[string]string{
+		"Accept-Ranges":       "bytes",
+		"Content-Disposition": "inline;filename=" + path.Base(key),
+	}
+	if byteRange == nil {
+		ctx.DataFromReader(http.StatusOK, content.Object.Size, content.ContentType, content.Body, headers)
+		return
+	}
+	headers["Content-Range"] = fmt.Sprintf("bytes %d-%d/%d",
+		byteRange.Offset, byteRange.Offset+byteRange.Length-1, size)
+	ctx.DataFromReader(http.StatusPartialContent, byteRange.Length, content.ContentType, content.Body, headers)
 }
 
 func (s *smartController) PresignDownloadLink(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	storeName := ctx.Query("storeName")
 	key := ctx.Query("key")
 	expString := ctx.Query("exp")
 	params := &domain.ObjectParams{
 		StoreName: storeName,
 		Key:       key,
+		VersionID: ctx.Query("versionId"),
 	}
 	exp, err := strconv.ParseInt(expString, 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
-	url, err := s.service.PresignDownloadLinkWithExpTime(storageType, params, uint(exp))
+	url, err := s.service.PresignDownloadLinkWithExpTime(connection, params, uint(exp))
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
@@ -208,19 +334,36 @@ func (s *smartController) PresignDownloadLink(ctx *gin.Context) {
 }
 
 func (s *smartController) DeleteAll(ctx *gin.Context) {
-	//TODO implement me
-	panic("implement me")
+	connection := s.ExtractConnection(ctx)
+	storeName := ctx.Query("storeName")
+	prefix := ctx.Query("prefix")
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	all, err := strconv.ParseBool(ctx.DefaultQuery("all", "false"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	report, err := s.service.DeleteAll(connection, storeName, prefix, all, dryRun)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
 }
 
 func (s *smartController) Delete(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	storeName := ctx.Query("storeName")
 	key := ctx.Query("key")
 	params := &domain.ObjectParams{
 		StoreName: storeName,
 		Key:       key,
 	}
-	objects, err := s.service.Delete(storageType, params)
+	objects, err := s.service.Delete(connection, params)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
@@ -228,8 +371,88 @@ func (s *smartController) Delete(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, objects)
 }
 
+// Versions lists the versions and delete markers below prefix, or those of key if one is given.
+func (s *smartController) Versions(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	storeName := ctx.Query("storeName")
+	maxObjectPerPage := ctx.DefaultQuery("maxObjectPerPage", "1000")
+	maxKeys, err := strconv.ParseInt(maxObjectPerPage, 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	page, err := s.service.Versions(connection, storeName, int32(maxKeys), ctx.Query("token"), ctx.Query("prefix"), ctx.Query("key"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// RestoreVersion makes a copy of an older version the current version of its object.
+func (s *smartController) RestoreVersion(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.RestoreVersionRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	params := &domain.ObjectParams{
+		StoreName: body.StoreName,
+		Key:       body.Key,
+		VersionID: body.VersionID,
+	}
+	object, err := s.service.RestoreVersion(connection, params)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, object)
+}
+
+// DeleteVersion deletes a version of an object for good, unlike Delete.
+func (s *smartController) DeleteVersion(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	params := &domain.ObjectParams{
+		StoreName: ctx.Query("storeName"),
+		Key:       ctx.Query("key"),
+		VersionID: ctx.Query("versionId"),
+	}
+	if err := s.service.DeleteVersion(connection, params); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *smartController) Versioning(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	versioning, err := s.service.Versioning(connection, ctx.Query("storeName"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, versioning)
+}
+
+// SetVersioning enables or suspends the versioning of a store.
+func (s *smartController) SetVersioning(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.VersioningRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	versioning, err := s.service.SetVersioning(connection, body.StoreName, body.Enabled)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, versioning)
+}
+
 func (s *smartController) Copy(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	var body domain.ObjectMovementRequest
 	if err := ctx.BindJSON(&body); err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
@@ -243,7 +466,7 @@ func (s *smartController) Copy(ctx *gin.Context) {
 		StoreName: body.DestinationStoreName,
 		Key:       body.DestinationKey,
 	}
-	result, err := s.service.Copy(storageType, current, destination)
+	result, err := s.service.Copy(connection, current, destination)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
@@ -252,12 +475,22 @@ func (s *smartController) Copy(ctx *gin.Context) {
 }
 
 func (s *smartController) CopyAll(ctx *gin.Context) {
-	//TODO implement me
-	panic("implement me")
+	connection := s.ExtractConnection(ctx)
+	var body domain.CopyAllRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	report, err := s.service.CopyAll(connection, body.SourceStoreName, body.SourcePath, body.TargetStoreName, body.TargetPath, body.SkipIdentical)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, report)
 }
 
 func (s *smartController) Move(ctx *gin.Context) {
-	storageType := s.ExtractStorageType(ctx)
+	connection := s.ExtractConnection(ctx)
 	var body domain.ObjectMovementRequest
 	if err := ctx.BindJSON(&body); err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
@@ -271,15 +504,352 @@ func (s *smartController) Move(ctx *gin.Context) {
 		StoreName: body.DestinationStoreName,
 		Key:       body.DestinationKey,
 	}
-	result, err := s.service.Move(storageType, current, destination)
+	result, err := s.service.Move(connection, current, destination)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+// MoveAll moves every object below the source path, which is a rename of a folder on most storages.
+// Moves which don't complete can be resumed.
+func (s *smartController) MoveAll(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.MoveAllRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	record, err := s.service.MoveAll(connection, body.SourceStoreName, body.SourcePath, body.TargetStoreName, body.TargetPath)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, record)
+}
+
+// MoveAllRecords lists the moves which haven't completed.
+func (s *smartController) MoveAllRecords(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	records, err := s.service.MoveAllRecords(connection)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, records)
+}
+
+func (s *smartController) ResumeMoveAll(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	record, err := s.service.ResumeMoveAll(connection, ctx.Param("id"))
+	if errors.Is(err, domain.ErrMoveNotFound) {
+		ctx.JSON(http.StatusNotFound, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, record)
+}
+
+func (s *smartController) CopyBetween(ctx *gin.Context) {
+	var body domain.TransferRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	current, destination := transferParams(body)
+	result, err := s.service.CopyBetween(body.SourceConnection, current, body.DestinationConnection, destination)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+func (s *smartController) MoveBetween(ctx *gin.Context) {
+	var body domain.TransferRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	current, destination := transferParams(body)
+	result, err := s.service.MoveBetween(body.SourceConnection, current, body.DestinationConnection, destination)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+func transferParams(body domain.TransferRequest) (*domain.ObjectParams, *domain.ObjectParams) {
+	current := &domain.ObjectParams{
+		StoreName: body.SourceStoreName,
+		Key:       body.SourceKey,
+	}
+	destination := &domain.ObjectParams{
+		StoreName: body.DestinationStoreName,
+		Key:       body.DestinationKey,
+	}
+	return current, destination
+}
+
+func (s *smartController) CreateMultipartUpload(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.CreateMultipartUploadRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	params := &domain.ObjectParams{
+		StoreName: body.StoreName,
+		Key:       body.Key,
+	}
+	upload, err := s.service.CreateMultipartUpload(connection, params, body.Metadata)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
 		return
 	}
+	ctx.JSON(http.StatusOK, upload)
+}
+
+// UploadPart stores the request body as a part of the upload. A part uploaded again under the
+// same number replaces the former one, so clients resume by repeating the parts which failed.
+func (s *smartController) UploadPart(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	partNumber, err := strconv.ParseInt(ctx.Param("partNumber"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	params := &domain.ObjectParams{
+		StoreName: ctx.Query("storeName"),
+		Key:       ctx.Query("key"),
+	}
+	part, err := s.service.UploadPart(connection, params, ctx.Param("uploadId"), int32(partNumber), ctx.Request.Body, ctx.Request.ContentLength)
+	if err != nil {
+		multipartError(ctx, err)
+		return
+	}
+	ctx.Header("ETag", part.ETag)
+	ctx.JSON(http.StatusOK, part)
+}
+
+func (s *smartController) ListParts(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	params := &domain.ObjectParams{
+		StoreName: ctx.Query("storeName"),
+		Key:       ctx.Query("key"),
+	}
+	parts, err := s.service.ListParts(connection, params, ctx.Param("uploadId"))
+	if err != nil {
+		multipartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, parts)
+}
+
+// CompleteMultipartUpload assembles the object from the listed parts, or from all uploaded parts
+// if the body lists none.
+func (s *smartController) CompleteMultipartUpload(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.CompleteMultipartUploadRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	params := &domain.ObjectParams{
+		StoreName: body.StoreName,
+		Key:       body.Key,
+	}
+	object, err := s.service.CompleteMultipartUpload(connection, params, ctx.Param("uploadId"), body.Parts)
+	if err != nil {
+		multipartError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, object)
+}
+
+func (s *smartController) AbortMultipartUpload(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	params := &domain.ObjectParams{
+		StoreName: ctx.Query("storeName"),
+		Key:       ctx.Query("key"),
+	}
+	if err := s.service.AbortMultipartUpload(connection, params, ctx.Param("uploadId")); err != nil {
+		multipartError(ctx, err)
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// PresignMultipartUpload starts a multipart upload whose parts are uploaded straight to the storage
+// through the returned links. The upload is completed or aborted with the multipart endpoints.
+func (s *smartController) PresignMultipartUpload(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	var body domain.PresignMultipartUploadRequest
+	if err := ctx.BindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	params := &domain.ObjectParams{
+		StoreName: body.StoreName,
+		Key:       body.Key,
+	}
+	upload, err := s.service.PresignMultipartUpload(connection, params, body.Metadata, body.Size, body.PartSize, body.ExpirationTime)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, upload)
+}
+
+// PresignedDownload serves presigned download links of storage types without native presigning.
+func (s *smartController) PresignedDownload(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	content, err := s.service.OpenPresigned(connection, ctx.Request.URL.Query())
+	if err != nil {
+		ctx.JSON(presignedErrorStatus(err), domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	defer content.Body.Close()
+	setValidators(ctx, content.Object)
+	ctx.DataFromReader(http.StatusOK, content.Object.Size, content.ContentType, content.Body, nil)
+}
+
+// PresignedUpload accepts the request body for presigned upload links of storage types without
+// native presigning.
+func (s *smartController) PresignedUpload(ctx *gin.Context) {
+	connection := s.ExtractConnection(ctx)
+	result, err := s.service.UploadPresigned(connection, ctx.Request.URL.Query(), ctx.Request.Body)
+	if err != nil {
+		ctx.JSON(presignedErrorStatus(err), domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.Writer.Header().Set("ETag", result.ETag)
 	ctx.JSON(http.StatusOK, result)
 }
 
-func (s *smartController) ExtractStorageType(ctx *gin.Context) domain.StorageType {
-	sType := ctx.Param("type")
-	return domain.StorageTypeFromValue(sType)
+// conditionsOf reads the preconditions of a conditional request. Dates which don't parse are
+// ignored, as RFC 9110 demands.
+func conditionsOf(ctx *gin.Context) *domain.Conditions {
+	conditions := domain.Conditions{
+		IfMatch:     ctx.GetHeader("If-Match"),
+		IfNoneMatch: ctx.GetHeader("If-None-Match"),
+	}
+	conditions.IfModifiedSince, _ = http.ParseTime(ctx.GetHeader("If-Modified-Since"))
+	conditions.IfUnmodifiedSince, _ = http.ParseTime(ctx.GetHeader("If-Unmodified-Since"))
+	if conditions == (domain.Conditions{}) {
+		return nil
+	}
+	return &conditions
+}
+
+// ifRangeMatches reports whether the If-Range header, an entity tag or a date, still holds for object.
+func ifRangeMatches(header string, object domain.StorageObject) bool {
+	if date, err := http.ParseTime(header); err == nil {
+		return time.UnixMilli(object.LastModified).Truncate(time.Second).Equal(date)
+	}
+	return (&domain.Conditions{IfMatch: header}).Check(object) == nil
+}
+
+// setValidators sends the ETag and Last-Modified headers of object.
+func setValidators(ctx *gin.Context, object domain.StorageObject) {
+	if object.ETag != "" {
+		etag := object.ETag
+		if !strings.HasPrefix(etag, `"`) && !strings.HasPrefix(etag, `W/"`) {
+			etag = `"` + etag + `"`
+		}
+		ctx.Header("ETag", etag)
+	}
+	if object.LastModified != 0 {
+		ctx.Header("Last-Modified", time.UnixMilli(object.LastModified).UTC().Format(http.TimeFormat))
+	}
+}
+
+// readError answers a failed read. Reads whose preconditions aren't met end with
+// 304 Not Modified, which repeats the validators of the object, or 412 Precondition Failed.
+func readError(ctx *gin.Context, err error) {
+	var notModified *domain.NotModifiedError
+	switch {
+	case errors.As(err, &notModified):
+		setValidators(ctx, notModified.Object)
+		ctx.Status(http.StatusNotModified)
+	case errors.Is(err, domain.ErrNotModified):
+		ctx.Status(http.StatusNotModified)
+	case errors.Is(err, domain.ErrPreconditionFailed):
+		ctx.JSON(http.StatusPreconditionFailed, domain.ErrorResponse{Message: err.Error()})
+	default:
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+	}
+}
+
+// multipartError answers a failed step of a multipart upload, with 404 Not Found if the upload
+// doesn't exist (anymore).
+func multipartError(ctx *gin.Context, err error) {
+	if errors.Is(err, domain.ErrUploadNotFound) {
+		ctx.JSON(http.StatusNotFound, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+}
+
+var errRangeNotSatisfiable = errors.New("requested range is not satisfiable")
+
+// parseRange resolves a Range header against an object of the given size. Ranges the hub doesn't
+// serve, i.e. other units, several ranges or malformed ones, are ignored as RFC 9110 allows.
+func parseRange(header string, size int64) (*domain.ByteRange, error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return nil, nil
+	}
+	if first == "" {
+		// the last bytes of the object
+		length, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || length < 0 {
+			return nil, nil
+		}
+		if length == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		if length > size {
+			length = size
+		}
+		return &domain.ByteRange{Offset: size - length, Length: length}, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		if end > size-1 {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
+	}
+	return &domain.ByteRange{Offset: start, Length: end - start + 1}, nil
+}
+
+func presignedErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrPresignInvalid) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
+}
+
+func (s *smartController) ExtractConnection(ctx *gin.Context) string {
+	return ctx.Param("connection")
 }
@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"github.com/nevcodia/smarthub/service"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newTestRouter routes the smart API of the memory connection "mem" with the store "files".
+func newTestRouter(t *testing.T) (*gin.Engine, service.SmartService) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	smartService := service.NewSmartService([]domain.Connection{{
+		Name:       "mem",
+		Type:       repository.MEMORY,
+		Repository: repository.NewMemoryRepository([]string{"files"}, "http://hub.test/api/mem/presigned", []byte("test key")),
+	}}, t.TempDir(), t.TempDir())
+	smartController := NewSmartController(smartService)
+	router := gin.New()
+	group := router.Group("/api")
+	group.GET("/:connection/objects", smartController.Objects)
+	group.GET("/:connection/object", smartController.GetObject)
+	group.GET("/:connection/download", smartController.Download)
+	group.GET("/:connection/download-link", smartController.PresignDownloadLink)
+	group.POST("/:connection/upload-link", smartController.PresignUploadLink)
+	group.GET("/:connection/presigned", smartController.PresignedDownload)
+	group.PUT("/:connection/presigned", smartController.PresignedUpload)
+	return router, smartService
+}
+
+func serve(router *gin.Engine, method string, target string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func putObject(t *testing.T, smartService service.SmartService, key string, content string) domain.StorageObject {
+	t.Helper()
+	object, err := smartService.Upload("mem", &domain.ObjectParams{StoreName: "files", Key: key}, nil, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+func TestGetObjectValidators(t *testing.T) {
+	router, smartService := newTestRouter(t)
+	object := putObject(t, smartService, "a.txt", "hello")
+	target := "/api/mem/object?storeName=files&key=a.txt"
+	response := serve(router, http.MethodGet, target, "", nil)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") != object.ETag || response.Header().Get("Last-Modified") == "" {
+		t.Fatalf("got %v with headers %v", response.Code, response.Header())
+	}
+	for _, test := range []struct {
+		headers map[string]string
+		want    int
+	}{
+		{map[string]string{"If-None-Match": object.ETag}, http.StatusNotModified},
+		{map[string]string{"If-None-Match": `"other"`}, http.StatusOK},
+		{map[string]string{"If-Match": `"other"`}, http.StatusPreconditionFailed},
+		{map[string]string{"If-Modified-Since": response.Header().Get("Last-Modified")}, http.StatusNotModified},
+		{map[string]string{"If-Modified-Since": "not a date"}, http.StatusOK},
+	} {
+		got := serve(router, http.MethodGet, target, "", test.headers)
+		if got.Code != test.want {
+			t.Errorf("%v answered %v, want %v", test.headers, got.Code, test.want)
+			continue
+		}
+		// a 304 repeats the validators of the object
+		if test.want == http.StatusNotModified && (got.Header().Get("ETag") != object.ETag ||
+			got.Header().Get("Last-Modified") != response.Header().Get("Last-Modified")) {
+			t.Errorf("%v answered 304 with headers %v", test.headers, got.Header())
+		}
+	}
+}
+
+func TestDownloadRanges(t *testing.T) {
+	router, smartService := newTestRouter(t)
+	object := putObject(t, smartService, "a.txt", "hello world")
+	target := "/api/mem/download?storeName=files&key=a.txt"
+	for _, test := range []struct {
+		headers      map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{map[string]string{}, http.StatusOK, "hello world", ""},
+		{map[string]string{"Range": "bytes=6-"}, http.StatusPartialContent, "world", "bytes 6-10/11"},
+		{map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "rld", "bytes 8-10/11"},
+		{map[string]string{"Range": "bytes=0-0,5-6"}, http.StatusOK, "hello world", ""},
+		{map[string]string{"Range": "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */11"},
+		{map[string]string{"Range": "bytes=0-4", "If-Range": object.ETag}, http.StatusPartialContent, "hello", "bytes 0-4/11"},
+		{map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`}, http.StatusOK, "hello world", ""},
+		{map[string]string{"Range": "bytes=0-4", "If-None-Match": object.ETag}, http.StatusNotModified, "", ""},
+		{map[string]string{"If-None-Match": object.ETag}, http.StatusNotModified, "", ""},
+	} {
+		response := serve(router, http.MethodGet, target, "", test.headers)
+		if response.Code != test.status || response.Header().Get("Content-Range") != test.contentRange {
+			t.Errorf("%v answered %v with Content-Range %q, want %v with %q",
+				test.headers, response.Code, response.Header().Get("Content-Range"), test.status, test.contentRange)
+			continue
+		}
+		if test.status < 300 && response.Body.String() != test.body {
+			t.Errorf("%v answered %q, want %q", test.headers, response.Body.String(), test.body)
+		}
+		if test.status == http.StatusNotModified && (response.Header().Get("ETag") != object.ETag || response.Header().Get("Last-Modified") == "") {
+			t.Errorf("%v answered 304 with headers %v", test.headers, response.Header())
+		}
+	}
+}
+
+func TestObjectsPagination(t *testing.T) {
+	router, smartService := newTestRouter(t)
+	for _, key := range []string{"c.txt", "a.txt", "b.txt"} {
+		putObject(t, smartService, key, key)
+	}
+	var keys []string
+	token := ""
+	for {
+		response := serve(router, http.MethodGet, "/api/mem/objects?storeName=files&maxObjectPerPage=2&token="+url.QueryEscape(token), "", nil)
+		var page domain.ObjectPage
+		if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil || response.Code != http.StatusOK {
+			t.Fatalf("got %v %s", response.Code, response.Body)
+		}
+		for _, object := range page.Objects {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		token = page.NextToken
+	}
+	if strings.Join(keys, ",") != "a.txt,b.txt,c.txt" {
+		t.Fatalf("got keys %v", keys)
+	}
+	if response := serve(router, http.MethodGet, "/api/mem/objects?storeName=files&maxObjectPerPage=x", "", nil); response.Code != http.StatusBadRequest {
+		t.Fatalf("got %v for an invalid page size", response.Code)
+	}
+}
+
+func TestPresignedRoundTrip(t *testing.T) {
+	router, _ := newTestRouter(t)
+	response := serve(router, http.MethodPost, "/api/mem/upload-link",
+		`{"store_name": "files", "key": "a.txt", "mime_type": "text/plain", "exp": 60000}`, nil)
+	var link string
+	if err := json.Unmarshal(response.Body.Bytes(), &link); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("got %v %s", response.Code, response.Body)
+	}
+	uploadURL, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = serve(router, http.MethodPut, uploadURL.RequestURI(), "hello", nil)
+	if response.Code != http.StatusOK || response.Header().Get("ETag") == "" {
+		t.Fatalf("presigned upload answered %v %s", response.Code, response.Body)
+	}
+
+	response = serve(router, http.MethodGet, "/api/mem/download-link?storeName=files&key=a.txt&exp=60000", "", nil)
+	if err = json.Unmarshal(response.Body.Bytes(), &link); err != nil || response.Code != http.StatusOK {
+		t.Fatalf("got %v %s", response.Code, response.Body)
+	}
+	downloadURL, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response = serve(router, http.MethodGet, downloadURL.RequestURI(), "", nil)
+	body, _ := io.ReadAll(response.Body)
+	if response.Code != http.StatusOK || string(body) != "hello" || response.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("presigned download answered %v %q as %v", response.Code, body, response.Header().Get("Content-Type"))
+	}
+
+	query := downloadURL.Query()
+	query.Set("key", "b.txt")
+	if response = serve(router, http.MethodGet, downloadURL.Path+"?"+query.Encode(), "", nil); response.Code != http.StatusForbidden {
+		t.Fatalf("tampered link answered %v", response.Code)
+	}
+	if response = serve(router, http.MethodPut, downloadURL.RequestURI(), "replaced", nil); response.Code != http.StatusForbidden {
+		t.Fatalf("upload with a download link answered %v", response.Code)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		size   int64
+		want   *domain.ByteRange
+		err    error
+	}{
+		{"bytes=0-4", 11, &domain.ByteRange{Offset: 0, Length: 5}, nil},
+		{"bytes=6-", 11, &domain.ByteRange{Offset: 6, Length: 5}, nil},
+		{"bytes=10-", 11, &domain.ByteRange{Offset: 10, Length: 1}, nil},
+		{"bytes=-3", 11, &domain.ByteRange{Offset: 8, Length: 3}, nil},
+		{"bytes=-20", 11, &domain.ByteRange{Offset: 0, Length: 11}, nil},
+		{"bytes=-0", 11, nil, errRangeNotSatisfiable},
+		{"bytes=-3", 0, nil, errRangeNotSatisfiable},
+		{"bytes=6-20", 11, &domain.ByteRange{Offset: 6, Length: 5}, nil},
+		{"bytes=11-", 11, nil, errRangeNotSatisfiable},
+		{"bytes=20-30", 11, nil, errRangeNotSatisfiable},
+		{"bytes=0-", 0, nil, errRangeNotSatisfiable},
+		{"bytes= 2-3", 11, &domain.ByteRange{Offset: 2, Length: 2}, nil},
+		{"bytes=0-0,5-6", 11, nil, nil},
+		{"bytes=0-1, -2", 11, nil, nil},
+		{"bytes=5-4", 11, nil, nil},
+		{"bytes=-", 11, nil, nil},
+		{"bytes=a-4", 11, nil, nil},
+		{"bytes=0-b", 11, nil, nil},
+		{"bytes=-1-2", 11, nil, nil},
+		{"bytes=4", 11, nil, nil},
+		{"items=0-4", 11, nil, nil},
+		{"", 11, nil, nil},
+	} {
+		got, err := parseRange(test.header, test.size)
+		if err != test.err || (got == nil) != (test.want == nil) || got != nil && *got != *test.want {
+			t.Errorf("parseRange(%q, %v) = %+v, %v, want %+v, %v", test.header, test.size, got, err, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/service"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// tusExtensions are the extensions of the tus protocol the hub supports.
+const tusExtensions = "creation,termination,checksum,expiration"
+
+// statusChecksumMismatch is the status the checksum extension of tus answers a corrupted chunk with.
+const statusChecksumMismatch = 460
+
+// TusController speaks the tus resumable upload protocol, see https://tus.io/protocols/resumable-upload.
+type TusController interface {
+	Options(ctx *gin.Context)
+	Create(ctx *gin.Context)
+	Head(ctx *gin.Context)
+	Patch(ctx *gin.Context)
+	Terminate(ctx *gin.Context)
+}
+
+type tusController struct {
+	service service.TusService
+}
+
+func NewTusController(service service.TusService) TusController {
+	return &tusController{
+		service: service,
+	}
+}
+
+func (t *tusController) Options(ctx *gin.Context) {
+	algorithms := make([]string, 0, len(domain.TusChecksumAlgorithms))
+	for algorithm := range domain.TusChecksumAlgorithms {
+		algorithms = append(algorithms, algorithm)
+	}
+	sort.Strings(algorithms)
+	ctx.Header("Tus-Resumable", domain.TusVersion)
+	ctx.Header("Tus-Version", domain.TusVersion)
+	ctx.Header("Tus-Extension", tusExtensions)
+	ctx.Header("Tus-Checksum-Algorithm", strings.Join(algorithms, ","))
+	ctx.Status(http.StatusNoContent)
+}
+
+// Create starts an upload. Its target is named by the storeName and key pairs of Upload-Metadata.
+func (t *tusController) Create(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+	if ctx.GetHeader("Upload-Defer-Length") != "" {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: "uploads of deferred length are not supported"})
+		return
+	}
+	length, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: "Upload-Length is missing or invalid"})
+		return
+	}
+	metadata, err := parseTusMetadata(ctx.GetHeader("Upload-Metadata"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	upload, err := t.service.CreateUpload(t.ExtractConnection(ctx), length, metadata)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.Header("Location", path.Join(ctx.Request.URL.Path, upload.ID))
+	ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	ctx.Status(http.StatusCreated)
+}
+
+func (t *tusController) Head(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+	upload, err := t.service.GetUpload(t.ExtractConnection(ctx), ctx.Param("id"))
+	if err != nil {
+		ctx.Status(tusErrorStatus(err))
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	if len(upload.Metadata) > 0 {
+		ctx.Header("Upload-Metadata", formatTusMetadata(upload.Metadata))
+	}
+	ctx.Status(http.StatusOK)
+}
+
+// Patch appends the request body to the upload at Upload-Offset, which must be the upload's
+// current offset.
+func (t *tusController) Patch(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+	if ctx.ContentType() != "application/offset+octet-stream" {
+		ctx.JSON(http.StatusUnsupportedMediaType, domain.ErrorResponse{Message: "Content-Type has to be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: "Upload-Offset is missing or invalid"})
+		return
+	}
+	var checksum *domain.TusChecksum
+	if header := ctx.GetHeader("Upload-Checksum"); header != "" {
+		algorithm, encoded, _ := strings.Cut(header, " ")
+		sum, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, domain.ErrorResponse{Message: "Upload-Checksum is invalid"})
+			return
+		}
+		checksum = &domain.TusChecksum{Algorithm: algorithm, Sum: sum}
+	}
+	upload, err := t.service.WriteUpload(t.ExtractConnection(ctx), ctx.Param("id"), offset, ctx.Request.Body, checksum)
+	if err != nil {
+		ctx.JSON(tusErrorStatus(err), domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Expires", upload.Expires.UTC().Format(http.TimeFormat))
+	ctx.Status(http.StatusNoContent)
+}
+
+func (t *tusController) Terminate(ctx *gin.Context) {
+	if !tusResumable(ctx) {
+		return
+	}
+	if err := t.service.TerminateUpload(t.ExtractConnection(ctx), ctx.Param("id")); err != nil {
+		ctx.JSON(tusErrorStatus(err), domain.ErrorResponse{Message: err.Error()})
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+func (t *tusController) ExtractConnection(ctx *gin.Context) string {
+	return ctx.Param("connection")
+}
+
+// tusResumable sends the protocol version with the response and answers requests for any other
+// version with 412 Precondition Failed.
+func tusResumable(ctx *gin.Context) bool {
+	ctx.Header("Tus-Resumable", domain.TusVersion)
+	if ctx.GetHeader("Tus-Resumable") != domain.TusVersion {
+		ctx.Header("Tus-Version", domain.TusVersion)
+		ctx.AbortWithStatus(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func tusErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrOffsetMismatch):
+		return http.StatusConflict
+	case errors.Is(err, domain.ErrChecksumMismatch):
+		return statusChecksumMismatch
+	case errors.Is(err, domain.ErrUploadTooLarge):
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// parseTusMetadata reads Upload-Metadata, comma-separated pairs of a key and its Base64 encoded
+// value. The value may be left out.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			return nil, errors.New("Upload-Metadata is invalid")
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("Upload-Metadata is invalid")
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+func formatTusMetadata(metadata map[string]string) string {
+	pairs := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		if value == "" {
+			pairs = append(pairs, key)
+		} else {
+			pairs = append(pairs, key+" "+base64.StdEncoding.EncodeToString([]byte(value)))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/gin-gonic/gin"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"github.com/nevcodia/smarthub/service"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTusRouter routes the tus API of the memory connection "mem" with the store "files".
+func newTusRouter(t *testing.T, expiration time.Duration) (*gin.Engine, service.SmartService) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	smartService := service.NewSmartService([]domain.Connection{{
+		Name:       "mem",
+		Type:       repository.MEMORY,
+		Repository: repository.NewMemoryRepository([]string{"files"}, "http://hub.test/api/mem/presigned", []byte("test key")),
+	}}, t.TempDir(), t.TempDir())
+	tusService := service.NewTusService(smartService, t.TempDir(), expiration)
+	t.Cleanup(func() { tusService.Close() })
+	tusController := NewTusController(tusService)
+	router := gin.New()
+	group := router.Group("/api")
+	group.POST("/:connection/tus/", tusController.Create)
+	group.HEAD("/:connection/tus/:id", tusController.Head)
+	group.PATCH("/:connection/tus/:id", tusController.Patch)
+	group.DELETE("/:connection/tus/:id", tusController.Terminate)
+	return router, smartService
+}
+
+// createTusUpload starts an upload of length bytes to files:a.txt and returns its location.
+func createTusUpload(t *testing.T, router *gin.Engine, length string) string {
+	t.Helper()
+	response := serve(router, http.MethodPost, "/api/mem/tus/", "", map[string]string{
+		"Tus-Resumable":   domain.TusVersion,
+		"Upload-Length":   length,
+		"Upload-Metadata": "storeName " + base64.StdEncoding.EncodeToString([]byte("files")) + ",key " + base64.StdEncoding.EncodeToString([]byte("a.txt")),
+	})
+	if response.Code != http.StatusCreated || response.Header().Get("Location") == "" {
+		t.Fatalf("creating the upload answered %v %s", response.Code, response.Body)
+	}
+	return response.Header().Get("Location")
+}
+
+func patchTus(router *gin.Engine, location string, offset string, body string, checksum string) (int, string) {
+	headers := map[string]string{
+		"Tus-Resumable": domain.TusVersion,
+		"Content-Type":  "application/offset+octet-stream",
+		"Upload-Offset": offset,
+	}
+	if checksum != "" {
+		headers["Upload-Checksum"] = checksum
+	}
+	response := serve(router, http.MethodPatch, location, body, headers)
+	return response.Code, response.Header().Get("Upload-Offset")
+}
+
+func sha256Checksum(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestTusUpload(t *testing.T) {
+	router, smartService := newTusRouter(t, 0)
+	location := createTusUpload(t, router, "11")
+	for _, test := range []struct {
+		name     string
+		offset   string
+		body     string
+		checksum string
+		status   int
+		want     string
+	}{
+		{"first chunk", "0", "hello", "", http.StatusNoContent, "5"},
+		{"offset behind", "0", "hello", "", http.StatusConflict, ""},
+		{"offset ahead", "6", "world", "", http.StatusConflict, ""},
+		{"corrupted chunk", "5", " world", sha256Checksum(" World"), statusChecksumMismatch, ""},
+		{"unknown algorithm", "5", " world", "crc32 AAAAAA==", http.StatusBadRequest, ""},
+		{"too large", "5", " world!", "", http.StatusRequestEntityTooLarge, ""},
+		{"last chunk", "5", " world", sha256Checksum(" world"), http.StatusNoContent, "11"},
+	} {
+		status, offset := patchTus(router, location, test.offset, test.body, test.checksum)
+		if status != test.status || offset != test.want {
+			t.Errorf("%v: answered %v with offset %q, want %v with %q", test.name, status, offset, test.status, test.want)
+		}
+	}
+	response := serve(router, http.MethodHead, location, "", map[string]string{"Tus-Resumable": domain.TusVersion})
+	if response.Code != http.StatusOK || response.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("HEAD answered %v with offset %q", response.Code, response.Header().Get("Upload-Offset"))
+	}
+	content, err := smartService.Download("mem", &domain.ObjectParams{StoreName: "files", Key: "a.txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Body.Close()
+	if data, _ := io.ReadAll(content.Body); string(data) != "hello world" {
+		t.Fatalf("uploaded %q", data)
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	router, _ := newTusRouter(t, 0)
+	location := createTusUpload(t, router, "11")
+	if status, _ := patchTus(router, location, "0", "hello", ""); status != http.StatusNoContent {
+		t.Fatalf("PATCH answered %v", status)
+	}
+	headers := map[string]string{"Tus-Resumable": domain.TusVersion}
+	if response := serve(router, http.MethodDelete, location, "", headers); response.Code != http.StatusNoContent {
+		t.Fatalf("DELETE answered %v %s", response.Code, response.Body)
+	}
+	if response := serve(router, http.MethodHead, location, "", headers); response.Code != http.StatusNotFound {
+		t.Fatalf("HEAD of a terminated upload answered %v", response.Code)
+	}
+	if status, _ := patchTus(router, location, "5", " world", ""); status != http.StatusNotFound {
+		t.Fatalf("PATCH of a terminated upload answered %v", status)
+	}
+	if response := serve(router, http.MethodDelete, location, "", headers); response.Code != http.StatusNotFound {
+		t.Fatalf("second DELETE answered %v", response.Code)
+	}
+}
+
+func TestTusExpiry(t *testing.T) {
+	router, _ := newTusRouter(t, time.Millisecond)
+	location := createTusUpload(t, router, "11")
+	time.Sleep(5 * time.Millisecond)
+	if response := serve(router, http.MethodHead, location, "", map[string]string{"Tus-Resumable": domain.TusVersion}); response.Code != http.StatusNotFound {
+		t.Fatalf("HEAD of an expired upload answered %v", response.Code)
+	}
+	if status, _ := patchTus(router, location, "0", "hello", ""); status != http.StatusNotFound {
+		t.Fatalf("PATCH of an expired upload answered %v", status)
+	}
+}
@@ -1,36 +1,72 @@
 package route
 
 import (
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gin-gonic/gin"
 	"github.com/nevcodia/smarthub/api/controller"
+	"github.com/nevcodia/smarthub/bootstrap"
 	"github.com/nevcodia/smarthub/domain"
-	"github.com/nevcodia/smarthub/repository"
 	"github.com/nevcodia/smarthub/service"
+	"log"
+	"time"
 )
 
-func NewSmartRouter(client *s3.Client, group *gin.RouterGroup) {
-	s3Repository := repository.NewS3Repository(client)
-	reps := map[domain.StorageType]domain.StorageRepository{
-		domain.S3: s3Repository,
+func NewSmartRouter(app bootstrap.Application, group *gin.RouterGroup) {
+	connections := make([]domain.Connection, 0, len(app.Connections))
+	for _, config := range app.Connections {
+		repository, err := domain.NewStorageRepository(config)
+		if err != nil {
+			log.Fatal(err)
+		}
+		connections = append(connections, domain.Connection{
+			Name:       config.Name,
+			Type:       domain.StorageTypeFromValue(config.Type),
+			Repository: repository,
+		})
 	}
-	smartController := controller.NewSmartController(service.NewSmartService(reps))
+	smartService := service.NewSmartService(connections, app.Env.MultipartStagingDir, app.Env.MoveJournalDir)
+	smartController := controller.NewSmartController(smartService)
+	tusController := controller.NewTusController(service.NewTusService(smartService, app.Env.TusUploadDir, time.Duration(app.Env.TusExpirationHours)*time.Hour))
 
 	group.GET("/support", smartController.StorageTypes)
-	group.GET("/:type/stores", smartController.StoreNames)
-	group.GET("/:type/objects", smartController.Objects)
-	group.GET("/:type/objects/metadata", smartController.ObjectsWithMetadata)
-	group.GET("/:type/object", smartController.GetObject)
-	group.DELETE("/:type/object", smartController.Delete)
-	group.DELETE("/:type/object/all", smartController.DeleteAll)
-	group.PUT("/:type/copy", smartController.Copy)
-	//group.PUT("/:type/copy/multi", smartController.CopyMulti)
-	group.PUT("/:type/copy/all", smartController.CopyAll)
-	group.PUT("/:type/move", smartController.Move)
-	group.POST("/:type/upload", smartController.Upload)
-	group.POST("/:type/upload-link", smartController.PresignUploadLink)
-	//group.POST("/:type/upload-link", smartController.PresignUploadLinkWithMetadata)
-	group.GET("/:type/download-link", smartController.PresignDownloadLink)
-	group.GET("/:type/download", smartController.Download) //Use presigned download link for larger files
-
+	group.GET("/connections", smartController.Connections)
+	group.PUT("/copy", smartController.CopyBetween)
+	group.PUT("/move", smartController.MoveBetween)
+	group.GET("/:connection/stores", smartController.StoreNames)
+	group.GET("/:connection/objects", smartController.Objects)
+	group.GET("/:connection/objects/metadata", smartController.ObjectsWithMetadata)
+	group.GET("/:connection/object", smartController.GetObject)
+	group.DELETE("/:connection/object", smartController.Delete)
+	group.DELETE("/:connection/object/all", smartController.DeleteAll)
+	group.PUT("/:connection/copy", smartController.Copy)
+	//group.PUT("/:connection/copy/multi", smartController.CopyMulti)
+	group.PUT("/:connection/copy/all", smartController.CopyAll)
+	group.PUT("/:connection/move", smartController.Move)
+	group.PUT("/:connection/move/all", smartController.MoveAll)
+	group.GET("/:connection/move/all", smartController.MoveAllRecords)
+	group.PUT("/:connection/move/all/:id", smartController.ResumeMoveAll)
+	group.GET("/:connection/versions", smartController.Versions)
+	group.PUT("/:connection/versions/restore", smartController.RestoreVersion)
+	group.DELETE("/:connection/version", smartController.DeleteVersion)
+	group.GET("/:connection/versioning", smartController.Versioning)
+	group.PUT("/:connection/versioning", smartController.SetVersioning)
+	group.POST("/:connection/upload", smartController.Upload)
+	group.POST("/:connection/upload-link", smartController.PresignUploadLink)
+	group.POST("/:connection/upload-form", smartController.PresignPost)
+	group.POST("/:connection/multipart", smartController.CreateMultipartUpload)
+	group.GET("/:connection/multipart/:uploadId/parts", smartController.ListParts)
+	group.PUT("/:connection/multipart/:uploadId/parts/:partNumber", smartController.UploadPart)
+	group.POST("/:connection/multipart/:uploadId/complete", smartController.CompleteMultipartUpload)
+	group.DELETE("/:connection/multipart/:uploadId", smartController.AbortMultipartUpload)
+	group.POST("/:connection/multipart-links", smartController.PresignMultipartUpload)
+	group.OPTIONS("/:connection/tus/", tusController.Options)
+	group.POST("/:connection/tus/", tusController.Create)
+	group.OPTIONS("/:connection/tus/:id", tusController.Options)
+	group.HEAD("/:connection/tus/:id", tusController.Head)
+	group.PATCH("/:connection/tus/:id", tusController.Patch)
+	group.DELETE("/:connection/tus/:id", tusController.Terminate)
+	//group.POST("/:connection/upload-link", smartController.PresignUploadLinkWithMetadata)
+	group.GET("/:connection/download-link", smartController.PresignDownloadLink)
+	group.GET("/:connection/download", smartController.Download)
+	group.GET("/:connection/presigned", smartController.PresignedDownload)
+	group.PUT("/:connection/presigned", smartController.PresignedUpload)
 }
@@ -1,17 +1,15 @@
 package bootstrap
 
-import (
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-)
+import "github.com/nevcodia/smarthub/domain"
 
 type Application struct {
-	Env *Env
-	S3  *s3.Client
+	Env         *Env
+	Connections []domain.ConnectionConfig
 }
 
 func App() Application {
 	app := &Application{}
 	app.Env = NewEnv()
-	app.S3 = NewS3Client(app.Env)
+	app.Connections = LoadConnections(app.Env)
 	return *app
 }
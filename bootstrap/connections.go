@@ -0,0 +1,209 @@
+package bootstrap
+
+import (
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"github.com/spf13/viper"
+	"log"
+	"regexp"
+	"strconv"
+)
+
+// connectionName keeps names usable as a single path segment in /api/:connection/...
+var connectionName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// reservedConnectionNames are taken by routes which sit next to /api/:connection.
+var reservedConnectionNames = map[string]bool{
+	"support":     true,
+	"connections": true,
+	"copy":        true,
+	"move":        true,
+}
+
+// LoadConnections reads the connections listed in CONNECTIONS_FILE and adds one connection per
+// storage type configured through the older single connection variables, named after the type.
+func LoadConnections(env *Env) []domain.ConnectionConfig {
+	connections, err := loadConnections(env)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return connections
+}
+
+func loadConnections(env *Env) ([]domain.ConnectionConfig, error) {
+	var connections []domain.ConnectionConfig
+	if env.ConnectionsFile != "" {
+		file := viper.New()
+		file.SetConfigFile(env.ConnectionsFile)
+		if err := file.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("can't read CONNECTIONS_FILE: %w", err)
+		}
+		if err := file.UnmarshalKey("connections", &connections); err != nil {
+			return nil, fmt.Errorf("connections can't be loaded: %w", err)
+		}
+	}
+	names := map[string]bool{}
+	for _, connection := range connections {
+		names[connection.Name] = true
+	}
+	for _, connection := range legacyConnections(env) {
+		if names[connection.Name] {
+			log.Printf("Connection %v from CONNECTIONS_FILE overrides the %v variables\n",
+				connection.Name, connection.Type)
+			continue
+		}
+		connections = append(connections, connection)
+	}
+
+	seen := map[string]bool{}
+	for i, connection := range connections {
+		if !connectionName.MatchString(connection.Name) || reservedConnectionNames[connection.Name] {
+			return nil, fmt.Errorf("connection name %q is invalid or reserved", connection.Name)
+		}
+		if seen[connection.Name] {
+			return nil, fmt.Errorf("connection %v is configured more than once", connection.Name)
+		}
+		seen[connection.Name] = true
+		if domain.StorageTypeFromValue(connection.Type) == "unknown" {
+			return nil, fmt.Errorf("connection %v has an unknown type %q, supported are %v",
+				connection.Name, connection.Type, domain.StorageTypes())
+		}
+		connections[i].HubURL = hubURL(env)
+	}
+	return connections, nil
+}
+
+// legacyConnections maps the single connection variables of every storage type, e.g. S3_HOST_ADDR,
+// onto connections named after the type.
+func legacyConnections(env *Env) []domain.ConnectionConfig {
+	var connections []domain.ConnectionConfig
+	if env.S3HostAddr != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:     repository.S3.String(),
+			Type:     repository.S3.String(),
+			Endpoint: env.S3HostAddr,
+			Region:   env.S3Region,
+			Credentials: map[string]string{
+				"access_key": env.S3AccessKey,
+				"secret_key": env.S3SecretKey,
+			},
+			Options: map[string]string{
+				"part_size_mb":       env.S3PartSizeMB,
+				"upload_concurrency": env.S3UploadConcurrency,
+			},
+		})
+	}
+	if env.FTPHostAddr != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:     repository.FTP.String(),
+			Type:     repository.FTP.String(),
+			Endpoint: env.FTPHostAddr,
+			Credentials: map[string]string{
+				"user":     env.FTPUser,
+				"password": env.FTPPassword,
+			},
+			Options: map[string]string{
+				"roots": env.FTPRoots,
+				"tls":   env.FTPTLS,
+			},
+		})
+	}
+	if env.SharePointClientID != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:     repository.SHAREPOINT.String(),
+			Type:     repository.SHAREPOINT.String(),
+			Endpoint: env.SharePointGraphURL,
+			Credentials: map[string]string{
+				"tenant_id":     env.SharePointTenantID,
+				"client_id":     env.SharePointClientID,
+				"client_secret": env.SharePointClientSecret,
+			},
+			Options: map[string]string{
+				"sites":      env.SharePointSites,
+				"link_scope": env.SharePointLinkScope,
+				"token_url":  env.SharePointTokenURL,
+			},
+		})
+	}
+	if env.LocalRoots != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:    repository.LOCAL.String(),
+			Type:    repository.LOCAL.String(),
+			Options: map[string]string{"roots": env.LocalRoots},
+		})
+	}
+	if env.SFTPHostAddr != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:     repository.SFTP.String(),
+			Type:     repository.SFTP.String(),
+			Endpoint: env.SFTPHostAddr,
+			Credentials: map[string]string{
+				"user":                   env.SFTPUser,
+				"password":               env.SFTPPassword,
+				"private_key":            env.SFTPPrivateKey,
+				"private_key_passphrase": env.SFTPPrivateKeyPassphrase,
+			},
+			Options: map[string]string{
+				"known_hosts":              env.SFTPKnownHosts,
+				"insecure_ignore_host_key": strconv.FormatBool(env.SFTPInsecureIgnoreHostKey),
+				"roots":                    env.SFTPRoots,
+			},
+		})
+	}
+	if env.AzureAccountName != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:     repository.AZURE.String(),
+			Type:     repository.AZURE.String(),
+			Endpoint: env.AzureServiceURL,
+			Credentials: map[string]string{
+				"account_name": env.AzureAccountName,
+				"account_key":  env.AzureAccountKey,
+			},
+		})
+	}
+	if env.GCSProjectID != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:        repository.GCS.String(),
+			Type:        repository.GCS.String(),
+			Endpoint:    env.GCSEndpoint,
+			Credentials: map[string]string{"credentials_file": env.GCSCredentialsFile},
+			Options:     map[string]string{"project_id": env.GCSProjectID},
+		})
+	}
+	if env.WebDAVURL != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:     repository.WEBDAV.String(),
+			Type:     repository.WEBDAV.String(),
+			Endpoint: env.WebDAVURL,
+			Credentials: map[string]string{
+				"user":     env.WebDAVUser,
+				"password": env.WebDAVPassword,
+			},
+			Options: map[string]string{"roots": env.WebDAVRoots},
+		})
+	}
+	if env.MemoryStores != "" {
+		connections = append(connections, domain.ConnectionConfig{
+			Name:        repository.MEMORY.String(),
+			Type:        repository.MEMORY.String(),
+			Endpoint:    env.MemoryPublicURL,
+			Credentials: map[string]string{"signing_key": env.MemorySigningKey},
+			Options:     map[string]string{"stores": env.MemoryStores},
+		})
+	}
+	return connections
+}
+
+// hubURL is the address under which this hub is reachable by default.
+func hubURL(env *Env) string {
+	host := env.Host
+	if host == "" {
+		host = "localhost"
+	}
+	port := env.Port
+	if port == "" {
+		port = "80"
+	}
+	return "http://" + host + ":" + port
+}
@@ -0,0 +1,84 @@
+package bootstrap
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// connectionsFile writes content to a connections file and returns its path.
+func connectionsFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "connections.yaml")
+	if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestLoadConnections(t *testing.T) {
+	env := &Env{
+		Host:         "hub.test",
+		Port:         "8080",
+		S3HostAddr:   "http://legacy-s3:9000",
+		MemoryStores: "legacy",
+		ConnectionsFile: connectionsFile(t, `
+connections:
+  - name: memory
+    type: memory
+    options:
+      stores: files
+  - name: lab
+    type: s3
+    endpoint: http://lab:9000
+`),
+	}
+	connections, err := loadConnections(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, connection := range connections {
+		names = append(names, connection.Name)
+		if connection.HubURL != "http://hub.test:8080" {
+			t.Errorf("connection %v has the hub URL %q", connection.Name, connection.HubURL)
+		}
+	}
+	if got := strings.Join(names, ","); got != "memory,lab,s3" {
+		t.Fatalf("loaded the connections %v, want memory,lab,s3", got)
+	}
+	// the connections file overrides the memory variables, the s3 variables are added
+	if stores := connections[0].Option("stores"); stores != "files" {
+		t.Errorf("memory has the stores %q, want files", stores)
+	}
+	if connections[2].Type != "s3" || connections[2].Endpoint != "http://legacy-s3:9000" {
+		t.Errorf("unexpected legacy connection %+v", connections[2])
+	}
+}
+
+func TestLoadConnectionsRejects(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		connections string
+		env         Env
+		want        string
+	}{
+		{"unknown type", "connections:\n  - {name: a, type: floppy}", Env{}, "unknown type"},
+		{"duplicate name", "connections:\n  - {name: a, type: memory}\n  - {name: a, type: s3}", Env{}, "more than once"},
+		{"invalid name", "connections:\n  - {name: a/b, type: memory}", Env{}, "invalid or reserved"},
+		{"support", "connections:\n  - {name: support, type: memory}", Env{}, "invalid or reserved"},
+		{"connections", "connections:\n  - {name: connections, type: memory}", Env{}, "invalid or reserved"},
+		{"copy", "connections:\n  - {name: copy, type: memory}", Env{}, "invalid or reserved"},
+		{"move", "connections:\n  - {name: move, type: memory}", Env{}, "invalid or reserved"},
+	} {
+		env := test.env
+		env.ConnectionsFile = connectionsFile(t, test.connections)
+		if _, err := loadConnections(&env); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%v: got %v, want an error containing %q", test.name, err, test.want)
+		}
+	}
+	if _, err := loadConnections(&Env{ConnectionsFile: filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("loaded a missing connections file")
+	}
+}
@@ -6,13 +6,63 @@ import (
 )
 
 type Env struct {
-	AppEnv      string `mapstructure:"APP_ENV"`
-	Host        string `mapstructure:"HOST"`
-	Port        string `mapstructure:"PORT"`
-	S3HostAddr  string `mapstructure:"S3_HOST_ADDR"`
-	S3AccessKey string `mapstructure:"S3_ACCESS_KEY"`
-	S3SecretKey string `mapstructure:"S3_SECRET_KEY"`
-	S3Region    string `mapstructure:"S3_REGION"`
+	AppEnv              string `mapstructure:"APP_ENV"`
+	Host                string `mapstructure:"HOST"`
+	Port                string `mapstructure:"PORT"`
+	S3HostAddr          string `mapstructure:"S3_HOST_ADDR"`
+	S3AccessKey         string `mapstructure:"S3_ACCESS_KEY"`
+	S3SecretKey         string `mapstructure:"S3_SECRET_KEY"`
+	S3Region            string `mapstructure:"S3_REGION"`
+	S3PartSizeMB        string `mapstructure:"S3_PART_SIZE_MB"`
+	S3UploadConcurrency string `mapstructure:"S3_UPLOAD_CONCURRENCY"`
+	FTPHostAddr         string `mapstructure:"FTP_HOST_ADDR"`
+	FTPUser             string `mapstructure:"FTP_USER"`
+	FTPPassword         string `mapstructure:"FTP_PASSWORD"`
+	FTPRoots            string `mapstructure:"FTP_ROOTS"`
+	FTPTLS              string `mapstructure:"FTP_TLS"`
+
+	SharePointTenantID     string `mapstructure:"SHAREPOINT_TENANT_ID"`
+	SharePointClientID     string `mapstructure:"SHAREPOINT_CLIENT_ID"`
+	SharePointClientSecret string `mapstructure:"SHAREPOINT_CLIENT_SECRET"`
+	SharePointSites        string `mapstructure:"SHAREPOINT_SITES"`
+	SharePointLinkScope    string `mapstructure:"SHAREPOINT_LINK_SCOPE"`
+	SharePointGraphURL     string `mapstructure:"SHAREPOINT_GRAPH_URL"`
+	SharePointTokenURL     string `mapstructure:"SHAREPOINT_TOKEN_URL"`
+
+	LocalRoots string `mapstructure:"LOCAL_ROOTS"`
+
+	SFTPHostAddr              string `mapstructure:"SFTP_HOST_ADDR"`
+	SFTPUser                  string `mapstructure:"SFTP_USER"`
+	SFTPPassword              string `mapstructure:"SFTP_PASSWORD"`
+	SFTPPrivateKey            string `mapstructure:"SFTP_PRIVATE_KEY"`
+	SFTPPrivateKeyPassphrase  string `mapstructure:"SFTP_PRIVATE_KEY_PASSPHRASE"`
+	SFTPKnownHosts            string `mapstructure:"SFTP_KNOWN_HOSTS"`
+	SFTPInsecureIgnoreHostKey bool   `mapstructure:"SFTP_INSECURE_IGNORE_HOST_KEY"`
+	SFTPRoots                 string `mapstructure:"SFTP_ROOTS"`
+
+	AzureAccountName string `mapstructure:"AZURE_ACCOUNT_NAME"`
+	AzureAccountKey  string `mapstructure:"AZURE_ACCOUNT_KEY"`
+	AzureServiceURL  string `mapstructure:"AZURE_SERVICE_URL"`
+
+	GCSProjectID       string `mapstructure:"GCS_PROJECT_ID"`
+	GCSCredentialsFile string `mapstructure:"GCS_CREDENTIALS_FILE"`
+	GCSEndpoint        string `mapstructure:"GCS_ENDPOINT"`
+
+	WebDAVURL      string `mapstructure:"WEBDAV_URL"`
+	WebDAVUser     string `mapstructure:"WEBDAV_USER"`
+	WebDAVPassword string `mapstructure:"WEBDAV_PASSWORD"`
+	WebDAVRoots    string `mapstructure:"WEBDAV_ROOTS"`
+
+	MemoryStores     string `mapstructure:"MEMORY_STORES"`
+	MemoryPublicURL  string `mapstructure:"MEMORY_PUBLIC_URL"`
+	MemorySigningKey string `mapstructure:"MEMORY_SIGNING_KEY"`
+
+	ConnectionsFile string `mapstructure:"CONNECTIONS_FILE"`
+
+	MultipartStagingDir string `mapstructure:"MULTIPART_STAGING_DIR"`
+	TusUploadDir        string `mapstructure:"TUS_UPLOAD_DIR"`
+	TusExpirationHours  int    `mapstructure:"TUS_EXPIRATION_HOURS"`
+	MoveJournalDir      string `mapstructure:"MOVE_JOURNAL_DIR"`
 }
 
 func NewEnv() *Env {
@@ -5,6 +5,7 @@ import (
 	"github.com/nevcodia/smarthub/api/route"
 	"github.com/nevcodia/smarthub/bootstrap"
 	"github.com/nevcodia/smarthub/middleware"
+	_ "github.com/nevcodia/smarthub/repository" // registers the storage types
 	"io"
 	"os"
 )
@@ -20,11 +21,9 @@ func main() {
 
 	env := app.Env
 
-	s3Client := app.S3
-
 	server := gin.New()
 	server.Use(gin.Recovery(), middleware.Logger())
-	route.Setup(env, s3Client, server)
+	route.Setup(app, server)
 
 	var serverAddress string
 	if env.Host != "" {
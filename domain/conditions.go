@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"strings"
+	"time"
+)
+
+// Conditions are the preconditions of a conditional read, as sent in the If-Match, If-None-Match,
+// If-Modified-Since and If-Unmodified-Since headers. Empty fields are not checked.
+type Conditions struct {
+	IfMatch           string
+	IfNoneMatch       string
+	IfModifiedSince   time.Time
+	IfUnmodifiedSince time.Time
+}
+
+// NotModifiedError is the ErrNotModified of an object, it keeps the validators a 304 Not Modified
+// response has to repeat.
+type NotModifiedError struct {
+	Object StorageObject
+}
+
+func (e *NotModifiedError) Error() string {
+	return ErrNotModified.Error()
+}
+
+func (e *NotModifiedError) Is(target error) bool {
+	return target == ErrNotModified
+}
+
+// Check evaluates the conditions against object in the order of RFC 9110, section 13.2.2.
+// It returns ErrPreconditionFailed, or a NotModifiedError if the object must not be read.
+func (c *Conditions) Check(object StorageObject) error {
+	if c == nil {
+		return nil
+	}
+	// HTTP dates carry whole seconds only
+	lastModified := time.UnixMilli(object.LastModified).Truncate(time.Second)
+	if c.IfMatch != "" {
+		if !matchesETag(c.IfMatch, object.ETag, false) {
+			return ErrPreconditionFailed
+		}
+	} else if !c.IfUnmodifiedSince.IsZero() && lastModified.After(c.IfUnmodifiedSince) {
+		return ErrPreconditionFailed
+	}
+	if c.IfNoneMatch != "" {
+		if matchesETag(c.IfNoneMatch, object.ETag, true) {
+			return &NotModifiedError{Object: object}
+		}
+	} else if !c.IfModifiedSince.IsZero() && !lastModified.After(c.IfModifiedSince) {
+		return &NotModifiedError{Object: object}
+	}
+	return nil
+}
+
+// matchesETag reports whether etag is one of the comma separated entity tags in header, or header is "*".
+// A weak comparison ignores the W/ prefix, a strong one never matches weak tags.
+func matchesETag(header string, etag string, weak bool) bool {
+	if strings.TrimSpace(header) == "*" {
+		return etag != ""
+	}
+	etag, etagWeak := normalizeETag(etag)
+	if etag == "" || etagWeak && !weak {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate, candidateWeak := normalizeETag(candidate)
+		if candidateWeak && !weak {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeETag strips the quotes and the weakness indicator off an entity tag. Some storage
+// services hand out unquoted tags, which compare equal to their quoted form.
+func normalizeETag(etag string) (string, bool) {
+	etag = strings.TrimSpace(etag)
+	weak := strings.HasPrefix(etag, "W/")
+	etag = strings.TrimPrefix(etag, "W/")
+	return strings.Trim(etag, `"`), weak
+}
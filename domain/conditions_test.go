@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConditionsCheck(t *testing.T) {
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// the object was modified within the second of modified
+	object := StorageObject{ETag: `"abc"`, LastModified: modified.Add(300 * time.Millisecond).UnixMilli()}
+	before, after := modified.Add(-time.Second), modified.Add(time.Second)
+	for _, test := range []struct {
+		name       string
+		conditions *Conditions
+		object     StorageObject
+		want       error
+	}{
+		{"nil conditions", nil, object, nil},
+		{"no conditions", &Conditions{}, object, nil},
+		{"If-Match", &Conditions{IfMatch: `"other", "abc"`}, object, nil},
+		{"If-Match other", &Conditions{IfMatch: `"other"`}, object, ErrPreconditionFailed},
+		{"If-Match any", &Conditions{IfMatch: "*"}, object, nil},
+		{"If-Match any without an entity tag", &Conditions{IfMatch: "*"}, StorageObject{}, ErrPreconditionFailed},
+		{"If-Match weak", &Conditions{IfMatch: `W/"abc"`}, object, ErrPreconditionFailed},
+		{"If-Match of a weak tag", &Conditions{IfMatch: `"abc"`}, StorageObject{ETag: `W/"abc"`}, ErrPreconditionFailed},
+		{"If-Match unquoted tag", &Conditions{IfMatch: `"abc"`}, StorageObject{ETag: "abc"}, nil},
+		{"If-Unmodified-Since", &Conditions{IfUnmodifiedSince: modified}, object, nil},
+		{"If-Unmodified-Since before", &Conditions{IfUnmodifiedSince: before}, object, ErrPreconditionFailed},
+		{"If-None-Match", &Conditions{IfNoneMatch: `"abc"`}, object, ErrNotModified},
+		{"If-None-Match weak", &Conditions{IfNoneMatch: `W/"abc"`}, object, ErrNotModified},
+		{"If-None-Match other", &Conditions{IfNoneMatch: `"other"`}, object, nil},
+		{"If-None-Match any", &Conditions{IfNoneMatch: "*"}, object, ErrNotModified},
+		{"If-Modified-Since", &Conditions{IfModifiedSince: modified}, object, ErrNotModified},
+		{"If-Modified-Since after", &Conditions{IfModifiedSince: after}, object, ErrNotModified},
+		{"If-Modified-Since before", &Conditions{IfModifiedSince: before}, object, nil},
+
+		// If-Match takes the place of If-Unmodified-Since
+		{"If-Match over If-Unmodified-Since", &Conditions{IfMatch: `"abc"`, IfUnmodifiedSince: before}, object, nil},
+		{"failed If-Match over If-Unmodified-Since", &Conditions{IfMatch: `"other"`, IfUnmodifiedSince: after}, object, ErrPreconditionFailed},
+		// If-None-Match takes the place of If-Modified-Since
+		{"If-None-Match over If-Modified-Since", &Conditions{IfNoneMatch: `"other"`, IfModifiedSince: after}, object, nil},
+		{"matching If-None-Match over If-Modified-Since", &Conditions{IfNoneMatch: `"abc"`, IfModifiedSince: before}, object, ErrNotModified},
+		// preconditions fail before the object counts as not modified
+		{"If-Match before If-None-Match", &Conditions{IfMatch: `"other"`, IfNoneMatch: `"abc"`}, object, ErrPreconditionFailed},
+		{"If-Unmodified-Since before If-None-Match", &Conditions{IfUnmodifiedSince: before, IfNoneMatch: `"abc"`}, object, ErrPreconditionFailed},
+		{"If-Unmodified-Since before If-Modified-Since", &Conditions{IfUnmodifiedSince: before, IfModifiedSince: after}, object, ErrPreconditionFailed},
+		{"If-Match and If-None-Match", &Conditions{IfMatch: `"abc"`, IfNoneMatch: `"abc"`}, object, ErrNotModified},
+		{"all passing", &Conditions{IfMatch: `"abc"`, IfUnmodifiedSince: after, IfNoneMatch: `"other"`, IfModifiedSince: before}, object, nil},
+	} {
+		got := test.conditions.Check(test.object)
+		if !errors.Is(got, test.want) || (got == nil) != (test.want == nil) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+		var notModified *NotModifiedError
+		if errors.As(got, &notModified) && (notModified.Object.ETag != test.object.ETag || notModified.Object.LastModified != test.object.LastModified) {
+			t.Errorf("%v: not modified %+v, want %+v", test.name, notModified.Object, test.object)
+		}
+	}
+}
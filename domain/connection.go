@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Connection is a named, configured storage account, e.g. "s3-prod-eu".
+type Connection struct {
+	Name       string            `json:"name"`
+	Type       StorageType       `json:"type"`
+	Repository StorageRepository `json:"-"`
+}
+
+// ConnectionConfig describes one named storage connection. Credentials and Options hold the
+// type specific settings, e.g. "access_key" for S3 or "roots" for FTP.
+type ConnectionConfig struct {
+	Name        string            `mapstructure:"name"`
+	Type        string            `mapstructure:"type"`
+	Endpoint    string            `mapstructure:"endpoint"`
+	Region      string            `mapstructure:"region"`
+	Credentials map[string]string `mapstructure:"credentials"`
+	Options     map[string]string `mapstructure:"options"`
+	// HubURL is the address under which the hub itself is reachable, for storage types whose
+	// links point back at the hub.
+	HubURL string `mapstructure:"-"`
+}
+
+func (c ConnectionConfig) Credential(key string) string {
+	return c.Credentials[key]
+}
+
+func (c ConnectionConfig) Option(key string) string {
+	return c.Options[key]
+}
+
+// BoolOption is false for a missing option.
+func (c ConnectionConfig) BoolOption(key string) (bool, error) {
+	value := c.Options[key]
+	if value == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("option %v must be a boolean, got: %v", key, value)
+	}
+	return enabled, nil
+}
+
+// IntOption is fallback for a missing option.
+func (c ConnectionConfig) IntOption(key string, fallback int) (int, error) {
+	value := c.Options[key]
+	if value == "" {
+		return fallback, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("option %v must be a number, got: %v", key, value)
+	}
+	return number, nil
+}
@@ -0,0 +1,27 @@
+package domain
+
+import "errors"
+
+var ErrNotSupported = errors.New("operation is not supported by this storage type")
+
+var ErrPresignInvalid = errors.New("presigned link is invalid or expired")
+
+var ErrPageTokenInvalid = errors.New("page token is invalid")
+
+var ErrNotModified = errors.New("object has not been modified")
+
+var ErrPreconditionFailed = errors.New("object does not meet the preconditions")
+
+var ErrUploadNotFound = errors.New("upload does not exist")
+
+var ErrOffsetMismatch = errors.New("upload offset does not match")
+
+var ErrChecksumMismatch = errors.New("checksum does not match")
+
+var ErrUploadTooLarge = errors.New("upload exceeds its length")
+
+var ErrCopyMismatch = errors.New("copy does not match its source")
+
+var ErrPrefixRequired = errors.New("prefix is required, set all to delete the whole store")
+
+var ErrMoveNotFound = errors.New("move does not exist")
@@ -0,0 +1,56 @@
+package domain
+
+import "io"
+
+// MultipartUpload is an upload in progress, which is assembled from numbered parts once completed.
+type MultipartUpload struct {
+	UploadID  string `json:"upload_id"`
+	StoreName string `json:"store_name"`
+	Key       string `json:"key"`
+}
+
+// UploadedPart is a part of a multipart upload. Completing an upload only needs PartNumber and ETag.
+type UploadedPart struct {
+	PartNumber   int32  `json:"part_number"`
+	ETag         string `json:"etag"`
+	Size         int64  `json:"size,omitempty"`
+	LastModified int64  `json:"last_modified,omitempty"`
+}
+
+// The part numbers a multipart upload accepts.
+const (
+	MinPartNumber = 1
+	MaxPartNumber = 10000
+)
+
+// MultipartUploader is implemented by repositories which upload objects in parts natively.
+// The hub emulates it for all others. Parts may be uploaded in any order and again, the last
+// upload of a part number wins. A size of -1 stands for a part of unknown size.
+type MultipartUploader interface {
+	CreateMultipartUpload(params *ObjectParams, metadata map[string]string) (MultipartUpload, error)
+	UploadPart(params *ObjectParams, uploadID string, partNumber int32, body io.Reader, size int64) (UploadedPart, error)
+	ListParts(params *ObjectParams, uploadID string) ([]UploadedPart, error)
+	CompleteMultipartUpload(params *ObjectParams, uploadID string, parts []UploadedPart) (StorageObject, error)
+	AbortMultipartUpload(params *ObjectParams, uploadID string) error
+}
+
+// PresignedPart is a link a client uploads the part with the given size to with PUT.
+type PresignedPart struct {
+	PartNumber int32  `json:"part_number"`
+	Size       int64  `json:"size"`
+	URL        string `json:"url"`
+}
+
+// PresignedMultipartUpload is a multipart upload whose parts go straight to the storage. Part n
+// holds the bytes from (n-1)*PartSize on.
+type PresignedMultipartUpload struct {
+	MultipartUpload
+	PartSize int64           `json:"part_size"`
+	Parts    []PresignedPart `json:"parts"`
+}
+
+// MultipartPresigner is implemented by repositories which presign the upload of the parts of a
+// native multipart upload. The expiration time is given in milliseconds.
+type MultipartPresigner interface {
+	PresignUploadPart(params *ObjectParams, uploadID string, partNumber int32, exp uint) (string, error)
+}
@@ -3,4 +3,10 @@ package domain
 type ObjectParams struct {
 	StoreName string `json:"store_name"`
 	Key       string `json:"key"`
+	// Conditions restrict reads of the object, nil reads it unconditionally.
+	Conditions *Conditions `json:"-"`
+	// VersionID selects a version of the object, empty selects its current version.
+	VersionID string `json:"version_id,omitempty"`
+	// ContentType is stored with an upload by backends which keep one, empty derives it from the key.
+	ContentType string `json:"-"`
 }
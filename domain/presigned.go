@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"io"
+	"net/url"
+)
+
+// HubPresigner is implemented by repositories without a presigning mechanism of their own.
+// Their presigned links point back at the hub, which hands the request over to these methods.
+type HubPresigner interface {
+	OpenPresigned(query url.Values) (ObjectContent, error)
+	UploadPresigned(query url.Values, file io.Reader) (StorageObject, error)
+}
+
+// PostPolicy restricts the uploads of a presigned POST. Either Key or KeyPrefix is set, a prefix
+// leaves the rest of the key to the form. Sizes are in bytes, a MaxSize of 0 doesn't restrict the
+// size. The expiration time is given in milliseconds.
+type PostPolicy struct {
+	Key               string
+	KeyPrefix         string
+	ContentType       string
+	ContentTypePrefix string
+	MinSize           int64
+	MaxSize           int64
+	Metadata          map[string]string
+	Expiration        uint
+}
+
+// PresignedPost is an upload through an HTML form. The form posts the Fields to URL as
+// multipart/form-data, followed by the file in a field named "file".
+type PresignedPost struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+// PostPresigner is implemented by repositories which presign uploads through HTML forms.
+type PostPresigner interface {
+	PresignPost(storeName string, policy PostPolicy) (PresignedPost, error)
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// StorageFactory connects to the storage described by config.
+type StorageFactory func(config ConnectionConfig) (StorageRepository, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = map[StorageType]StorageFactory{}
+)
+
+// RegisterStorage makes a storage type available to connections. Backends call it from init,
+// registering the same type twice panics.
+func RegisterStorage(storageType StorageType, factory StorageFactory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	if factory == nil {
+		panic("storage factory for " + storageType.String() + " is nil")
+	}
+	if _, registered := factories[storageType]; registered {
+		panic("storage type " + storageType.String() + " is registered twice")
+	}
+	factories[storageType] = factory
+}
+
+// StorageTypes lists the registered storage types in alphabetical order.
+func StorageTypes() []StorageType {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+	types := make([]StorageType, 0, len(factories))
+	for storageType := range factories {
+		types = append(types, storageType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// NewStorageRepository connects to the storage described by config with the factory of its type.
+func NewStorageRepository(config ConnectionConfig) (StorageRepository, error) {
+	factoriesMutex.RLock()
+	factory, registered := factories[StorageType(config.Type)]
+	factoriesMutex.RUnlock()
+	if !registered {
+		return nil, fmt.Errorf("connection %v has an unknown type %q", config.Name, config.Type)
+	}
+	repository, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("connection %v: %w", config.Name, err)
+	}
+	return repository, nil
+}
@@ -0,0 +1,77 @@
+package domain
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type registeredRepository struct {
+	StorageRepository
+}
+
+// panicMessage returns what register panicked with, or "" if it didn't panic.
+func panicMessage(register func()) (message string) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			message = recovered.(string)
+		}
+	}()
+	register()
+	return ""
+}
+
+func TestRegisterStorage(t *testing.T) {
+	factory := func(config ConnectionConfig) (StorageRepository, error) {
+		if config.Option("fail") != "" {
+			return nil, errors.New("option fail is set")
+		}
+		return registeredRepository{}, nil
+	}
+	RegisterStorage("test-b", factory)
+	RegisterStorage("test-a", factory)
+
+	if message := panicMessage(func() { RegisterStorage("test-a", factory) }); !strings.Contains(message, "registered twice") {
+		t.Fatalf("registering test-a twice panicked with %q", message)
+	}
+	if message := panicMessage(func() { RegisterStorage("test-nil", nil) }); !strings.Contains(message, "is nil") {
+		t.Fatalf("registering a nil factory panicked with %q", message)
+	}
+	if got := StorageTypeFromValue("test-nil"); got != "unknown" {
+		t.Fatalf("the nil factory was registered as %v", got)
+	}
+
+	var registered []string
+	for _, storageType := range StorageTypes() {
+		if strings.HasPrefix(storageType.String(), "test-") {
+			registered = append(registered, storageType.String())
+		}
+	}
+	if got := strings.Join(registered, ","); got != "test-a,test-b" {
+		t.Fatalf("listed the types %v, want test-a,test-b", got)
+	}
+
+	for _, test := range []struct {
+		value string
+		want  StorageType
+	}{
+		{"test-a", "test-a"},
+		{"test-c", "unknown"},
+		{"TEST-A", "unknown"},
+		{"", "unknown"},
+	} {
+		if got := StorageTypeFromValue(test.value); got != test.want {
+			t.Errorf("StorageTypeFromValue(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+
+	if repository, err := NewStorageRepository(ConnectionConfig{Name: "a", Type: "test-a"}); err != nil || repository == nil {
+		t.Fatalf("got %v, %v", repository, err)
+	}
+	if _, err := NewStorageRepository(ConnectionConfig{Name: "a", Type: "test-a", Options: map[string]string{"fail": "yes"}}); err == nil || !strings.HasPrefix(err.Error(), "connection a: ") {
+		t.Fatalf("got %v for a failing factory", err)
+	}
+	if _, err := NewStorageRepository(ConnectionConfig{Name: "c", Type: "test-c"}); err == nil {
+		t.Fatal("connected with an unknown type")
+	}
+}
@@ -14,3 +14,71 @@ type ObjectMovementRequest struct {
 	DestinationStoreName string `json:"destination_store_name"`
 	DestinationKey       string `json:"destination_key"`
 }
+
+type CopyAllRequest struct {
+	SourceStoreName string `json:"source_store_name"`
+	SourcePath      string `json:"source_path"`
+	TargetStoreName string `json:"target_store_name"`
+	TargetPath      string `json:"target_path"`
+	SkipIdentical   bool   `json:"skip_identical"`
+}
+
+type MoveAllRequest struct {
+	SourceStoreName string `json:"source_store_name"`
+	SourcePath      string `json:"source_path"`
+	TargetStoreName string `json:"target_store_name"`
+	TargetPath      string `json:"target_path"`
+}
+
+type RestoreVersionRequest struct {
+	StoreName string `json:"store_name"`
+	Key       string `json:"key"`
+	VersionID string `json:"version_id"`
+}
+
+type VersioningRequest struct {
+	StoreName string `json:"store_name"`
+	Enabled   bool   `json:"enabled"`
+}
+
+type TransferRequest struct {
+	SourceConnection      string `json:"source_connection"`
+	SourceStoreName       string `json:"source_store_name"`
+	SourceKey             string `json:"source_key"`
+	DestinationConnection string `json:"destination_connection"`
+	DestinationStoreName  string `json:"destination_store_name"`
+	DestinationKey        string `json:"destination_key"`
+}
+
+type CreateMultipartUploadRequest struct {
+	StoreName string            `json:"store_name"`
+	Key       string            `json:"key"`
+	Metadata  map[string]string `json:"metadata"`
+}
+
+type CompleteMultipartUploadRequest struct {
+	StoreName string         `json:"store_name"`
+	Key       string         `json:"key"`
+	Parts     []UploadedPart `json:"parts"`
+}
+
+type PresignMultipartUploadRequest struct {
+	StoreName      string            `json:"store_name"`
+	Key            string            `json:"key"`
+	Metadata       map[string]string `json:"metadata"`
+	Size           int64             `json:"size"`
+	PartSize       int64             `json:"part_size"`
+	ExpirationTime uint              `json:"exp"`
+}
+
+type PresignPostRequest struct {
+	StoreName         string            `json:"store_name"`
+	Key               string            `json:"key"`
+	KeyPrefix         string            `json:"key_prefix"`
+	ContentType       string            `json:"content_type"`
+	ContentTypePrefix string            `json:"content_type_prefix"`
+	MinSize           int64             `json:"min_size"`
+	MaxSize           int64             `json:"max_size"`
+	Metadata          map[string]string `json:"metadata"`
+	ExpirationTime    uint              `json:"exp"`
+}
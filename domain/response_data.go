@@ -1,11 +1,75 @@
 package domain
 
-type DownloadFileResponse struct {
-	Filename    string
-	Type        string
-	Disposition string
-}
-
 type ErrorResponse struct {
 	Message string `json:"message"`
 }
+
+// Outcomes of a single key in a bulk operation.
+const (
+	StatusDeleted = "deleted"
+	StatusDryRun  = "dry_run"
+	StatusCopied  = "copied"
+	StatusMoved   = "moved"
+	StatusSkipped = "skipped"
+	StatusFailed  = "failed"
+)
+
+type KeyResult struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type DeleteAllReport struct {
+	StoreName string      `json:"store_name"`
+	Prefix    string      `json:"prefix"`
+	DryRun    bool        `json:"dry_run"`
+	Deleted   int         `json:"deleted"`
+	Failed    int         `json:"failed"`
+	Results   []KeyResult `json:"results"`
+}
+
+type CopyResult struct {
+	SourceKey string `json:"source_key"`
+	TargetKey string `json:"target_key"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type CopyAllReport struct {
+	SourceStoreName string       `json:"source_store_name"`
+	SourcePath      string       `json:"source_path"`
+	TargetStoreName string       `json:"target_store_name"`
+	TargetPath      string       `json:"target_path"`
+	Copied          int          `json:"copied"`
+	Skipped         int          `json:"skipped"`
+	Failed          int          `json:"failed"`
+	Results         []CopyResult `json:"results"`
+}
+
+// States of a move of all objects below a path.
+const (
+	MoveRunning     = "running"
+	MoveInterrupted = "interrupted"
+	MoveFailed      = "failed"
+	MoveCompleted   = "completed"
+)
+
+// MoveAllRecord is the progress record of moving all objects below a path. The record of a move
+// which didn't complete is kept, running the move again completes it. Moved counts the objects
+// moved by all runs, short of those an interrupted run moved after it last saved the record.
+// Failures lists the objects the last run couldn't move.
+type MoveAllRecord struct {
+	ID              string       `json:"id"`
+	SourceStoreName string       `json:"source_store_name"`
+	SourcePath      string       `json:"source_path"`
+	TargetStoreName string       `json:"target_store_name"`
+	TargetPath      string       `json:"target_path"`
+	Status          string       `json:"status"`
+	Moved           int          `json:"moved"`
+	Failed          int          `json:"failed"`
+	Remaining       int          `json:"remaining"`
+	Started         int64        `json:"started"`
+	Updated         int64        `json:"updated"`
+	Failures        []CopyResult `json:"failures,omitempty"`
+}
@@ -2,7 +2,7 @@ package domain
 
 import (
 	"io"
-	"mime/multipart"
+	"strings"
 )
 
 type StorageObject struct {
@@ -12,22 +12,74 @@ type StorageObject struct {
 	ETag         string            `json:"etag,omitempty"`
 	Size         int64             `json:"size,omitempty"`
 	Metadata     map[string]string `json:"metadata,omitempty"`
+	// VersionID and IsLatest are only set by storages which keep versions of their objects.
+	VersionID string `json:"version_id,omitempty"`
+	IsLatest  bool   `json:"is_latest,omitempty"`
+}
+
+// FolderPath turns path into the prefix of a folder, an empty path stays the whole store. Keys
+// mapped from one folder to another thereby match and keep their separator: "a/b" neither matches
+// "a/bc/x" nor maps "a/b/x" to "targetx".
+func FolderPath(path string) string {
+	path = strings.TrimLeft(path, "/")
+	if path != "" && !strings.HasSuffix(path, "/") {
+		path += "/"
+	}
+	return path
+}
+
+// Folder is a common prefix of the keys found at one level of a delimited listing.
+// Size sums up all objects below the prefix and is only filled in on request.
+type Folder struct {
+	Prefix string `json:"prefix"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size,omitempty"`
+}
+
+// ObjectPage is one page of a listing. NextToken is opaque and continues the listing after this
+// page as long as IsTruncated is set. Folders are only listed by Browse.
+type ObjectPage struct {
+	Folders     []Folder        `json:"folders,omitempty"`
+	Objects     []StorageObject `json:"objects"`
+	IsTruncated bool            `json:"is_truncated"`
+	NextToken   string          `json:"next_token,omitempty"`
+}
+
+// ByteRange selects Length bytes of an object starting at Offset.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// ObjectContent is an object opened for reading. The caller has to close its Body, which only holds
+// the selected bytes if the object was opened with a ByteRange. Object always describes the whole object.
+type ObjectContent struct {
+	Object      StorageObject
+	ContentType string
+	Body        io.ReadCloser
 }
 
 type StorageRepository interface {
 	StoreNames() ([]string, error)
-	Objects(storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]StorageObject, error)
-	ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]StorageObject, error)
+	Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (ObjectPage, error)
+	ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (ObjectPage, error)
+	Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (ObjectPage, error)
 	GetObject(params *ObjectParams) (StorageObject, error)
 	Upload(params *ObjectParams, metadata map[string]string, file io.Reader) (StorageObject, error)
-	UploadMultiPart(params *ObjectParams, metadata map[string]string, fileHeader *multipart.FileHeader) (StorageObject, error)
 	PresignUploadLink(params *ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error)
-	Download(params *ObjectParams) (DownloadFileResponse, error)
+	Open(params *ObjectParams, byteRange *ByteRange) (ObjectContent, error)
 	PresignDownloadLink(params *ObjectParams) (string, error)
 	PresignDownloadLinkWithExpTime(params *ObjectParams, exp uint) (string, error)
-	DeleteAll(storeName string, pathPrefix string) (bool, error)
+	DeleteAll(storeName string, pathPrefix string, dryRun bool) (DeleteAllReport, error)
 	Delete(params *ObjectParams) (bool, error)
 	Copy(current *ObjectParams, destination *ObjectParams) (StorageObject, error)
-	CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) ([]StorageObject, error)
+	CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (CopyAllReport, error)
 	Move(current *ObjectParams, destination *ObjectParams) (StorageObject, error)
 }
+
+// ServerSideCopier is implemented by repositories which can copy the objects of another connection
+// server side, e.g. of another S3 connection with the same credentials. CopyFrom returns
+// ErrNotSupported if it can't copy from source, whose object is streamed over then.
+type ServerSideCopier interface {
+	CopyFrom(source StorageRepository, current *ObjectParams, destination *ObjectParams) (StorageObject, error)
+}
@@ -0,0 +1,18 @@
+package domain
+
+import "testing"
+
+func TestFolderPath(t *testing.T) {
+	for path, want := range map[string]string{
+		"":       "",
+		"/":      "",
+		"a":      "a/",
+		"/a/b":   "a/b/",
+		"a/b/":   "a/b/",
+		"//a/b/": "a/b/",
+	} {
+		if got := FolderPath(path); got != want {
+			t.Errorf("FolderPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -1,26 +1,19 @@
 package domain
 
+// StorageType names a kind of storage. Backends declare their own type and register it with
+// RegisterStorage.
 type StorageType string
 
-const (
-	S3         StorageType = "s3"
-	FTP        StorageType = "ftp"
-	SHAREPOINT StorageType = "sharepoint"
-)
-
 func (s StorageType) String() string {
 	return string(s)
 }
 
+// StorageTypeFromValue returns the registered storage type named v, or "unknown".
 func StorageTypeFromValue(v string) StorageType {
-	switch v {
-	case "s3":
-		return S3
-	case "ftp":
-		return FTP
-	case "sharepoint":
-		return SHAREPOINT
-	default:
-		return "unknown"
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+	if _, registered := factories[StorageType(v)]; registered {
+		return StorageType(v)
 	}
+	return "unknown"
 }
@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"time"
+)
+
+// TusVersion is the version of the tus resumable upload protocol the hub speaks.
+const TusVersion = "1.0.0"
+
+// TusUpload is an upload of the tus protocol. It's committed to the storage as soon as all of
+// its Length bytes have arrived, Object describes the result then.
+type TusUpload struct {
+	ID        string            `json:"id"`
+	StoreName string            `json:"store_name"`
+	Key       string            `json:"key"`
+	Length    int64             `json:"length"`
+	Offset    int64             `json:"offset"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Expires   time.Time         `json:"expires"`
+	Object    *StorageObject    `json:"object,omitempty"`
+}
+
+// TusChecksum is the checksum a client sends along with a chunk in Upload-Checksum.
+type TusChecksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// TusChecksumAlgorithms are the algorithms the checksum extension accepts.
+var TusChecksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
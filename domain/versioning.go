@@ -0,0 +1,39 @@
+package domain
+
+// The versioning states of a store. A store which never had versioning enabled is unversioned,
+// once enabled it can only be suspended.
+const (
+	VersioningUnversioned = "unversioned"
+	VersioningEnabled     = "enabled"
+	VersioningSuspended   = "suspended"
+)
+
+// ObjectVersion is a version of an object. A delete marker is the version a delete leaves behind
+// in a versioned store, the object is hidden while its delete marker is the latest version.
+type ObjectVersion struct {
+	StorageObject
+	IsDeleteMarker bool `json:"is_delete_marker,omitempty"`
+}
+
+// VersionPage is one page of a listing of versions, ordered by key and the newest version first.
+// NextToken is opaque and continues the listing after this page as long as IsTruncated is set.
+type VersionPage struct {
+	Versions    []ObjectVersion `json:"versions"`
+	IsTruncated bool            `json:"is_truncated"`
+	NextToken   string          `json:"next_token,omitempty"`
+}
+
+// Versioning is the versioning state of a store.
+type Versioning struct {
+	StoreName string `json:"store_name"`
+	Status    string `json:"status"`
+}
+
+// Versioner is implemented by repositories which keep versions of their objects. These
+// repositories read, copy and delete the version selected by the VersionID of ObjectParams,
+// deleting a version removes it for good.
+type Versioner interface {
+	Versions(storeName string, maxVersionsPerPage int32, token string, prefix string) (VersionPage, error)
+	Versioning(storeName string) (Versioning, error)
+	SetVersioning(storeName string, enabled bool) (Versioning, error)
+}
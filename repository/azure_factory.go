@@ -0,0 +1,33 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/nevcodia/smarthub/domain"
+)
+
+// AZURE stores objects as blobs in the containers of an Azure storage account.
+const AZURE domain.StorageType = "azure"
+
+func init() {
+	domain.RegisterStorage(AZURE, newAzureStorage)
+}
+
+// newAzureStorage authenticates with the storage account key, which is also what signs SAS URLs.
+// The endpoint overrides the public service URL, e.g. http://127.0.0.1:10000/devstoreaccount1 for Azurite.
+func newAzureStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	accountName := config.Credential("account_name")
+	serviceURL := config.Endpoint
+	if serviceURL == "" {
+		serviceURL = fmt.Sprintf("https://%v.blob.core.windows.net/", accountName)
+	}
+	credential, err := azblob.NewSharedKeyCredential(accountName, config.Credential("account_key"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure credentials: %w", err)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(serviceURL, credential, nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewAzureRepository(client), nil
+}
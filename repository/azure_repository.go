@@ -0,0 +1,387 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/sas"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// UploadStream stages blocks of this size, up to azureUploadConcurrency at a time
+	azureBlockSize         = 8 << 20
+	azureUploadConcurrency = 4
+)
+
+type azureRepository struct {
+	client *azblob.Client
+}
+
+// NewAzureRepository exposes the containers of an Azure storage account as stores.
+// client must use a shared key credential, it signs the SAS URLs.
+func NewAzureRepository(client *azblob.Client) domain.StorageRepository {
+	return &azureRepository{
+		client: client,
+	}
+}
+
+func (a *azureRepository) StoreNames() ([]string, error) {
+	var containerNames []string
+	pager := a.client.NewListContainersPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Couldn't list containers for your account. Here's why: %v\n", err)
+			return nil, err
+		}
+		for _, item := range page.ContainerItems {
+			containerNames = append(containerNames, *item.Name)
+		}
+	}
+	return containerNames, nil
+}
+
+func (a *azureRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	return a.list(storeName, maxObjectsPerPage, token, prefix, false)
+}
+
+func (a *azureRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	return a.list(storeName, maxObjectsPerPage, token, prefix, true)
+}
+
+func (a *azureRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	if delimiter == "" {
+		return a.list(storeName, maxObjectsPerPage, token, prefix, false)
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	options := &container.ListBlobsHierarchyOptions{Prefix: &prefix}
+	if maxObjectsPerPage > 0 {
+		options.MaxResults = &maxObjectsPerPage
+	}
+	if token != "" {
+		options.Marker = &token
+	}
+	page := domain.ObjectPage{Objects: []domain.StorageObject{}}
+	pager := a.client.ServiceClient().NewContainerClient(storeName).NewListBlobsHierarchyPager(delimiter, options)
+	for pager.More() {
+		response, err := pager.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return domain.ObjectPage{}, err
+		}
+		for _, blobPrefix := range response.Segment.BlobPrefixes {
+			page.Folders = append(page.Folders, newFolder(prefix, *blobPrefix.Name, delimiter))
+		}
+		for _, item := range response.Segment.BlobItems {
+			page.Objects = append(page.Objects, domain.StorageObject{
+				StoreName:    storeName,
+				Key:          *item.Name,
+				LastModified: item.Properties.LastModified.UnixMilli(),
+				ETag:         string(*item.Properties.ETag),
+				Size:         *item.Properties.ContentLength,
+			})
+		}
+		if maxObjectsPerPage > 0 {
+			if response.NextMarker != nil && *response.NextMarker != "" {
+				page.IsTruncated = true
+				page.NextToken = *response.NextMarker
+			}
+			break
+		}
+	}
+	if withSizes {
+		err := addFolderSizes(&page, func(prefix string) ([]domain.StorageObject, error) {
+			folderPage, err := a.list(storeName, 0, "", prefix, false)
+			return folderPage.Objects, err
+		})
+		if err != nil {
+			return domain.ObjectPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func (a *azureRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	response, err := a.blobClient(params).GetProperties(context.TODO(), nil)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return domain.StorageObject{
+		StoreName:    params.StoreName,
+		Key:          params.Key,
+		LastModified: response.LastModified.UnixMilli(),
+		ETag:         string(*response.ETag),
+		Size:         *response.ContentLength,
+		Metadata:     fromAzureMetadata(response.Metadata),
+	}, nil
+}
+
+// Upload stages the file as blocks, several at a time, and commits the block list at the end.
+func (a *azureRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	response, err := a.client.UploadStream(context.TODO(), params.StoreName, params.Key, file, &blockblob.UploadStreamOptions{
+		BlockSize:   azureBlockSize,
+		Concurrency: azureUploadConcurrency,
+		HTTPHeaders: &blob.HTTPHeaders{BlobContentType: to.Ptr(uploadContentType(params))},
+		Metadata:    toAzureMetadata(metadata),
+	})
+	if err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	return domain.StorageObject{
+		StoreName:    params.StoreName,
+		Key:          params.Key,
+		LastModified: response.LastModified.UnixMilli(),
+		ETag:         string(*response.ETag),
+		Metadata:     metadata,
+	}, nil
+}
+
+// PresignUploadLink returns a SAS URL which allows to create and write the blob. A SAS can't
+// enforce headers, so the client has to send mimeType, metadata and "x-ms-blob-type: BlockBlob" itself.
+func (a *azureRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	url, err := a.blobClient(params).GetSASURL(sas.BlobPermissions{Create: true, Write: true},
+		time.Now().Add(time.Duration(exp*uint(time.Millisecond))), nil)
+	if err != nil {
+		log.Printf("Couldn't get a presigned request to put %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return "", err
+	}
+	return url, nil
+}
+
+func (a *azureRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	options := &azblob.DownloadStreamOptions{}
+	if byteRange != nil {
+		options.Range = azblob.HTTPRange{Offset: byteRange.Offset, Count: byteRange.Length}
+	}
+	result, err := a.client.DownloadStream(context.TODO(), params.StoreName, params.Key, options)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	object := domain.StorageObject{
+		StoreName: params.StoreName,
+		Key:       params.Key,
+		Metadata:  fromAzureMetadata(result.Metadata),
+	}
+	if result.LastModified != nil {
+		object.LastModified = result.LastModified.UnixMilli()
+	}
+	if result.ETag != nil {
+		object.ETag = string(*result.ETag)
+	}
+	if result.ContentLength != nil {
+		object.Size = *result.ContentLength
+	}
+	if byteRange != nil && result.ContentRange != nil {
+		object.Size = rangeTotal(*result.ContentRange, object.Size)
+	}
+	contentType := contentTypeOf(params.Key)
+	if result.ContentType != nil {
+		contentType = *result.ContentType
+	}
+	return domain.ObjectContent{
+		Object:      object,
+		ContentType: contentType,
+		Body:        result.Body,
+	}, nil
+}
+
+func (a *azureRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return a.PresignDownloadLinkWithExpTime(params, 15*uint(time.Minute)) //Default time 15 minute
+}
+
+func (a *azureRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	url, err := a.blobClient(params).GetSASURL(sas.BlobPermissions{Read: true},
+		time.Now().Add(time.Duration(exp*uint(time.Millisecond))), nil)
+	if err != nil {
+		log.Printf("Couldn't get a presigned request to get %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return "", err
+	}
+	return url, nil
+}
+
+func (a *azureRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	page, err := a.list(storeName, 0, "", pathPrefix, false)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	return deleteEach(storeName, pathPrefix, page.Objects, dryRun, func(key string) error {
+		_, err := a.Delete(&domain.ObjectParams{StoreName: storeName, Key: key})
+		return err
+	}), nil
+}
+
+func (a *azureRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	_, err := a.client.DeleteBlob(context.TODO(), params.StoreName, params.Key, &blob.DeleteOptions{
+		DeleteSnapshots: to.Ptr(blob.DeleteSnapshotsOptionTypeInclude),
+	})
+	if err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy starts a server side copy from a short lived SAS URL of the source and waits for it to finish.
+func (a *azureRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	err := a.copy(current, destination)
+	if err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return a.GetObject(destination)
+}
+
+func (a *azureRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		func(storeName string, prefix string) ([]domain.StorageObject, error) {
+			page, err := a.list(storeName, 0, "", prefix, false)
+			return page.Objects, err
+		},
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := a.Copy(current, destination)
+			return err
+		})
+}
+
+// Move copies the blob and deletes the source once the copy is verified, blobs can't be renamed.
+func (a *azureRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	return moveByCopy(a, current, destination, a.sameContent)
+}
+
+// sameContent compares the Content-MD5 of the copy and its source, if the source has one.
+func (a *azureRepository) sameContent(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+	source, err := a.blobClient(current).GetProperties(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	copied, err := a.blobClient(destination).GetProperties(context.TODO(), nil)
+	if err != nil {
+		return err
+	}
+	if len(source.ContentMD5) > 0 && !bytes.Equal(source.ContentMD5, copied.ContentMD5) {
+		return domain.ErrCopyMismatch
+	}
+	return nil
+}
+
+// list hands out the continuation marker of the blob service as the next token. Without
+// a page size all blobs following the token are listed.
+func (a *azureRepository) list(storeName string, maxObjectsPerPage int32, token string, prefix string, withMetadata bool) (domain.ObjectPage, error) {
+	prefix = strings.TrimLeft(prefix, "/")
+	options := &container.ListBlobsFlatOptions{
+		Include: container.ListBlobsInclude{Metadata: withMetadata},
+		Prefix:  &prefix,
+	}
+	if maxObjectsPerPage > 0 {
+		options.MaxResults = &maxObjectsPerPage
+	}
+	if token != "" {
+		options.Marker = &token
+	}
+	page := domain.ObjectPage{Objects: []domain.StorageObject{}}
+	pager := a.client.NewListBlobsFlatPager(storeName, options)
+	for pager.More() {
+		response, err := pager.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return domain.ObjectPage{}, err
+		}
+		for _, item := range response.Segment.BlobItems {
+			object := domain.StorageObject{
+				StoreName:    storeName,
+				Key:          *item.Name,
+				LastModified: item.Properties.LastModified.UnixMilli(),
+				ETag:         string(*item.Properties.ETag),
+				Size:         *item.Properties.ContentLength,
+			}
+			if withMetadata {
+				object.Metadata = fromAzureMetadata(item.Metadata)
+			}
+			page.Objects = append(page.Objects, object)
+		}
+		if maxObjectsPerPage > 0 {
+			if response.NextMarker != nil && *response.NextMarker != "" {
+				page.IsTruncated = true
+				page.NextToken = *response.NextMarker
+			}
+			break
+		}
+	}
+	return page, nil
+}
+
+func (a *azureRepository) copy(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+	sourceURL, err := a.blobClient(current).GetSASURL(sas.BlobPermissions{Read: true}, time.Now().Add(time.Hour), nil)
+	if err != nil {
+		return err
+	}
+	target := a.blobClient(destination)
+	response, err := target.StartCopyFromURL(context.TODO(), sourceURL, nil)
+	if err != nil {
+		return err
+	}
+	status, description := response.CopyStatus, ""
+	for wait := 100 * time.Millisecond; status != nil && *status == blob.CopyStatusTypePending; {
+		time.Sleep(wait)
+		if wait < 5*time.Second {
+			wait *= 2
+		}
+		properties, err := target.GetProperties(context.TODO(), nil)
+		if err != nil {
+			return err
+		}
+		status = properties.CopyStatus
+		if properties.CopyStatusDescription != nil {
+			description = *properties.CopyStatusDescription
+		}
+	}
+	// a copy may also fail or be aborted right away, anything but success is an error
+	if status == nil || *status != blob.CopyStatusTypeSuccess {
+		state := "status unknown"
+		if status != nil {
+			state = string(*status)
+		}
+		return errors.New("copy " + state + ": " + description)
+	}
+	return nil
+}
+
+func (a *azureRepository) blobClient(params *domain.ObjectParams) *blob.Client {
+	return a.client.ServiceClient().NewContainerClient(params.StoreName).NewBlobClient(params.Key)
+}
+
+func toAzureMetadata(metadata map[string]string) map[string]*string {
+	azureMetadata := make(map[string]*string, len(metadata))
+	for key, value := range metadata {
+		azureMetadata[key] = to.Ptr(value)
+	}
+	return azureMetadata
+}
+
+func fromAzureMetadata(azureMetadata map[string]*string) map[string]string {
+	metadata := make(map[string]string, len(azureMetadata))
+	for key, value := range azureMetadata {
+		if value != nil {
+			metadata[key] = *value
+		}
+	}
+	return metadata
+}
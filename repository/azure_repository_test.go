@@ -0,0 +1,335 @@
+package repository
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const azureTestAccount = "devstoreaccount1"
+
+type fakeBlob struct {
+	data                  []byte
+	contentType           string
+	etag                  string
+	copyStatus            string
+	copyStatusDescription string
+}
+
+// fakeBlobService serves the part of the Blob API the repository uses, with the account in the
+// path as Azurite does. Copies report the statuses of copyStatuses one after another, the first
+// one answers the copy request and each further one a HEAD of the target.
+type fakeBlobService struct {
+	mu           sync.Mutex
+	blobs        map[string]*fakeBlob
+	blocks       map[string][]byte
+	copyStatuses []string
+	etags        int
+	server       *httptest.Server
+}
+
+func newFakeBlobService(t *testing.T) (*fakeBlobService, domain.StorageRepository) {
+	t.Helper()
+	f := &fakeBlobService{blobs: map[string]*fakeBlob{}, blocks: map[string][]byte{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	credential, err := azblob.NewSharedKeyCredential(azureTestAccount, base64.StdEncoding.EncodeToString([]byte("test key")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(f.server.URL+"/"+azureTestAccount+"/", credential,
+		&azblob.ClientOptions{ClientOptions: azcore.ClientOptions{Retry: policy.RetryOptions{MaxRetries: -1}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, NewAzureRepository(client)
+}
+
+func (f *fakeBlobService) put(name string, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.store(name, []byte(content), contentTypeOf(name))
+}
+
+func (f *fakeBlobService) get(name string) *fakeBlob {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.blobs[name]
+}
+
+func (f *fakeBlobService) copyStatusesLeft() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.copyStatuses)
+}
+
+func (f *fakeBlobService) store(name string, data []byte, contentType string) *fakeBlob {
+	f.etags++
+	blob := &fakeBlob{data: data, contentType: contentType, etag: fmt.Sprintf("\"0x%X\"", f.etags)}
+	f.blobs[name] = blob
+	return blob
+}
+
+func (f *fakeBlobService) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimPrefix(r.URL.Path, "/"+azureTestAccount+"/")
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		f.list(w, name, query)
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		data, _ := io.ReadAll(r.Body)
+		f.blocks[name+"\x00"+query.Get("blockid")] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var blockList struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&blockList); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var data []byte
+		for _, id := range blockList.Latest {
+			data = append(data, f.blocks[name+"\x00"+id]...)
+		}
+		blob := f.store(name, data, r.Header.Get("x-ms-blob-content-type"))
+		f.writeProperties(w, blob)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && r.Header.Get("x-ms-copy-source") != "":
+		source, err := url.Parse(r.Header.Get("x-ms-copy-source"))
+		if err != nil || source.Query().Get("sig") == "" {
+			http.Error(w, "copy source is not a SAS URL", http.StatusForbidden)
+			return
+		}
+		original, ok := f.blobs[strings.TrimPrefix(source.Path, "/"+azureTestAccount+"/")]
+		if !ok {
+			http.Error(w, "copy source not found", http.StatusNotFound)
+			return
+		}
+		blob := f.store(name, original.data, original.contentType)
+		blob.copyStatus = f.nextCopyStatus()
+		f.writeProperties(w, blob)
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		blob := f.store(name, data, r.Header.Get("x-ms-blob-content-type"))
+		f.writeProperties(w, blob)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		blob, ok := f.blobs[name]
+		if !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodHead && blob.copyStatus == "pending" {
+			blob.copyStatus = f.nextCopyStatus()
+			if blob.copyStatus == "failed" {
+				blob.copyStatusDescription = "500 InternalError"
+			}
+		}
+		f.writeProperties(w, blob)
+		data, status := blob.data, http.StatusOK
+		if header := r.Header.Get("x-ms-range"); header != "" {
+			var first, last int
+			fmt.Sscanf(header, "bytes=%d-%d", &first, &last)
+			if last >= len(data) {
+				last = len(data) - 1
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+			data, status = data[first:last+1], http.StatusPartialContent
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.WriteHeader(status)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.String(), http.StatusBadRequest)
+	}
+}
+
+func (f *fakeBlobService) nextCopyStatus() string {
+	if len(f.copyStatuses) == 0 {
+		return "success"
+	}
+	status := f.copyStatuses[0]
+	f.copyStatuses = f.copyStatuses[1:]
+	return status
+}
+
+func (f *fakeBlobService) writeProperties(w http.ResponseWriter, blob *fakeBlob) {
+	sum := md5.Sum(blob.data)
+	w.Header().Set("ETag", blob.etag)
+	w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+	w.Header().Set("Content-Type", blob.contentType)
+	w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	w.Header().Set("x-ms-blob-type", "BlockBlob")
+	if blob.copyStatus != "" {
+		w.Header().Set("x-ms-copy-status", blob.copyStatus)
+		w.Header().Set("x-ms-copy-id", "copy1")
+	}
+	if blob.copyStatusDescription != "" {
+		w.Header().Set("x-ms-copy-status-description", blob.copyStatusDescription)
+	}
+}
+
+// list pages through the blobs of a container, the next marker is the name of the next blob.
+func (f *fakeBlobService) list(w http.ResponseWriter, containerName string, query url.Values) {
+	type properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		Etag          string `xml:"Etag"`
+		ContentLength int    `xml:"Content-Length"`
+		ContentType   string `xml:"Content-Type"`
+		BlobType      string `xml:"BlobType"`
+	}
+	type blobItem struct {
+		Name       string     `xml:"Name"`
+		Properties properties `xml:"Properties"`
+	}
+	type enumerationResults struct {
+		XMLName    xml.Name   `xml:"EnumerationResults"`
+		Blobs      []blobItem `xml:"Blobs>Blob"`
+		NextMarker string     `xml:"NextMarker"`
+	}
+	prefix := containerName + "/" + query.Get("prefix")
+	var names []string
+	for name := range f.blobs {
+		if strings.HasPrefix(name, prefix) && name >= containerName+"/"+query.Get("marker") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	result := enumerationResults{Blobs: []blobItem{}}
+	if maxResults, _ := strconv.Atoi(query.Get("maxresults")); maxResults > 0 && len(names) > maxResults {
+		result.NextMarker = strings.TrimPrefix(names[maxResults], containerName+"/")
+		names = names[:maxResults]
+	}
+	for _, name := range names {
+		blob := f.blobs[name]
+		result.Blobs = append(result.Blobs, blobItem{
+			Name: strings.TrimPrefix(name, containerName+"/"),
+			Properties: properties{
+				LastModified:  time.Now().UTC().Format(http.TimeFormat),
+				Etag:          blob.etag,
+				ContentLength: len(blob.data),
+				ContentType:   blob.contentType,
+				BlobType:      "BlockBlob",
+			},
+		})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestAzureObjectsPages(t *testing.T) {
+	blobs, repository := newFakeBlobService(t)
+	for _, key := range []string{"b/2.txt", "a.txt", "b/1.txt", "d.txt"} {
+		blobs.put("files/"+key, key)
+	}
+	blobs.put("other/x.txt", "x")
+	equalKeys(t, listKeys(t, repository, "files", "", 2), "a.txt", "b/1.txt", "b/2.txt", "d.txt")
+	equalKeys(t, listKeys(t, repository, "files", "/b/", 1), "b/1.txt", "b/2.txt")
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "a.txt", "b/1.txt", "b/2.txt", "d.txt")
+}
+
+func TestAzureUploadAndOpenRange(t *testing.T) {
+	blobs, repository := newFakeBlobService(t)
+	upload(t, repository, "files", "docs/hello.txt", "hello world")
+	if blob := blobs.get("files/docs/hello.txt"); blob == nil || string(blob.data) != "hello world" || blob.contentType != "text/plain; charset=utf-8" {
+		t.Fatalf("service has %+v", blob)
+	}
+	content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"}, &domain.ByteRange{Offset: 6, Length: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(content.Body)
+	content.Body.Close()
+	if err != nil || string(data) != "wor" {
+		t.Fatalf("range read %q, %v, want \"wor\"", data, err)
+	}
+	if content.Object.Size != 11 {
+		t.Fatalf("got size %v, want the size of the whole blob", content.Object.Size)
+	}
+}
+
+func TestAzureCopyWaitsForPendingCopy(t *testing.T) {
+	blobs, repository := newFakeBlobService(t)
+	blobs.put("files/a.txt", "one")
+	blobs.copyStatuses = []string{"pending", "pending", "success"}
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.StoreName != "other" || copied.Size != 3 || blobs.copyStatusesLeft() != 0 {
+		t.Fatalf("unexpected copy %+v, %v statuses left", copied, blobs.copyStatusesLeft())
+	}
+}
+
+func TestAzureCopyFails(t *testing.T) {
+	for name, statuses := range map[string][]string{
+		"right away":    {"failed"},
+		"while polling": {"pending", "failed"},
+		"when aborted":  {"pending", "aborted"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			blobs, repository := newFakeBlobService(t)
+			blobs.put("files/a.txt", "one")
+			blobs.copyStatuses = statuses
+			_, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a.txt"},
+				&domain.ObjectParams{StoreName: "other", Key: "b.txt"})
+			if err == nil || !strings.Contains(err.Error(), statuses[len(statuses)-1]) {
+				t.Fatalf("got %v, want a failed copy", err)
+			}
+		})
+	}
+}
+
+func TestAzureMoveVerifiesAndDeletesSource(t *testing.T) {
+	blobs, repository := newFakeBlobService(t)
+	blobs.put("files/a.txt", "one")
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Key != "b.txt" || exists(repository, "files", "a.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+
+	blobs.put("files/c.txt", "two")
+	blobs.copyStatuses = []string{"failed"}
+	if _, err = repository.Move(&domain.ObjectParams{StoreName: "files", Key: "c.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "d.txt"}); err == nil {
+		t.Fatal("move succeeded although the copy failed")
+	}
+	if got := read(t, repository, "files", "c.txt"); got != "two" {
+		t.Fatalf("source has %q after a failed move", got)
+	}
+}
@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"github.com/nevcodia/smarthub/domain"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// bulkConcurrency bounds the requests a bulk operation has in flight at once.
+const bulkConcurrency = 8
+
+// forEachConcurrently calls fn for every item with at most workers calls running.
+func forEachConcurrently[T any](items <-chan T, workers int, fn func(item T)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				fn(item)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deleteReport collects the per key results of a DeleteAll.
+type deleteReport struct {
+	mutex  sync.Mutex
+	report domain.DeleteAllReport
+}
+
+func newDeleteReport(storeName string, prefix string, dryRun bool) *deleteReport {
+	return &deleteReport{report: domain.DeleteAllReport{
+		StoreName: storeName,
+		Prefix:    prefix,
+		DryRun:    dryRun,
+		Results:   []domain.KeyResult{},
+	}}
+}
+
+func (r *deleteReport) add(key string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	result := domain.KeyResult{Key: key, Status: domain.StatusDeleted}
+	switch {
+	case err != nil:
+		result.Status = domain.StatusFailed
+		result.Error = err.Error()
+		r.report.Failed++
+	case r.report.DryRun:
+		result.Status = domain.StatusDryRun
+	default:
+		r.report.Deleted++
+	}
+	r.report.Results = append(r.report.Results, result)
+}
+
+// done returns the report sorted by key.
+func (r *deleteReport) done() domain.DeleteAllReport {
+	sort.Slice(r.report.Results, func(i, j int) bool {
+		return r.report.Results[i].Key < r.report.Results[j].Key
+	})
+	return r.report
+}
+
+// deleteEach deletes the given objects one by one, for backends without a batch delete.
+func deleteEach(storeName string, prefix string, objects []domain.StorageObject, dryRun bool, deleteKey func(key string) error) domain.DeleteAllReport {
+	report := newDeleteReport(storeName, prefix, dryRun)
+	keys := make(chan string)
+	go func() {
+		defer close(keys)
+		for _, object := range objects {
+			keys <- object.Key
+		}
+	}()
+	forEachConcurrently(keys, bulkConcurrency, func(key string) {
+		if dryRun {
+			report.add(key, nil)
+			return
+		}
+		report.add(key, deleteKey(key))
+	})
+	return report.done()
+}
+
+// copyProgressInterval is the number of objects after which CopyAll logs its progress.
+const copyProgressInterval = 1000
+
+// copyAll copies every object below the folder sourcePath to the same relative key below the
+// folder targetPath with at most workers copies running. With skipIdentical, objects whose target
+// already has the same ETag and size are left alone.
+func copyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool, workers int,
+	list func(storeName string, prefix string) ([]domain.StorageObject, error),
+	copyKey func(current *domain.ObjectParams, destination *domain.ObjectParams) error) (domain.CopyAllReport, error) {
+	sourcePath = domain.FolderPath(sourcePath)
+	targetPath = domain.FolderPath(targetPath)
+	objects, err := list(sourceStoreName, sourcePath)
+	if err != nil {
+		return domain.CopyAllReport{}, err
+	}
+	existing := map[string]domain.StorageObject{}
+	if skipIdentical {
+		targets, err := list(targetStoreName, targetPath)
+		if err != nil {
+			return domain.CopyAllReport{}, err
+		}
+		for _, target := range targets {
+			existing[target.Key] = target
+		}
+	}
+
+	report := domain.CopyAllReport{
+		SourceStoreName: sourceStoreName,
+		SourcePath:      sourcePath,
+		TargetStoreName: targetStoreName,
+		TargetPath:      targetPath,
+		Results:         make([]domain.CopyResult, 0, len(objects)),
+	}
+	var mutex sync.Mutex
+	add := func(result domain.CopyResult) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch result.Status {
+		case domain.StatusCopied:
+			report.Copied++
+		case domain.StatusSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+		report.Results = append(report.Results, result)
+		if done := len(report.Results); done%copyProgressInterval == 0 || done == len(objects) {
+			log.Printf("Copied %v of %v objects from %v:%v to %v:%v\n",
+				done, len(objects), sourceStoreName, sourcePath, targetStoreName, targetPath)
+		}
+	}
+
+	pending := make(chan domain.StorageObject)
+	go func() {
+		defer close(pending)
+		for _, object := range objects {
+			pending <- object
+		}
+	}()
+	forEachConcurrently(pending, workers, func(object domain.StorageObject) {
+		result := domain.CopyResult{
+			SourceKey: object.Key,
+			TargetKey: targetPath + strings.TrimPrefix(object.Key, sourcePath),
+			Status:    domain.StatusCopied,
+		}
+		if target, found := existing[result.TargetKey]; found && target.Size == object.Size &&
+			target.ETag != "" && target.ETag == object.ETag {
+			result.Status = domain.StatusSkipped
+			add(result)
+			return
+		}
+		err := copyKey(&domain.ObjectParams{StoreName: sourceStoreName, Key: result.SourceKey},
+			&domain.ObjectParams{StoreName: targetStoreName, Key: result.TargetKey})
+		if err != nil {
+			result.Status = domain.StatusFailed
+			result.Error = err.Error()
+		}
+		add(result)
+	})
+	sort.Slice(report.Results, func(i, j int) bool {
+		return report.Results[i].SourceKey < report.Results[j].SourceKey
+	})
+	return report, nil
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"testing"
+)
+
+func TestCopyAllMatchesFolders(t *testing.T) {
+	repository := newTestMemoryRepository()
+	for _, key := range []string{"a/b/x.txt", "a/b/y/z.txt", "a/bc/x.txt", "a/b.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	report, err := repository.CopyAll("files", "/a/b", "other", "target", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.SourcePath != "a/b/" || report.TargetPath != "target/" || report.Copied != 2 || len(report.Results) != 2 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "other", "", 0), "target/x.txt", "target/y/z.txt")
+	if got := read(t, repository, "other", "target/y/z.txt"); got != "a/b/y/z.txt" {
+		t.Fatalf("target/y/z.txt has %q", got)
+	}
+
+	// without paths the whole store is copied
+	if report, err = repository.CopyAll("files", "", "other", "", false); err != nil || report.Copied != 4 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	equalKeys(t, listKeys(t, repository, "other", "a/", 0), "a/b.txt", "a/b/x.txt", "a/b/y/z.txt", "a/bc/x.txt")
+}
+
+func TestCopyAllSkipsIdentical(t *testing.T) {
+	repository := newTestMemoryRepository()
+	for _, key := range []string{"src/same.txt", "src/changed.txt", "src/new.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	upload(t, repository, "other", "dst/same.txt", "src/same.txt")
+	upload(t, repository, "other", "dst/changed.txt", "src/changed.TXT")
+	report, err := repository.CopyAll("files", "src", "other", "dst", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 2 || report.Skipped != 1 || report.Failed != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	want := map[string]string{"src/changed.txt": domain.StatusCopied, "src/new.txt": domain.StatusCopied, "src/same.txt": domain.StatusSkipped}
+	for i, result := range report.Results {
+		if i > 0 && report.Results[i-1].SourceKey >= result.SourceKey {
+			t.Fatalf("results aren't sorted: %+v", report.Results)
+		}
+		if result.Status != want[result.SourceKey] || result.TargetKey != "dst/"+result.SourceKey[len("src/"):] {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+	if got := read(t, repository, "other", "dst/changed.txt"); got != "src/changed.txt" {
+		t.Fatalf("dst/changed.txt has %q", got)
+	}
+
+	if report, err = repository.CopyAll("files", "src", "other", "dst", false); err != nil || report.Copied != 3 || report.Skipped != 0 {
+		t.Fatalf("copying without skipIdentical returned %+v, %v", report, err)
+	}
+}
+
+func TestCopyAllCountsFailures(t *testing.T) {
+	repository := newTestMemoryRepository()
+	for _, key := range []string{"src/a.txt", "src/b.txt", "src/c.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	list := func(storeName string, prefix string) ([]domain.StorageObject, error) {
+		return repository.list(storeName, prefix, false)
+	}
+	copyKey := func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+		if current.Key == "src/b.txt" {
+			return errors.New("copy failed")
+		}
+		_, err := repository.Copy(current, destination)
+		return err
+	}
+	report, err := copyAll("files", "src", "other", "dst", false, 2, list, copyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Copied != 2 || report.Failed != 1 || report.Results[1].Status != domain.StatusFailed || report.Results[1].Error != "copy failed" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "other", "", 0), "dst/a.txt", "dst/c.txt")
+
+	if _, err = copyAll("missing", "src", "other", "dst", false, 2, list, copyKey); err == nil {
+		t.Fatal("copied from a missing store")
+	}
+}
@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/jlaffaye/ftp"
+	"github.com/nevcodia/smarthub/domain"
+	"net"
+	"strings"
+	"time"
+)
+
+// FTP stores objects as files below the directories of an FTP server.
+const FTP domain.StorageType = "ftp"
+
+func init() {
+	domain.RegisterStorage(FTP, newFTPStorage)
+}
+
+func newFTPStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	host, _, err := net.SplitHostPort(config.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("endpoint must be in host:port form: %w", err)
+	}
+	options := []ftp.DialOption{ftp.DialWithTimeout(30 * time.Second)}
+	switch strings.ToLower(config.Option("tls")) {
+	case "":
+	case "explicit":
+		options = append(options, ftp.DialWithExplicitTLS(&tls.Config{ServerName: host}))
+	case "implicit":
+		options = append(options, ftp.DialWithTLS(&tls.Config{ServerName: host}))
+	default:
+		return nil, fmt.Errorf("option tls must be empty, explicit or implicit, got: %v", config.Option("tls"))
+	}
+
+	dial := func() (*ftp.ServerConn, error) {
+		conn, err := ftp.Dial(config.Endpoint, options...)
+		if err != nil {
+			return nil, err
+		}
+		if err = conn.Login(config.Credential("user"), config.Credential("password")); err != nil {
+			conn.Quit()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return NewFTPRepository(dial, remoteRoots(config.Option("roots"))), nil
+}
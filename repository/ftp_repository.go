@@ -0,0 +1,417 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jlaffaye/ftp"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"log"
+	"net/textproto"
+	"path"
+	"sort"
+	"strings"
+)
+
+type ftpRepository struct {
+	dial  func() (*ftp.ServerConn, error)
+	roots map[string]string
+}
+
+// NewFTPRepository exposes the configured root directories of an FTP/FTPS server as stores.
+// dial must return a logged in connection; every operation uses its own connection.
+func NewFTPRepository(dial func() (*ftp.ServerConn, error), roots map[string]string) domain.StorageRepository {
+	return &ftpRepository{
+		dial:  dial,
+		roots: roots,
+	}
+}
+
+func (f *ftpRepository) StoreNames() ([]string, error) {
+	storeNames := make([]string, 0, len(f.roots))
+	for name := range f.roots {
+		storeNames = append(storeNames, name)
+	}
+	sort.Strings(storeNames)
+	return storeNames, nil
+}
+
+func (f *ftpRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := f.list(storeName, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+// ObjectsWithMetadata is the same as Objects, FTP has no object metadata.
+func (f *ftpRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	return f.Objects(storeName, maxObjectsPerPage, token, prefix)
+}
+
+func (f *ftpRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	storageObjects, err := f.list(storeName, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return browseOf(storageObjects, maxObjectsPerPage, token, prefix, delimiter, withSizes)
+}
+
+// list returns all objects below prefix, sorted by key.
+func (f *ftpRepository) list(storeName string, prefix string) ([]domain.StorageObject, error) {
+	root, err := f.root(storeName)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return nil, err
+	}
+	defer conn.Quit()
+
+	prefix = strings.TrimLeft(prefix, "/")
+	storageObjects := []domain.StorageObject{}
+	walker := conn.Walk(walkRoot(root, prefix))
+	for walker.Next() {
+		entry := walker.Stat()
+		key := keyOf(root, walker.Path())
+		if entry.Type == ftp.EntryTypeFolder {
+			if !canContain(key, prefix) {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if entry.Type != ftp.EntryTypeFile || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		storageObjects = append(storageObjects, domain.StorageObject{
+			StoreName:    storeName,
+			Key:          key,
+			LastModified: entry.Time.UnixMilli(),
+			Size:         int64(entry.Size),
+		})
+	}
+	if err = walker.Err(); err != nil && !isFTPNotFound(err) {
+		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+		return nil, err
+	}
+	sort.Slice(storageObjects, func(i, j int) bool {
+		return storageObjects[i].Key < storageObjects[j].Key
+	})
+	return storageObjects, nil
+}
+
+func (f *ftpRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	remotePath, err := f.remotePath(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.StorageObject{}, err
+	}
+	defer conn.Quit()
+
+	object, err := f.stat(conn, params, remotePath)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return object, nil
+}
+
+// Upload streams file to the server. Metadata is ignored because FTP can't store it.
+func (f *ftpRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	remotePath, err := f.remotePath(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.StorageObject{}, err
+	}
+	defer conn.Quit()
+
+	makeFTPDirs(conn, path.Dir(remotePath))
+	if err = conn.Stor(remotePath, file); err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	return f.stat(conn, params, remotePath)
+}
+
+func (f *ftpRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+// Open keeps a connection of its own until the body is closed.
+func (f *ftpRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	remotePath, err := f.remotePath(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.ObjectContent{}, err
+	}
+	object, err := f.stat(conn, params, remotePath)
+	if err != nil {
+		conn.Quit()
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	if err = params.Conditions.Check(object); err != nil {
+		conn.Quit()
+		return domain.ObjectContent{}, err
+	}
+	var offset uint64
+	if byteRange != nil {
+		offset = uint64(byteRange.Offset)
+	}
+	response, err := conn.RetrFrom(remotePath, offset)
+	if err != nil {
+		conn.Quit()
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	var body io.ReadCloser = &ftpBody{Response: response, conn: conn}
+	if byteRange != nil {
+		body = limitBody(body, byteRange.Length)
+	}
+	return domain.ObjectContent{
+		Object:      object,
+		ContentType: contentTypeOf(params.Key),
+		Body:        body,
+	}, nil
+}
+
+func (f *ftpRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (f *ftpRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+// DeleteAll works through the keys one by one on a single connection, since FTP servers
+// commonly limit the connections per user.
+func (f *ftpRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	objects, err := f.list(storeName, pathPrefix)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	report := newDeleteReport(storeName, pathPrefix, dryRun)
+	if dryRun {
+		for _, object := range objects {
+			report.add(object.Key, nil)
+		}
+		return report.done(), nil
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.DeleteAllReport{}, err
+	}
+	defer conn.Quit()
+
+	root := f.roots[storeName]
+	for _, object := range objects {
+		err = conn.Delete(joinKey(root, object.Key))
+		if err != nil {
+			log.Printf("Couldn't delete %v:%v. Here's why: %v\n", storeName, object.Key, err)
+		}
+		report.add(object.Key, err)
+	}
+	return report.done(), nil
+}
+
+func (f *ftpRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	remotePath, err := f.remotePath(params)
+	if err != nil {
+		return false, err
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return false, err
+	}
+	defer conn.Quit()
+
+	if err = conn.Delete(remotePath); err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy streams the object from one connection into another, FTP has no server side copy.
+func (f *ftpRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	source, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.StorageObject{}, err
+	}
+	defer source.Quit()
+	target, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.StorageObject{}, err
+	}
+	defer target.Quit()
+
+	return f.copy(source, target, current, destination)
+}
+
+// CopyAll copies one object after the other over a single pair of connections, since FTP
+// servers commonly limit the connections per user.
+func (f *ftpRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	source, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.CopyAllReport{}, err
+	}
+	defer source.Quit()
+	target, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.CopyAllReport{}, err
+	}
+	defer target.Quit()
+
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, 1,
+		f.list,
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := f.copy(source, target, current, destination)
+			return err
+		})
+}
+
+// Move renames the object on the server, the roots of all stores live on the same server.
+func (f *ftpRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentPath, err := f.remotePath(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationPath, err := f.remotePath(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	conn, err := f.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to ftp server. Here's why: %v\n", err)
+		return domain.StorageObject{}, err
+	}
+	defer conn.Quit()
+
+	makeFTPDirs(conn, path.Dir(destinationPath))
+	if err = conn.Rename(currentPath, destinationPath); err != nil {
+		log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return f.stat(conn, destination, destinationPath)
+}
+
+func (f *ftpRepository) copy(source *ftp.ServerConn, target *ftp.ServerConn, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentPath, err := f.remotePath(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationPath, err := f.remotePath(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	response, err := source.Retr(currentPath)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", current.StoreName, current.Key, err)
+		return domain.StorageObject{}, err
+	}
+	makeFTPDirs(target, path.Dir(destinationPath))
+	err = target.Stor(destinationPath, response)
+	if closeErr := response.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return f.stat(target, destination, destinationPath)
+}
+
+func (f *ftpRepository) stat(conn *ftp.ServerConn, params *domain.ObjectParams, remotePath string) (domain.StorageObject, error) {
+	object := domain.StorageObject{
+		StoreName: params.StoreName,
+		Key:       strings.TrimLeft(params.Key, "/"),
+	}
+	if entry, err := conn.GetEntry(remotePath); err == nil {
+		if entry.Type != ftp.EntryTypeFile {
+			return domain.StorageObject{}, fmt.Errorf("%v is not a file", params.Key)
+		}
+		object.Size = int64(entry.Size)
+		object.LastModified = entry.Time.UnixMilli()
+		return object, nil
+	}
+	// MLST is not supported everywhere, SIZE and MDTM are
+	size, err := conn.FileSize(remotePath)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	object.Size = size
+	if modified, err := conn.GetTime(remotePath); err == nil {
+		object.LastModified = modified.UnixMilli()
+	}
+	return object, nil
+}
+
+func (f *ftpRepository) root(storeName string) (string, error) {
+	root, ok := f.roots[storeName]
+	if !ok {
+		return "", fmt.Errorf("store %v is not configured", storeName)
+	}
+	return root, nil
+}
+
+func (f *ftpRepository) remotePath(params *domain.ObjectParams) (string, error) {
+	root, err := f.root(params.StoreName)
+	if err != nil {
+		return "", err
+	}
+	return joinKey(root, params.Key), nil
+}
+
+// makeFTPDirs creates dir and its parents. Errors are ignored since most servers
+// answer "already exists" with the same code as every other failure.
+func makeFTPDirs(conn *ftp.ServerConn, dir string) {
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+		_ = conn.MakeDir(current)
+	}
+}
+
+func isFTPNotFound(err error) bool {
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code == ftp.StatusFileUnavailable
+}
+
+// ftpBody closes the data connection of a transfer and then its control connection.
+type ftpBody struct {
+	*ftp.Response
+	conn *ftp.ServerConn
+}
+
+func (b *ftpBody) Close() error {
+	err := b.Response.Close()
+	b.conn.Quit()
+	return err
+}
@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"crypto/tls"
+	"errors"
+	ftpserver "github.com/fclairamb/ftpserverlib"
+	"github.com/jlaffaye/ftp"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/spf13/afero"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// ftpTestDriver serves a directory to any user.
+type ftpTestDriver struct {
+	fs       afero.Fs
+	listener net.Listener
+}
+
+func (d *ftpTestDriver) GetSettings() (*ftpserver.Settings, error) {
+	return &ftpserver.Settings{Listener: d.listener}, nil
+}
+
+func (d *ftpTestDriver) ClientConnected(cc ftpserver.ClientContext) (string, error) {
+	return "smarthub test server", nil
+}
+
+func (d *ftpTestDriver) ClientDisconnected(cc ftpserver.ClientContext) {}
+
+func (d *ftpTestDriver) AuthUser(cc ftpserver.ClientContext, user string, pass string) (ftpserver.ClientDriver, error) {
+	return d.fs, nil
+}
+
+func (d *ftpTestDriver) GetTLSConfig() (*tls.Config, error) {
+	return nil, errors.New("TLS is not configured")
+}
+
+// newTestFTPRepository serves the stores "files" and "other" from a temporary directory.
+func newTestFTPRepository(t *testing.T) (domain.StorageRepository, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for _, root := range []string{"files", "other"} {
+		if err := os.Mkdir(filepath.Join(dir, root), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := ftpserver.NewFtpServer(&ftpTestDriver{fs: afero.NewBasePathFs(afero.NewOsFs(), dir), listener: listener})
+	if err = server.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+	t.Cleanup(func() { server.Stop() })
+
+	dial := func() (*ftp.ServerConn, error) {
+		conn, err := ftp.Dial(listener.Addr().String(), ftp.DialWithTimeout(5*time.Second))
+		if err != nil {
+			return nil, err
+		}
+		if err = conn.Login("test", "test"); err != nil {
+			conn.Quit()
+			return nil, err
+		}
+		return conn, nil
+	}
+	return NewFTPRepository(dial, map[string]string{"files": "/files", "other": "/other"}), dir
+}
+
+func TestFTPObjectsPages(t *testing.T) {
+	repository, _ := newTestFTPRepository(t)
+	for _, key := range []string{"b/2.txt", "a.txt", "b/1.txt", "b/c/3.txt", "d.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 2), "a.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/", 0), "b/1.txt", "b/2.txt", "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/c", 1), "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "missing/", 0))
+
+	page, err := repository.Browse("files", 0, "", "b/", "/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 2 || len(page.Folders) != 1 || page.Folders[0].Prefix != "b/c/" || page.Folders[0].Size != 9 {
+		t.Fatalf("unexpected browse page %+v", page)
+	}
+	if _, err = repository.Objects("files", 2, "%%%", ""); !errors.Is(err, domain.ErrPageTokenInvalid) {
+		t.Fatalf("got %v for an invalid token, want ErrPageTokenInvalid", err)
+	}
+}
+
+func TestFTPUploadAndOpenRange(t *testing.T) {
+	repository, dir := newTestFTPRepository(t)
+	object := upload(t, repository, "files", "/docs/hello.txt", "hello world")
+	if object.Key != "docs/hello.txt" || object.Size != 11 {
+		t.Fatalf("unexpected uploaded object %+v", object)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "files", "docs", "hello.txt")); err != nil || string(data) != "hello world" {
+		t.Fatalf("server has %q, %v", data, err)
+	}
+
+	content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"}, &domain.ByteRange{Offset: 6, Length: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(content.Body)
+	content.Body.Close()
+	if err != nil || string(data) != "wor" {
+		t.Fatalf("range read %q, %v, want \"wor\"", data, err)
+	}
+	if content.Object.Size != 11 || content.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content %+v", content.Object)
+	}
+	if _, err = repository.Open(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"}, nil); err == nil {
+		t.Fatal("opened a missing object")
+	}
+}
+
+func TestFTPCopy(t *testing.T) {
+	repository, _ := newTestFTPRepository(t)
+	upload(t, repository, "files", "a/one.txt", "one")
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a/one.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "x/y/one.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.StoreName != "other" || copied.Size != 3 {
+		t.Fatalf("unexpected copy %+v", copied)
+	}
+	if got := read(t, repository, "other", "x/y/one.txt"); got != "one" {
+		t.Fatalf("copy has %q", got)
+	}
+	if !exists(repository, "files", "a/one.txt") {
+		t.Fatal("copy removed the source")
+	}
+}
+
+func TestFTPMove(t *testing.T) {
+	repository, _ := newTestFTPRepository(t)
+	upload(t, repository, "files", "a/one.txt", "one")
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a/one.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "b/one.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Key != "b/one.txt" || moved.Size != 3 {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	if got := read(t, repository, "other", "b/one.txt"); got != "one" {
+		t.Fatalf("moved object has %q", got)
+	}
+	if exists(repository, "files", "a/one.txt") {
+		t.Fatal("move kept the source")
+	}
+}
+
+func TestFTPDeleteAll(t *testing.T) {
+	repository, _ := newTestFTPRepository(t)
+	for _, key := range []string{"logs/1.log", "logs/2/3.log", "logs-old/4.log", "keep.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	report, err := repository.DeleteAll("files", "logs/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 0 || len(report.Results) != 2 || report.Results[0].Status != domain.StatusDryRun {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/4.log", "logs/1.log", "logs/2/3.log")
+
+	report, err = repository.DeleteAll("files", "logs/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || report.Failed != 0 || report.Results[0].Key != "logs/1.log" || report.Results[1].Key != "logs/2/3.log" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/4.log")
+}
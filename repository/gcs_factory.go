@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"github.com/nevcodia/smarthub/domain"
+	"google.golang.org/api/option"
+)
+
+// GCS stores objects in Google Cloud Storage buckets.
+const GCS domain.StorageType = "gcs"
+
+func init() {
+	domain.RegisterStorage(GCS, newGCSStorage)
+}
+
+// newGCSStorage uses the credentials_file credential or the application default credentials.
+// With an endpoint and no credentials file it talks to an unauthenticated emulator.
+func newGCSStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	credentialsFile := config.Credential("credentials_file")
+	var options []option.ClientOption
+	if credentialsFile != "" {
+		options = append(options, option.WithCredentialsFile(credentialsFile))
+	}
+	if config.Endpoint != "" {
+		options = append(options, option.WithEndpoint(config.Endpoint))
+		if credentialsFile == "" {
+			options = append(options, option.WithoutAuthentication())
+		}
+	}
+	client, err := storage.NewClient(context.Background(), options...)
+	if err != nil {
+		return nil, err
+	}
+	return NewGCSRepository(client, config.Option("project_id")), nil
+}
@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"google.golang.org/api/iterator"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// gcsChunkSize is the size of each request of a resumable upload
+const gcsChunkSize = 16 << 20
+
+type gcsRepository struct {
+	client    *storage.Client
+	projectID string
+}
+
+// NewGCSRepository exposes the buckets of a Google Cloud project as stores.
+func NewGCSRepository(client *storage.Client, projectID string) domain.StorageRepository {
+	return &gcsRepository{
+		client:    client,
+		projectID: projectID,
+	}
+}
+
+func (g *gcsRepository) StoreNames() ([]string, error) {
+	var bucketNames []string
+	buckets := g.client.Buckets(context.TODO(), g.projectID)
+	for {
+		bucket, err := buckets.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			log.Printf("Couldn't list buckets for your project. Here's why: %v\n", err)
+			return nil, err
+		}
+		bucketNames = append(bucketNames, bucket.Name)
+	}
+	return bucketNames, nil
+}
+
+func (g *gcsRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	return g.list(storeName, maxObjectsPerPage, token, prefix, false)
+}
+
+func (g *gcsRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	return g.list(storeName, maxObjectsPerPage, token, prefix, true)
+}
+
+func (g *gcsRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	if delimiter == "" {
+		return g.list(storeName, maxObjectsPerPage, token, prefix, false)
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	query := &storage.Query{Prefix: prefix, Delimiter: delimiter}
+	if err := query.SetAttrSelection([]string{"Name", "Bucket", "Etag", "Size", "Updated"}); err != nil {
+		return domain.ObjectPage{}, err
+	}
+	objects := g.client.Bucket(storeName).Objects(context.TODO(), query)
+	var attrs []*storage.ObjectAttrs
+	page := domain.ObjectPage{Objects: []domain.StorageObject{}}
+	if maxObjectsPerPage > 0 {
+		nextToken, err := iterator.NewPager(objects, int(maxObjectsPerPage), token).NextPage(&attrs)
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return domain.ObjectPage{}, err
+		}
+		page.IsTruncated = nextToken != ""
+		page.NextToken = nextToken
+	} else {
+		objects.PageInfo().Token = token
+		for {
+			attr, err := objects.Next()
+			if errors.Is(err, iterator.Done) {
+				break
+			}
+			if err != nil {
+				log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+				return domain.ObjectPage{}, err
+			}
+			attrs = append(attrs, attr)
+		}
+	}
+	for _, attr := range attrs {
+		if attr.Prefix != "" {
+			page.Folders = append(page.Folders, newFolder(prefix, attr.Prefix, delimiter))
+		} else {
+			page.Objects = append(page.Objects, toGCSStorageObject(attr, false))
+		}
+	}
+	if withSizes {
+		err := addFolderSizes(&page, func(prefix string) ([]domain.StorageObject, error) {
+			folderPage, err := g.list(storeName, 0, "", prefix, false)
+			return folderPage.Objects, err
+		})
+		if err != nil {
+			return domain.ObjectPage{}, err
+		}
+	}
+	return page, nil
+}
+
+func (g *gcsRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	attrs, err := g.object(params).Attrs(context.TODO())
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return toGCSStorageObject(attrs, true), nil
+}
+
+// Upload uses a resumable upload, every chunk is retried on its own when a request fails.
+func (g *gcsRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	writer := g.object(params).NewWriter(context.TODO())
+	writer.ChunkSize = gcsChunkSize
+	writer.ContentType = uploadContentType(params)
+	writer.Metadata = metadata
+	_, err := io.Copy(writer, file)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	return toGCSStorageObject(writer.Attrs(), true), nil
+}
+
+// PresignUploadLink returns a V4 signed PUT URL. Content type and metadata are signed
+// headers, so the client has to send them with exactly these values.
+func (g *gcsRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	headers := make([]string, 0, len(metadata))
+	for key, value := range metadata {
+		headers = append(headers, "x-goog-meta-"+key+":"+value)
+	}
+	url, err := g.client.Bucket(params.StoreName).SignedURL(params.Key, &storage.SignedURLOptions{
+		Scheme:      storage.SigningSchemeV4,
+		Method:      http.MethodPut,
+		ContentType: mimeType,
+		Headers:     headers,
+		Expires:     time.Now().Add(time.Duration(exp * uint(time.Millisecond))),
+	})
+	if err != nil {
+		log.Printf("Couldn't get a presigned request to put %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return "", err
+	}
+	return url, nil
+}
+
+// Open reads the generation whose attributes it returns, even if the object is replaced meanwhile.
+func (g *gcsRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	attrs, err := g.object(params).Attrs(context.TODO())
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	offset, length := int64(0), int64(-1)
+	if byteRange != nil {
+		offset, length = byteRange.Offset, byteRange.Length
+	}
+	reader, err := g.object(params).Generation(attrs.Generation).NewRangeReader(context.TODO(), offset, length)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	contentType := attrs.ContentType
+	if contentType == "" {
+		contentType = contentTypeOf(params.Key)
+	}
+	return domain.ObjectContent{
+		Object:      toGCSStorageObject(attrs, true),
+		ContentType: contentType,
+		Body:        reader,
+	}, nil
+}
+
+func (g *gcsRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return g.PresignDownloadLinkWithExpTime(params, 15*uint(time.Minute)) //Default time 15 minute
+}
+
+func (g *gcsRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	url, err := g.client.Bucket(params.StoreName).SignedURL(params.Key, &storage.SignedURLOptions{
+		Scheme:  storage.SigningSchemeV4,
+		Method:  http.MethodGet,
+		Expires: time.Now().Add(time.Duration(exp * uint(time.Millisecond))),
+	})
+	if err != nil {
+		log.Printf("Couldn't get a presigned request to get %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return "", err
+	}
+	return url, nil
+}
+
+func (g *gcsRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	page, err := g.list(storeName, 0, "", pathPrefix, false)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	return deleteEach(storeName, pathPrefix, page.Objects, dryRun, func(key string) error {
+		_, err := g.Delete(&domain.ObjectParams{StoreName: storeName, Key: key})
+		return err
+	}), nil
+}
+
+func (g *gcsRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	if err := g.object(params).Delete(context.TODO()); err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy uses the rewrite API, which copies large objects server side in several calls.
+func (g *gcsRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	attrs, err := g.object(destination).CopierFrom(g.object(current)).Run(context.TODO())
+	if err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return toGCSStorageObject(attrs, true), nil
+}
+
+func (g *gcsRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		func(storeName string, prefix string) ([]domain.StorageObject, error) {
+			page, err := g.list(storeName, 0, "", prefix, false)
+			return page.Objects, err
+		},
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := g.Copy(current, destination)
+			return err
+		})
+}
+
+// Move rewrites the object and deletes the source once the copy is verified.
+func (g *gcsRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	return moveByCopy(g, current, destination, g.sameContent)
+}
+
+// sameContent compares the CRC32C checksums of the copy and its source, which every object has.
+func (g *gcsRepository) sameContent(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+	source, err := g.object(current).Attrs(context.TODO())
+	if err != nil {
+		return err
+	}
+	copied, err := g.object(destination).Attrs(context.TODO())
+	if err != nil {
+		return err
+	}
+	if source.CRC32C != copied.CRC32C {
+		return domain.ErrCopyMismatch
+	}
+	return nil
+}
+
+// list hands out the page token of the storage API as the next token. Without a page size
+// all objects following the token are listed.
+func (g *gcsRepository) list(storeName string, maxObjectsPerPage int32, token string, prefix string, withMetadata bool) (domain.ObjectPage, error) {
+	query := &storage.Query{Prefix: strings.TrimLeft(prefix, "/")}
+	attributes := []string{"Name", "Bucket", "Etag", "Size", "Updated"}
+	if withMetadata {
+		attributes = append(attributes, "Metadata")
+	}
+	if err := query.SetAttrSelection(attributes); err != nil {
+		return domain.ObjectPage{}, err
+	}
+	objects := g.client.Bucket(storeName).Objects(context.TODO(), query)
+	page := domain.ObjectPage{Objects: []domain.StorageObject{}}
+	if maxObjectsPerPage > 0 {
+		var attrs []*storage.ObjectAttrs
+		nextToken, err := iterator.NewPager(objects, int(maxObjectsPerPage), token).NextPage(&attrs)
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return domain.ObjectPage{}, err
+		}
+		for _, attr := range attrs {
+			page.Objects = append(page.Objects, toGCSStorageObject(attr, withMetadata))
+		}
+		page.IsTruncated = nextToken != ""
+		page.NextToken = nextToken
+		return page, nil
+	}
+	objects.PageInfo().Token = token
+	for {
+		attrs, err := objects.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return domain.ObjectPage{}, err
+		}
+		page.Objects = append(page.Objects, toGCSStorageObject(attrs, withMetadata))
+	}
+	return page, nil
+}
+
+func (g *gcsRepository) object(params *domain.ObjectParams) *storage.ObjectHandle {
+	return g.client.Bucket(params.StoreName).Object(params.Key)
+}
+
+func toGCSStorageObject(attrs *storage.ObjectAttrs, withMetadata bool) domain.StorageObject {
+	object := domain.StorageObject{
+		StoreName:    attrs.Bucket,
+		Key:          attrs.Name,
+		LastModified: attrs.Updated.UnixMilli(),
+		ETag:         attrs.Etag,
+		Size:         attrs.Size,
+	}
+	if withMetadata {
+		object.Metadata = attrs.Metadata
+	}
+	return object
+}
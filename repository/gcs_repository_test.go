@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"errors"
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"strings"
+	"testing"
+)
+
+// newTestGCSRepository connects through the emulator support of the factory to a fake GCS
+// server with the buckets "files" and "other". Downloads go to the public host of the server,
+// which is set to the listener. The fake server doesn't page listings, so tests list all at once.
+func newTestGCSRepository(t *testing.T) (domain.StorageRepository, *fakestorage.Server) {
+	t.Helper()
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{
+		Scheme:     "http",
+		Host:       "127.0.0.1",
+		PublicHost: "127.0.0.1",
+		InitialObjects: []fakestorage.Object{
+			{ObjectAttrs: fakestorage.ObjectAttrs{BucketName: "other", Name: "seed.txt"}, Content: []byte("seed")},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	server.CreateBucketWithOpts(fakestorage.CreateBucketOpts{Name: "files"})
+	repository, err := newGCSStorage(domain.ConnectionConfig{Name: "gcs", Type: "gcs", Endpoint: server.URL() + "/storage/v1/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repository, server
+}
+
+func TestGCSObjectsAndBrowse(t *testing.T) {
+	repository, _ := newTestGCSRepository(t)
+	for _, key := range []string{"b/2.txt", "a.txt", "b/1.txt", "b/c/3.txt", "d.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "a.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt")
+	equalKeys(t, listKeys(t, repository, "files", "/b/", 0), "b/1.txt", "b/2.txt", "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/c", 0), "b/c/3.txt")
+
+	page, err := repository.Browse("files", 0, "", "b/", "/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 2 || len(page.Folders) != 1 || page.Folders[0].Prefix != "b/c/" || page.Folders[0].Size != 9 {
+		t.Fatalf("unexpected browse page %+v", page)
+	}
+	names, err := repository.StoreNames()
+	if err != nil || len(names) != 2 {
+		t.Fatalf("got stores %v, %v", names, err)
+	}
+}
+
+func TestGCSUploadAndOpenRange(t *testing.T) {
+	repository, server := newTestGCSRepository(t)
+	object, err := repository.Upload(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"},
+		map[string]string{"owner": "team"}, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Key != "docs/hello.txt" || object.Size != 11 || object.Metadata["owner"] != "team" {
+		t.Fatalf("unexpected uploaded object %+v", object)
+	}
+	stored, err := server.GetObject("files", "docs/hello.txt")
+	if err != nil || string(stored.Content) != "hello world" || stored.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("server has %+v, %v", stored.ObjectAttrs, err)
+	}
+
+	content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"}, &domain.ByteRange{Offset: 6, Length: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(content.Body)
+	content.Body.Close()
+	if err != nil || string(data) != "wor" {
+		t.Fatalf("range read %q, %v, want \"wor\"", data, err)
+	}
+	if content.Object.Size != 11 || content.Object.Metadata["owner"] != "team" {
+		t.Fatalf("unexpected content %+v", content.Object)
+	}
+	if _, err = repository.GetObject(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"}); err == nil {
+		t.Fatal("found a missing object")
+	}
+}
+
+func TestGCSCopyAndMove(t *testing.T) {
+	repository, _ := newTestGCSRepository(t)
+	upload(t, repository, "files", "a/one.txt", "one")
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a/one.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "x/one.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.StoreName != "other" || copied.Key != "x/one.txt" || copied.Size != 3 {
+		t.Fatalf("unexpected copy %+v", copied)
+	}
+	if got := read(t, repository, "other", "x/one.txt"); got != "one" {
+		t.Fatalf("copy has %q", got)
+	}
+
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a/one.txt"},
+		&domain.ObjectParams{StoreName: "files", Key: "b/one.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Key != "b/one.txt" || exists(repository, "files", "a/one.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	if _, err = repository.Move(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"},
+		&domain.ObjectParams{StoreName: "files", Key: "c.txt"}); err == nil || exists(repository, "files", "c.txt") {
+		t.Fatalf("moving a missing object returned %v", err)
+	}
+}
+
+func TestGCSDeleteAll(t *testing.T) {
+	repository, _ := newTestGCSRepository(t)
+	for _, key := range []string{"logs/1.log", "logs/2/3.log", "logs-old/4.log", "keep.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	report, err := repository.DeleteAll("files", "logs/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || report.Failed != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/4.log")
+	if _, err = repository.Delete(&domain.ObjectParams{StoreName: "files", Key: "logs/1.log"}); err == nil {
+		t.Fatal("deleted a missing object")
+	}
+	if _, err = repository.Objects("missing", 0, "", ""); err == nil || errors.Is(err, domain.ErrPageTokenInvalid) {
+		t.Fatalf("listing a missing bucket returned %v", err)
+	}
+}
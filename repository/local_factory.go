@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"os"
+	"path/filepath"
+)
+
+// LOCAL stores objects as files below directories of the hub's own file system.
+const LOCAL domain.StorageType = "local"
+
+func init() {
+	domain.RegisterStorage(LOCAL, newLocalStorage)
+}
+
+// newLocalStorage resolves the directories of the roots option, which have to exist already.
+func newLocalStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	roots := parseRoots(config.Option("roots"))
+	for name, dir := range roots {
+		absolute, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("can't resolve local root %v: %w", dir, err)
+		}
+		info, err := os.Stat(absolute)
+		if err != nil {
+			return nil, fmt.Errorf("local root %v is not a directory: %w", absolute, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("local root %v is not a directory", absolute)
+		}
+		roots[name] = absolute
+	}
+	return NewLocalRepository(roots), nil
+}
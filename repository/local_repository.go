@@ -0,0 +1,439 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+const (
+	localSidecarPrefix = "."
+	localSidecarSuffix = ".smarthub-meta.json"
+)
+
+// renameFile renames the files of objects, tests stand in for a rename across devices.
+var renameFile = os.Rename
+
+type localRepository struct {
+	roots map[string]string
+}
+
+// NewLocalRepository exposes directories of the local filesystem as stores. Keys are
+// slash separated paths below the root of their store, metadata is kept in a hidden
+// sidecar file next to each object.
+func NewLocalRepository(roots map[string]string) domain.StorageRepository {
+	return &localRepository{
+		roots: roots,
+	}
+}
+
+func (l *localRepository) StoreNames() ([]string, error) {
+	storeNames := make([]string, 0, len(l.roots))
+	for name := range l.roots {
+		storeNames = append(storeNames, name)
+	}
+	sort.Strings(storeNames)
+	return storeNames, nil
+}
+
+func (l *localRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := l.list(storeName, prefix, false)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+func (l *localRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := l.list(storeName, prefix, true)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+func (l *localRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	storageObjects, err := l.list(storeName, prefix, false)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return browseOf(storageObjects, maxObjectsPerPage, token, prefix, delimiter, withSizes)
+}
+
+func (l *localRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	filePath, err := l.filePath(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	info, err := os.Stat(filePath)
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%v is a directory", params.Key)
+	}
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	object := l.toStorageObject(params.StoreName, cleanKey(params.Key), info)
+	object.Metadata, err = readSidecar(filePath)
+	if err != nil {
+		log.Printf("Couldn't read metadata of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+	}
+	return object, nil
+}
+
+// Upload writes file to a temporary file next to the target and renames it into place,
+// so readers never see a partially written object.
+func (l *localRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	filePath, err := l.filePath(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if err = writeAtomic(filePath, file); err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	if err = writeSidecar(filePath, metadata); err != nil {
+		log.Printf("Couldn't write metadata of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return l.GetObject(params)
+}
+
+func (l *localRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (l *localRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	object, err := l.GetObject(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	if err = params.Conditions.Check(object); err != nil {
+		return domain.ObjectContent{}, err
+	}
+	filePath, err := l.filePath(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	source, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	var body io.ReadCloser = source
+	if byteRange != nil {
+		if _, err = source.Seek(byteRange.Offset, io.SeekStart); err != nil {
+			source.Close()
+			log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+			return domain.ObjectContent{}, err
+		}
+		body = limitBody(source, byteRange.Length)
+	}
+	return domain.ObjectContent{
+		Object:      object,
+		ContentType: contentTypeOf(params.Key),
+		Body:        body,
+	}, nil
+}
+
+func (l *localRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (l *localRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (l *localRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	objects, err := l.list(storeName, pathPrefix, false)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	return deleteEach(storeName, pathPrefix, objects, dryRun, func(key string) error {
+		_, err := l.Delete(&domain.ObjectParams{StoreName: storeName, Key: key})
+		return err
+	}), nil
+}
+
+func (l *localRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	filePath, err := l.filePath(params)
+	if err != nil {
+		return false, err
+	}
+	if err = os.Remove(filePath); err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	if err = os.Remove(sidecarPath(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Couldn't delete metadata of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+	}
+	return true, nil
+}
+
+func (l *localRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentPath, err := l.filePath(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationPath, err := l.filePath(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if err = copyFile(currentPath, destinationPath); err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return l.GetObject(destination)
+}
+
+func (l *localRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		func(storeName string, prefix string) ([]domain.StorageObject, error) {
+			return l.list(storeName, prefix, false)
+		},
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := l.Copy(current, destination)
+			return err
+		})
+}
+
+// Move renames the file. When the stores live on different devices it falls back to moveByCopy,
+// which deletes the source only once the copy has its size.
+func (l *localRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentPath, err := l.filePath(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationPath, err := l.filePath(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if err = os.MkdirAll(filepath.Dir(destinationPath), 0o755); err == nil {
+		if err = renameFile(currentPath, destinationPath); err == nil {
+			err = os.Rename(sidecarPath(currentPath), sidecarPath(destinationPath))
+			if errors.Is(err, fs.ErrNotExist) {
+				err = os.Remove(sidecarPath(destinationPath))
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				err = nil
+			}
+		} else if errors.Is(err, syscall.EXDEV) {
+			return moveByCopy(l, current, destination, nil)
+		}
+	}
+	if err != nil {
+		log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return l.GetObject(destination)
+}
+
+func (l *localRepository) list(storeName string, prefix string, withMetadata bool) ([]domain.StorageObject, error) {
+	root, err := l.root(storeName)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	walkPath := filepath.FromSlash(walkRoot(root, prefix))
+	if !insideRoot(root, walkPath) {
+		return nil, fmt.Errorf("%v is not a valid prefix", prefix)
+	}
+	storageObjects := []domain.StorageObject{}
+	err = filepath.WalkDir(walkPath, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		key := keyOf(root, filepath.ToSlash(filePath))
+		if entry.IsDir() {
+			if key != "" && !canContain(key, prefix) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() || isInternalName(entry.Name()) || !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		object := l.toStorageObject(storeName, key, info)
+		if withMetadata {
+			object.Metadata, _ = readSidecar(filePath)
+		}
+		storageObjects = append(storageObjects, object)
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+		return nil, err
+	}
+	sort.Slice(storageObjects, func(i, j int) bool {
+		return storageObjects[i].Key < storageObjects[j].Key
+	})
+	return storageObjects, nil
+}
+
+func (l *localRepository) toStorageObject(storeName string, key string, info fs.FileInfo) domain.StorageObject {
+	return domain.StorageObject{
+		StoreName:    storeName,
+		Key:          key,
+		LastModified: info.ModTime().UnixMilli(),
+		ETag:         fmt.Sprintf("\"%x-%x\"", info.ModTime().UnixNano(), info.Size()),
+		Size:         info.Size(),
+	}
+}
+
+func (l *localRepository) root(storeName string) (string, error) {
+	root, ok := l.roots[storeName]
+	if !ok {
+		return "", fmt.Errorf("store %v is not configured", storeName)
+	}
+	return filepath.ToSlash(root), nil
+}
+
+// filePath resolves the key of params inside its store. Keys are cleaned as absolute
+// paths and their symbolic links have to stay below the root, so they can't point outside
+// of it, and they can't address the internal files.
+func (l *localRepository) filePath(params *domain.ObjectParams) (string, error) {
+	root, err := l.root(params.StoreName)
+	if err != nil {
+		return "", err
+	}
+	key := cleanKey(params.Key)
+	if key == "" || isInternalName(path.Base(key)) {
+		return "", fmt.Errorf("%v is not a valid key", params.Key)
+	}
+	filePath := filepath.FromSlash(joinKey(root, key))
+	if !insideRoot(root, filePath) {
+		return "", fmt.Errorf("%v is not a valid key", params.Key)
+	}
+	return filePath, nil
+}
+
+// insideRoot reports whether filePath stays below root once their symbolic links are resolved.
+func insideRoot(root string, filePath string) bool {
+	resolvedRoot, err := resolveExisting(filepath.FromSlash(root))
+	if err != nil {
+		return false
+	}
+	resolved, err := resolveExisting(filePath)
+	if err != nil {
+		return false
+	}
+	relative, err := filepath.Rel(resolvedRoot, resolved)
+	return err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator))
+}
+
+// resolveExisting resolves the symbolic links of the part of filePath which exists. A dangling
+// link can't be resolved, so where it points to is unknown.
+func resolveExisting(filePath string) (string, error) {
+	existing, rest := filepath.Clean(filePath), ""
+	for {
+		resolved, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		if _, lstatErr := os.Lstat(existing); lstatErr == nil {
+			return "", fmt.Errorf("%v is a dangling link", existing)
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return "", err
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+}
+
+func sidecarPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), localSidecarPrefix+filepath.Base(filePath)+localSidecarSuffix)
+}
+
+func readSidecar(filePath string) (map[string]string, error) {
+	content, err := os.ReadFile(sidecarPath(filePath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var metadata map[string]string
+	err = json.Unmarshal(content, &metadata)
+	return metadata, err
+}
+
+// writeSidecar replaces the metadata of filePath, empty metadata removes the sidecar.
+func writeSidecar(filePath string, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		err := os.Remove(sidecarPath(filePath))
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(sidecarPath(filePath), strings.NewReader(string(content)))
+}
+
+// writeAtomic fills a temporary file in the target directory and renames it over filePath.
+func writeAtomic(filePath string, content io.Reader) error {
+	dir := filepath.Dir(filePath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(dir, uploadTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if _, err = io.Copy(temp, content); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(temp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), filePath)
+}
+
+func copyFile(currentPath string, destinationPath string) error {
+	source, err := os.Open(currentPath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err = writeAtomic(destinationPath, source); err != nil {
+		return err
+	}
+	metadata, err := readSidecar(currentPath)
+	if err != nil {
+		return err
+	}
+	return writeSidecar(destinationPath, metadata)
+}
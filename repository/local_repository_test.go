@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"github.com/nevcodia/smarthub/domain"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// newTestLocalRepository serves the stores "files" and "other" from temporary directories.
+func newTestLocalRepository(t *testing.T) domain.StorageRepository {
+	t.Helper()
+	return NewLocalRepository(map[string]string{"files": t.TempDir(), "other": t.TempDir()})
+}
+
+func uploadWithMetadata(t *testing.T, repository domain.StorageRepository, storeName string, key string, content string, metadata map[string]string) {
+	t.Helper()
+	if _, err := repository.Upload(&domain.ObjectParams{StoreName: storeName, Key: key}, metadata, strings.NewReader(content)); err != nil {
+		t.Fatalf("Upload(%v:%v) failed: %v", storeName, key, err)
+	}
+}
+
+func TestLocalCopy(t *testing.T) {
+	repository := newTestLocalRepository(t)
+	uploadWithMetadata(t, repository, "files", "a.txt", "hello", map[string]string{"origin": "local"})
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "/new/dir/b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.Key != "new/dir/b.txt" || copied.Size != 5 || copied.Metadata["origin"] != "local" {
+		t.Fatalf("unexpected copy %+v", copied)
+	}
+	if got := read(t, repository, "other", "new/dir/b.txt"); got != "hello" || !exists(repository, "files", "a.txt") {
+		t.Fatalf("copy has %q", got)
+	}
+	// sidecars aren't objects of their own
+	equalKeys(t, listKeys(t, repository, "other", "", 0), "new/dir/b.txt")
+	if _, err = repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"}, &domain.ObjectParams{StoreName: "other", Key: "c.txt"}); err == nil {
+		t.Fatal("copied a missing object")
+	}
+}
+
+func TestLocalMove(t *testing.T) {
+	repository := newTestLocalRepository(t)
+	uploadWithMetadata(t, repository, "files", "a.txt", "hello", map[string]string{"origin": "local"})
+	uploadWithMetadata(t, repository, "other", "b.txt", "replaced", map[string]string{"stale": "yes"})
+	uploadWithMetadata(t, repository, "files", "plain.txt", "plain", nil)
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Size != 5 || moved.Metadata["origin"] != "local" || moved.Metadata["stale"] != "" || exists(repository, "files", "a.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	// an object without metadata doesn't take over the metadata of the one it replaces
+	if moved, err = repository.Move(&domain.ObjectParams{StoreName: "files", Key: "plain.txt"}, &domain.ObjectParams{StoreName: "other", Key: "b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, repository, "other", "b.txt"); got != "plain" || len(moved.Metadata) != 0 {
+		t.Fatalf("b.txt has %q with metadata %v", got, moved.Metadata)
+	}
+	if _, err = repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "c.txt"}); err == nil {
+		t.Fatal("moved a missing object")
+	}
+}
+
+func TestLocalMoveAcrossDevices(t *testing.T) {
+	renameFile = func(oldPath string, newPath string) error {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: syscall.EXDEV}
+	}
+	defer func() { renameFile = os.Rename }()
+	repository := newTestLocalRepository(t)
+	uploadWithMetadata(t, repository, "files", "a.txt", "hello", map[string]string{"origin": "local"})
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "dir/b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Key != "dir/b.txt" || moved.Size != 5 || moved.Metadata["origin"] != "local" || exists(repository, "files", "a.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0))
+	if _, err = repository.Move(&domain.ObjectParams{StoreName: "other", Key: "dir/b.txt"}, &domain.ObjectParams{StoreName: "other", Key: "/dir/b.txt"}); err == nil {
+		t.Fatal("moved an object onto itself")
+	}
+	if got := read(t, repository, "other", "dir/b.txt"); got != "hello" {
+		t.Fatalf("dir/b.txt has %q after moving it onto itself", got)
+	}
+}
+
+func TestLocalDeleteAll(t *testing.T) {
+	repository := newTestLocalRepository(t)
+	for _, key := range []string{"logs/a.txt", "logs/b/c.txt", "logs-old/d.txt", "keep.txt"} {
+		uploadWithMetadata(t, repository, "files", key, key, map[string]string{"key": key})
+	}
+	report, err := repository.DeleteAll("files", "logs/", true)
+	if err != nil || report.Deleted != 0 || len(report.Results) != 2 || report.Results[0].Status != domain.StatusDryRun {
+		t.Fatalf("dry run returned %+v, %v", report, err)
+	}
+	if report, err = repository.DeleteAll("files", "logs/", false); err != nil || report.Deleted != 2 || report.Failed != 0 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if report.Results[0].Key != "logs/a.txt" || report.Results[1].Key != "logs/b/c.txt" {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/d.txt")
+	if report, err = repository.DeleteAll("files", "", false); err != nil || report.Deleted != 2 {
+		t.Fatalf("deleting the whole store returned %+v, %v", report, err)
+	}
+	// the sidecars went with their objects
+	root := repository.(*localRepository).roots["files"]
+	if err = filepath.Walk(root, func(filePath string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			t.Errorf("%v is left", filePath)
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLocalSymlinksStayInsideRoot(t *testing.T) {
+	base := t.TempDir()
+	root, outside := filepath.Join(base, "root"), filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "inside"), outside} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{
+		"escape":        outside,
+		"escape.txt":    filepath.Join(outside, "secret.txt"),
+		"dangling.txt":  filepath.Join(outside, "missing.txt"),
+		"shortcut":      filepath.Join(root, "inside"),
+		"inside/up.txt": filepath.Join("..", "..", "outside", "secret.txt"),
+	} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symbolic links aren't supported: %v", err)
+		}
+	}
+	repository := NewLocalRepository(map[string]string{"files": root})
+
+	for _, key := range []string{"escape/secret.txt", "escape.txt", "inside/up.txt", "dangling.txt", "escape/new.txt"} {
+		if _, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: key}, nil); err == nil {
+			t.Errorf("opened %v outside the root", key)
+		}
+		if _, err := repository.Upload(&domain.ObjectParams{StoreName: "files", Key: key}, nil, strings.NewReader("replaced")); err == nil {
+			t.Errorf("uploaded %v outside the root", key)
+		}
+	}
+	if content, _ := os.ReadFile(filepath.Join(outside, "secret.txt")); string(content) != "secret" {
+		t.Fatalf("the file outside the root has %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "missing.txt")); err == nil {
+		t.Fatal("the dangling link was written through")
+	}
+	if _, err := repository.Objects("files", 0, "", "escape/"); err == nil {
+		t.Fatal("listed the objects outside the root")
+	}
+
+	// links which stay below the root are followed
+	upload(t, repository, "files", "shortcut/a.txt", "hello")
+	if got := read(t, repository, "files", "inside/a.txt"); got != "hello" {
+		t.Fatalf("inside/a.txt has %q", got)
+	}
+	upload(t, repository, "files", "new/dir/b.txt", "world")
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "inside/a.txt", "new/dir/b.txt")
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"crypto/rand"
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"net/url"
+	"strings"
+)
+
+// MEMORY keeps objects in the memory of the hub, they are gone after a restart.
+const MEMORY domain.StorageType = "memory"
+
+func init() {
+	domain.RegisterStorage(MEMORY, newMemoryStorage)
+}
+
+// newMemoryStorage enables the in-memory stores listed in the stores option. Presigned links point
+// at the endpoint, which defaults to the hub itself. Without a signing_key credential a random key
+// is used, so links don't survive a restart, just like the objects themselves.
+func newMemoryStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	var stores []string
+	for _, store := range strings.Split(config.Option("stores"), ",") {
+		if store = strings.TrimSpace(store); store != "" {
+			stores = append(stores, store)
+		}
+	}
+	if len(stores) == 0 {
+		return nil, errors.New("option stores must name at least one store")
+	}
+	signingKey := []byte(config.Credential("signing_key"))
+	if len(signingKey) == 0 {
+		signingKey = make([]byte, 32)
+		if _, err := rand.Read(signingKey); err != nil {
+			return nil, err
+		}
+	}
+	publicURL := config.Endpoint
+	if publicURL == "" {
+		publicURL = config.HubURL
+	}
+	// the hub serves the links of each connection under /api/:connection/presigned
+	presignURL := strings.TrimRight(publicURL, "/") + "/api/" + url.PathEscape(config.Name) + "/presigned"
+	return NewMemoryRepository(stores, presignURL, signingKey), nil
+}
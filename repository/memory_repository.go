@@ -0,0 +1,422 @@
+package repository
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryObject struct {
+	data         []byte
+	contentType  string
+	etag         string
+	lastModified int64
+	metadata     map[string]string
+}
+
+type memoryRepository struct {
+	mutex      sync.RWMutex
+	stores     map[string]map[string]*memoryObject
+	presignURL string
+	signingKey []byte
+}
+
+// NewMemoryRepository keeps objects of the given stores in memory, which makes it suited for
+// tests and demos. Presigned links point at presignURL, under which the hub serves them, and are
+// signed with signingKey.
+func NewMemoryRepository(storeNames []string, presignURL string, signingKey []byte) domain.StorageRepository {
+	stores := make(map[string]map[string]*memoryObject, len(storeNames))
+	for _, name := range storeNames {
+		stores[name] = map[string]*memoryObject{}
+	}
+	return &memoryRepository{
+		stores:     stores,
+		presignURL: presignURL,
+		signingKey: signingKey,
+	}
+}
+
+func (m *memoryRepository) StoreNames() ([]string, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	storeNames := make([]string, 0, len(m.stores))
+	for name := range m.stores {
+		storeNames = append(storeNames, name)
+	}
+	sort.Strings(storeNames)
+	return storeNames, nil
+}
+
+func (m *memoryRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := m.list(storeName, prefix, false)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+func (m *memoryRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := m.list(storeName, prefix, true)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+func (m *memoryRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	storageObjects, err := m.list(storeName, prefix, false)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return browseOf(storageObjects, maxObjectsPerPage, token, prefix, delimiter, withSizes)
+}
+
+func (m *memoryRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	object, err := m.get(params)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return object.toStorageObject(params.StoreName, params.Key, true), nil
+}
+
+func (m *memoryRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	return m.put(params, uploadContentType(params), metadata, file)
+}
+
+// PresignUploadLink returns a link to the hub which accepts a PUT of the object body until exp
+// milliseconds have passed.
+func (m *memoryRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	if _, err := m.store(params.StoreName); err != nil {
+		return "", err
+	}
+	query := url.Values{}
+	query.Set("contentType", mimeType)
+	if len(metadata) > 0 {
+		content, err := json.Marshal(metadata)
+		if err != nil {
+			return "", err
+		}
+		query.Set("metadata", string(content))
+	}
+	return m.presign(http.MethodPut, params, query, exp), nil
+}
+
+func (m *memoryRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	object, err := m.get(params)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	content := object.content(params)
+	if byteRange != nil {
+		// the range was checked against a size the object may no longer have, like a file it's
+		// cut off at the end of the data
+		size := int64(len(object.data))
+		start, end := byteRange.Offset, byteRange.Offset+byteRange.Length
+		if start < 0 || start > size {
+			start = size
+		}
+		if end < start {
+			end = start
+		}
+		if end > size {
+			end = size
+		}
+		content.Body = io.NopCloser(bytes.NewReader(object.data[start:end]))
+	}
+	return content, nil
+}
+
+func (m *memoryRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return m.PresignDownloadLinkWithExpTime(params, 15*uint(time.Minute/time.Millisecond)) //Default time 15 minute
+}
+
+// PresignDownloadLinkWithExpTime returns a link to the hub which serves the object until exp
+// milliseconds have passed.
+func (m *memoryRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	if _, err := m.store(params.StoreName); err != nil {
+		return "", err
+	}
+	return m.presign(http.MethodGet, params, url.Values{}, exp), nil
+}
+
+func (m *memoryRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	objects, err := m.store(storeName)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	pathPrefix = strings.TrimLeft(pathPrefix, "/")
+	report := newDeleteReport(storeName, pathPrefix, dryRun)
+	for key := range objects {
+		if strings.HasPrefix(key, pathPrefix) {
+			if !dryRun {
+				delete(objects, key)
+			}
+			report.add(key, nil)
+		}
+	}
+	return report.done(), nil
+}
+
+// Delete succeeds for missing keys as well, the same way S3 does.
+func (m *memoryRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	objects, err := m.store(params.StoreName)
+	if err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	delete(objects, params.Key)
+	return true, nil
+}
+
+func (m *memoryRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	result, err := m.transfer(current, destination, false)
+	if err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+	}
+	return result, err
+}
+
+func (m *memoryRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		func(storeName string, prefix string) ([]domain.StorageObject, error) {
+			return m.list(storeName, prefix, false)
+		},
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := m.Copy(current, destination)
+			return err
+		})
+}
+
+func (m *memoryRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	result, err := m.transfer(current, destination, true)
+	if err != nil {
+		log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+	}
+	return result, err
+}
+
+// OpenPresigned serves the object behind a link created by PresignDownloadLinkWithExpTime.
+func (m *memoryRepository) OpenPresigned(query url.Values) (domain.ObjectContent, error) {
+	params, err := m.verify(http.MethodGet, query)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	object, err := m.get(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	return object.content(params), nil
+}
+
+// UploadPresigned stores file under a link created by PresignUploadLink, with the content type
+// and metadata that were signed into it.
+func (m *memoryRepository) UploadPresigned(query url.Values, file io.Reader) (domain.StorageObject, error) {
+	params, err := m.verify(http.MethodPut, query)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	var metadata map[string]string
+	if content := query.Get("metadata"); content != "" {
+		if err = json.Unmarshal([]byte(content), &metadata); err != nil {
+			return domain.StorageObject{}, domain.ErrPresignInvalid
+		}
+	}
+	return m.put(params, query.Get("contentType"), metadata, file)
+}
+
+func (m *memoryRepository) put(params *domain.ObjectParams, contentType string, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	sum := md5.Sum(data)
+	object := &memoryObject{
+		data:         data,
+		contentType:  contentType,
+		etag:         "\"" + hex.EncodeToString(sum[:]) + "\"",
+		lastModified: time.Now().UnixMilli(),
+		metadata:     copyMetadata(metadata),
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	objects, err := m.store(params.StoreName)
+	if err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	objects[params.Key] = object
+	return object.toStorageObject(params.StoreName, params.Key, true), nil
+}
+
+// transfer copies or moves an object under a single lock. Objects are never modified in place,
+// so the copy shares its data with the source.
+func (m *memoryRepository) transfer(current *domain.ObjectParams, destination *domain.ObjectParams, move bool) (domain.StorageObject, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	object, err := m.get(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	objects, err := m.store(destination.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	copied := *object
+	copied.lastModified = time.Now().UnixMilli()
+	copied.metadata = copyMetadata(object.metadata)
+	objects[destination.Key] = &copied
+	if move && (current.StoreName != destination.StoreName || current.Key != destination.Key) {
+		delete(m.stores[current.StoreName], current.Key)
+	}
+	return copied.toStorageObject(destination.StoreName, destination.Key, true), nil
+}
+
+func (m *memoryRepository) list(storeName string, prefix string, withMetadata bool) ([]domain.StorageObject, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	objects, err := m.store(storeName)
+	if err != nil {
+		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+		return nil, err
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	storageObjects := []domain.StorageObject{}
+	for key, object := range objects {
+		if strings.HasPrefix(key, prefix) {
+			storageObjects = append(storageObjects, object.toStorageObject(storeName, key, withMetadata))
+		}
+	}
+	sort.Slice(storageObjects, func(i, j int) bool {
+		return storageObjects[i].Key < storageObjects[j].Key
+	})
+	return storageObjects, nil
+}
+
+// store and get expect the caller to hold the mutex.
+func (m *memoryRepository) store(storeName string) (map[string]*memoryObject, error) {
+	objects, ok := m.stores[storeName]
+	if !ok {
+		return nil, fmt.Errorf("store %v does not exist", storeName)
+	}
+	return objects, nil
+}
+
+func (m *memoryRepository) get(params *domain.ObjectParams) (*memoryObject, error) {
+	objects, err := m.store(params.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	object, ok := objects[params.Key]
+	if !ok {
+		return nil, fmt.Errorf("key %v does not exist in %v", params.Key, params.StoreName)
+	}
+	return object, nil
+}
+
+// presign adds the object, expiry and method to query and signs all of it.
+func (m *memoryRepository) presign(method string, params *domain.ObjectParams, query url.Values, exp uint) string {
+	expires := time.Now().Add(time.Duration(exp) * time.Millisecond).Unix()
+	query.Set("storeName", params.StoreName)
+	query.Set("key", params.Key)
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", m.signature(method, query))
+	return m.presignURL + "?" + query.Encode()
+}
+
+func (m *memoryRepository) verify(method string, query url.Values) (*domain.ObjectParams, error) {
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil || !hmac.Equal(signature, m.mac(method, query)) {
+		return nil, domain.ErrPresignInvalid
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return nil, domain.ErrPresignInvalid
+	}
+	return &domain.ObjectParams{
+		StoreName: query.Get("storeName"),
+		Key:       query.Get("key"),
+	}, nil
+}
+
+func (m *memoryRepository) signature(method string, query url.Values) string {
+	return hex.EncodeToString(m.mac(method, query))
+}
+
+// mac covers the method and every query parameter except the signature itself.
+func (m *memoryRepository) mac(method string, query url.Values) []byte {
+	signed := url.Values{}
+	for name, values := range query {
+		if name != "signature" {
+			signed[name] = values
+		}
+	}
+	hash := hmac.New(sha256.New, m.signingKey)
+	hash.Write([]byte(method + "\n" + signed.Encode()))
+	return hash.Sum(nil)
+}
+
+func (o *memoryObject) toStorageObject(storeName string, key string, withMetadata bool) domain.StorageObject {
+	storageObject := domain.StorageObject{
+		StoreName:    storeName,
+		Key:          key,
+		LastModified: o.lastModified,
+		ETag:         o.etag,
+		Size:         int64(len(o.data)),
+	}
+	if withMetadata {
+		storageObject.Metadata = copyMetadata(o.metadata)
+	}
+	return storageObject
+}
+
+// content hands out the object data, which is never modified in place and needs no copy.
+func (o *memoryObject) content(params *domain.ObjectParams) domain.ObjectContent {
+	return domain.ObjectContent{
+		Object:      o.toStorageObject(params.StoreName, params.Key, true),
+		ContentType: o.contentType,
+		Body:        io.NopCloser(bytes.NewReader(o.data)),
+	}
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if len(metadata) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+	return copied
+}
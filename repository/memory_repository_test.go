@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestMemoryRepository() *memoryRepository {
+	return NewMemoryRepository([]string{"files", "other"}, "http://hub.test/api/mem/presigned", []byte("test key")).(*memoryRepository)
+}
+
+// presignedQuery returns the query of a presigned link, after checking it points at the hub.
+func presignedQuery(t *testing.T, link string) url.Values {
+	t.Helper()
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Host != "hub.test" || parsed.Path != "/api/mem/presigned" {
+		t.Fatalf("link %v doesn't point at the hub", link)
+	}
+	return parsed.Query()
+}
+
+func TestMemoryObjectsPages(t *testing.T) {
+	repository := newTestMemoryRepository()
+	for _, key := range []string{"b/2.txt", "a.txt", "b/1.txt", "b/c/3.txt", "d.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 2), "a.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/", 1), "b/1.txt", "b/2.txt", "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "/b/c", 0), "b/c/3.txt")
+	if _, err := repository.Objects("files", 2, "%%%", ""); !errors.Is(err, domain.ErrPageTokenInvalid) {
+		t.Fatalf("got %v for an invalid token, want ErrPageTokenInvalid", err)
+	}
+}
+
+func TestMemoryDeleteAll(t *testing.T) {
+	repository := newTestMemoryRepository()
+	for _, key := range []string{"logs/a.txt", "logs/b/c.txt", "logs-old/d.txt", "keep.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	report, err := repository.DeleteAll("files", "/logs/", true)
+	if err != nil || report.Deleted != 0 || len(report.Results) != 2 || report.Results[0].Status != domain.StatusDryRun {
+		t.Fatalf("dry run returned %+v, %v", report, err)
+	}
+	if report, err = repository.DeleteAll("files", "/logs/", false); err != nil || report.Deleted != 2 || report.Failed != 0 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/d.txt")
+}
+
+func TestMemoryOpenClampsRange(t *testing.T) {
+	repository := newTestMemoryRepository()
+	upload(t, repository, "files", "a.txt", "hello")
+	for _, test := range []struct {
+		byteRange domain.ByteRange
+		want      string
+	}{
+		{domain.ByteRange{Offset: 1, Length: 3}, "ell"},
+		{domain.ByteRange{Offset: 3, Length: 10}, "lo"},
+		{domain.ByteRange{Offset: 5, Length: 1}, ""},
+		{domain.ByteRange{Offset: 10, Length: 5}, ""},
+	} {
+		content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &test.byteRange)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(content.Body)
+		if string(data) != test.want || content.Object.Size != 5 {
+			t.Errorf("range %+v read %q of %v bytes, want %q of 5", test.byteRange, data, content.Object.Size, test.want)
+		}
+	}
+}
+
+func TestMemoryPresignedDownload(t *testing.T) {
+	repository := newTestMemoryRepository()
+	object := upload(t, repository, "files", "a.txt", "hello")
+	link, err := repository.PresignDownloadLinkWithExpTime(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := presignedQuery(t, link)
+	content, err := repository.OpenPresigned(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content.Body)
+	if string(data) != "hello" || content.Object.ETag != object.ETag {
+		t.Fatalf("read %q with ETag %v, want \"hello\" with %v", data, content.Object.ETag, object.ETag)
+	}
+
+	tampered := url.Values{}
+	for name, values := range query {
+		tampered[name] = values
+	}
+	tampered.Set("key", "b.txt")
+	if _, err = repository.OpenPresigned(tampered); !errors.Is(err, domain.ErrPresignInvalid) {
+		t.Fatalf("got %v for a link to another key, want ErrPresignInvalid", err)
+	}
+	if _, err = repository.UploadPresigned(query, strings.NewReader("replaced")); !errors.Is(err, domain.ErrPresignInvalid) {
+		t.Fatalf("got %v for an upload with a download link, want ErrPresignInvalid", err)
+	}
+
+	// an expired link signed with the right key
+	expired := url.Values{}
+	expired.Set("storeName", "files")
+	expired.Set("key", "a.txt")
+	expired.Set("expires", strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10))
+	expired.Set("signature", repository.signature(http.MethodGet, expired))
+	if _, err = repository.OpenPresigned(expired); !errors.Is(err, domain.ErrPresignInvalid) {
+		t.Fatalf("got %v for an expired link, want ErrPresignInvalid", err)
+	}
+}
+
+func TestMemoryPresignedUpload(t *testing.T) {
+	repository := newTestMemoryRepository()
+	link, err := repository.PresignUploadLink(&domain.ObjectParams{StoreName: "files", Key: "a.bin"},
+		"application/x-test", map[string]string{"owner": "team"}, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := presignedQuery(t, link)
+	if _, err = repository.OpenPresigned(query); !errors.Is(err, domain.ErrPresignInvalid) {
+		t.Fatalf("got %v for a download with an upload link, want ErrPresignInvalid", err)
+	}
+	if _, err = repository.UploadPresigned(query, strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "a.bin"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if content.ContentType != "application/x-test" || content.Object.Metadata["owner"] != "team" {
+		t.Fatalf("got %v with metadata %v", content.ContentType, content.Object.Metadata)
+	}
+
+	query.Set("contentType", "text/html")
+	if _, err = repository.UploadPresigned(query, strings.NewReader("<script>")); !errors.Is(err, domain.ErrPresignInvalid) {
+		t.Fatalf("got %v for a changed content type, want ErrPresignInvalid", err)
+	}
+	if _, err = repository.PresignUploadLink(&domain.ObjectParams{StoreName: "missing", Key: "a"}, "", nil, 1000); err == nil {
+		t.Fatal("presigned a link into a missing store")
+	}
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"log"
+	"strings"
+)
+
+// moveByCopy moves an object on storages which can't rename it. The source is deleted only once
+// the copy has the size of the source and, if sameContent is given, the same content. A failed
+// move deletes the copy again, unless the source is gone already, so the object is never lost.
+func moveByCopy(repository domain.StorageRepository, current *domain.ObjectParams, destination *domain.ObjectParams,
+	sameContent func(current *domain.ObjectParams, destination *domain.ObjectParams) error) (domain.StorageObject, error) {
+	if current.StoreName == destination.StoreName && strings.TrimLeft(current.Key, "/") == strings.TrimLeft(destination.Key, "/") {
+		return domain.StorageObject{}, errors.New("source and destination of a move are the same")
+	}
+	if _, err := repository.Copy(current, destination); err != nil {
+		return domain.StorageObject{}, err
+	}
+	result, err := verifyCopy(repository, current, destination, sameContent)
+	if err == nil {
+		if _, err = repository.Delete(current); err == nil {
+			return result, nil
+		}
+	}
+	log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+		current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+	if _, sourceErr := repository.GetObject(current); sourceErr != nil {
+		log.Printf("Keeping the copy %v:%v, the source can't be found anymore.\n", destination.StoreName, destination.Key)
+		return domain.StorageObject{}, err
+	}
+	if _, rollbackErr := repository.Delete(destination); rollbackErr != nil {
+		log.Printf("Couldn't roll back the copy %v:%v. Here's why: %v\n", destination.StoreName, destination.Key, rollbackErr)
+	}
+	return domain.StorageObject{}, err
+}
+
+func verifyCopy(repository domain.StorageRepository, current *domain.ObjectParams, destination *domain.ObjectParams,
+	sameContent func(current *domain.ObjectParams, destination *domain.ObjectParams) error) (domain.StorageObject, error) {
+	source, err := repository.GetObject(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	copied, err := repository.GetObject(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if copied.Size != source.Size {
+		return domain.StorageObject{}, domain.ErrCopyMismatch
+	}
+	if sameContent != nil {
+		if err = sameContent(current, destination); err != nil {
+			return domain.StorageObject{}, err
+		}
+	}
+	return copied, nil
+}
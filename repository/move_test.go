@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"testing"
+)
+
+func TestMoveByCopy(t *testing.T) {
+	repository := newTestMemoryRepository()
+	upload(t, repository, "files", "a.txt", "hello")
+	current := &domain.ObjectParams{StoreName: "files", Key: "a.txt"}
+	destination := &domain.ObjectParams{StoreName: "other", Key: "b.txt"}
+	moved, err := moveByCopy(repository, current, destination, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.StoreName != "other" || moved.Key != "b.txt" || moved.Size != 5 || exists(repository, "files", "a.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	if _, err = moveByCopy(repository, destination, &domain.ObjectParams{StoreName: "other", Key: "/b.txt"}, nil); err == nil {
+		t.Fatal("moved an object onto itself")
+	}
+}
+
+func TestMoveByCopyRollsBackFailedVerify(t *testing.T) {
+	repository := newTestMemoryRepository()
+	upload(t, repository, "files", "a.txt", "hello")
+	current := &domain.ObjectParams{StoreName: "files", Key: "a.txt"}
+	destination := &domain.ObjectParams{StoreName: "other", Key: "b.txt"}
+	mismatch := func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+		if !exists(repository, destination.StoreName, destination.Key) {
+			t.Error("content compared before the copy exists")
+		}
+		return domain.ErrCopyMismatch
+	}
+	if _, err := moveByCopy(repository, current, destination, mismatch); !errors.Is(err, domain.ErrCopyMismatch) {
+		t.Fatalf("got %v, want ErrCopyMismatch", err)
+	}
+	if got := read(t, repository, "files", "a.txt"); got != "hello" {
+		t.Fatalf("source has %q after a failed move", got)
+	}
+	if exists(repository, "other", "b.txt") {
+		t.Fatal("the copy of a failed move wasn't rolled back")
+	}
+}
+
+func TestMoveByCopyKeepsCopyWithoutSource(t *testing.T) {
+	repository := newTestMemoryRepository()
+	upload(t, repository, "files", "a.txt", "hello")
+	current := &domain.ObjectParams{StoreName: "files", Key: "a.txt"}
+	destination := &domain.ObjectParams{StoreName: "other", Key: "b.txt"}
+	// the source disappears while the copy is verified
+	sourceGone := func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+		if _, err := repository.Delete(current); err != nil {
+			t.Fatal(err)
+		}
+		return domain.ErrCopyMismatch
+	}
+	if _, err := moveByCopy(repository, current, destination, sourceGone); err == nil {
+		t.Fatal("move succeeded although the verify failed")
+	}
+	if got := read(t, repository, "other", "b.txt"); got != "hello" {
+		t.Fatalf("copy has %q, it must be kept when the source is gone", got)
+	}
+}
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"encoding/base64"
+	"github.com/nevcodia/smarthub/domain"
+	"math/rand"
+	"mime"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// uploadTempPrefix marks files that are still being written by the hub.
+const uploadTempPrefix = ".smarthub-upload-"
+
+// joinKey resolves key below root. The key is cleaned as an absolute path first,
+// so ".." elements can never climb above root.
+func joinKey(root string, key string) string {
+	return path.Join(root, path.Clean("/"+key))
+}
+
+// keyOf is the inverse of joinKey.
+func keyOf(root string, fullPath string) string {
+	return strings.TrimLeft(strings.TrimPrefix(fullPath, root), "/")
+}
+
+// walkRoot returns the deepest directory that can contain keys starting with prefix.
+func walkRoot(root string, prefix string) string {
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return joinKey(root, prefix)
+	}
+	return joinKey(root, path.Dir(prefix))
+}
+
+// canContain reports whether a directory with the given key may hold keys starting with prefix.
+func canContain(dirKey string, prefix string) bool {
+	dirKey += "/"
+	return strings.HasPrefix(dirKey, prefix) || strings.HasPrefix(prefix, dirKey)
+}
+
+// parseRoots turns a comma separated list of "name=dir" or "dir" entries into a store name
+// to directory map. Bare directories are named after their last element.
+func parseRoots(value string) map[string]string {
+	roots := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, dir, found := strings.Cut(entry, "=")
+		if !found {
+			dir = name
+			name = path.Base(path.Clean("/" + filepath.ToSlash(dir)))
+			if name == "/" {
+				name = "root"
+			}
+		}
+		roots[strings.TrimSpace(name)] = strings.TrimSpace(dir)
+	}
+	return roots
+}
+
+// remoteRoots parses roots of a remote file system, which defaults to a single "root" store for "/".
+func remoteRoots(value string) map[string]string {
+	roots := parseRoots(value)
+	for name, dir := range roots {
+		roots[name] = path.Clean("/" + dir)
+	}
+	if len(roots) == 0 {
+		roots["root"] = "/"
+	}
+	return roots
+}
+
+func contentTypeOf(key string) string {
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return contentType
+}
+
+// uploadContentType is the content type an upload of params is stored with.
+func uploadContentType(params *domain.ObjectParams) string {
+	if params.ContentType != "" {
+		return params.ContentType
+	}
+	return contentTypeOf(params.Key)
+}
+
+// pageOf returns the page of objects following the one token was handed out with. The items must
+// already be sorted by key. The token holds the last key of the previous page, so objects added or
+// removed in between don't shift the following pages.
+func pageOf(items []domain.StorageObject, maxObjectsPerPage int32, token string) (domain.ObjectPage, error) {
+	start := 0
+	if token != "" {
+		after, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return domain.ObjectPage{}, domain.ErrPageTokenInvalid
+		}
+		start = sort.Search(len(items), func(i int) bool {
+			return items[i].Key > string(after)
+		})
+	}
+	items = items[start:]
+	if maxObjectsPerPage <= 0 || len(items) <= int(maxObjectsPerPage) {
+		return domain.ObjectPage{Objects: items}, nil
+	}
+	items = items[:maxObjectsPerPage]
+	return domain.ObjectPage{
+		Objects:     items,
+		IsTruncated: true,
+		NextToken:   base64.RawURLEncoding.EncodeToString([]byte(items[len(items)-1].Key)),
+	}, nil
+}
+
+// browseOf groups the keys below prefix at the next delimiter into folders, like a delimited
+// S3 listing does, and pages through folders and objects together in key order. The items
+// must already be sorted by key.
+func browseOf(items []domain.StorageObject, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	if delimiter == "" {
+		return pageOf(items, maxObjectsPerPage, token)
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	// entries holds the objects of this level and, for folders, an object named after the folder prefix
+	var entries []domain.StorageObject
+	folders := map[string]*domain.Folder{}
+	for _, item := range items {
+		rest := strings.TrimPrefix(item.Key, prefix)
+		end := strings.Index(rest, delimiter)
+		if end < 0 {
+			entries = append(entries, item)
+			continue
+		}
+		folderPrefix := prefix + rest[:end+len(delimiter)]
+		folder := folders[folderPrefix]
+		if folder == nil {
+			newFolder := newFolder(prefix, folderPrefix, delimiter)
+			folder = &newFolder
+			folders[folderPrefix] = folder
+			entries = append(entries, domain.StorageObject{Key: folderPrefix})
+		}
+		if withSizes {
+			folder.Size += item.Size
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	page, err := pageOf(entries, maxObjectsPerPage, token)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	storageObjects := []domain.StorageObject{}
+	for _, entry := range page.Objects {
+		if folder := folders[entry.Key]; folder != nil {
+			page.Folders = append(page.Folders, *folder)
+		} else {
+			storageObjects = append(storageObjects, entry)
+		}
+	}
+	page.Objects = storageObjects
+	return page, nil
+}
+
+// newFolder names a common prefix after its last level.
+func newFolder(prefix string, folderPrefix string, delimiter string) domain.Folder {
+	return domain.Folder{
+		Prefix: folderPrefix,
+		Name:   strings.TrimSuffix(strings.TrimPrefix(folderPrefix, prefix), delimiter),
+	}
+}
+
+// addFolderSizes sums up the objects below each folder of page, listed by list.
+func addFolderSizes(page *domain.ObjectPage, list func(prefix string) ([]domain.StorageObject, error)) error {
+	for i := range page.Folders {
+		objects, err := list(page.Folders[i].Prefix)
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			page.Folders[i].Size += object.Size
+		}
+	}
+	return nil
+}
+
+// cleanKey normalizes key the way joinKey does, without a leading slash.
+func cleanKey(key string) string {
+	return strings.TrimLeft(path.Clean("/"+key), "/")
+}
+
+func randomSuffix() string {
+	return strconv.FormatUint(rand.Uint64(), 36)
+}
+
+// isInternalName reports whether a file name belongs to the hub's own bookkeeping
+// and must neither be listed nor addressed as a key.
+func isInternalName(name string) bool {
+	return strings.HasPrefix(name, uploadTempPrefix) ||
+		strings.HasPrefix(name, localSidecarPrefix) && strings.HasSuffix(name, localSidecarSuffix)
+}
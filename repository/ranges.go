@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// rangeHeader formats byteRange as the value of an HTTP Range header.
+func rangeHeader(byteRange *domain.ByteRange) string {
+	return fmt.Sprintf("bytes=%d-%d", byteRange.Offset, byteRange.Offset+byteRange.Length-1)
+}
+
+// rangeTotal reads the object size from a Content-Range header like "bytes 0-99/1234".
+func rangeTotal(contentRange string, fallback int64) int64 {
+	_, total, found := strings.Cut(contentRange, "/")
+	if !found {
+		return fallback
+	}
+	size, err := strconv.ParseInt(total, 10, 64)
+	if err != nil {
+		return fallback
+	}
+	return size
+}
+
+// limitedBody closes the underlying body of a reader limited to a range.
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// limitBody cuts body off after length bytes.
+func limitBody(body io.ReadCloser, length int64) io.ReadCloser {
+	return limitedBody{Reader: io.LimitReader(body, length), Closer: body}
+}
+
+// rangedBody returns the selected bytes of an HTTP response to a ranged request. Servers are free
+// to ignore the Range header and send the whole content, which is then skipped up to the range.
+func rangedBody(response *http.Response, byteRange *domain.ByteRange) (io.ReadCloser, error) {
+	if byteRange == nil || response.StatusCode == http.StatusPartialContent {
+		return response.Body, nil
+	}
+	if _, err := io.CopyN(io.Discard, response.Body, byteRange.Offset); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+	return limitBody(response.Body, byteRange.Length), nil
+}
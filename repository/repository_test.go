@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"strings"
+	"testing"
+)
+
+// upload puts content under key or fails the test.
+func upload(t *testing.T, repository domain.StorageRepository, storeName string, key string, content string) domain.StorageObject {
+	t.Helper()
+	object, err := repository.Upload(&domain.ObjectParams{StoreName: storeName, Key: key}, map[string]string{}, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload(%v:%v) failed: %v", storeName, key, err)
+	}
+	return object
+}
+
+// read returns the content of the object or fails the test.
+func read(t *testing.T, repository domain.StorageRepository, storeName string, key string) string {
+	t.Helper()
+	content, err := repository.Open(&domain.ObjectParams{StoreName: storeName, Key: key}, nil)
+	if err != nil {
+		t.Fatalf("Open(%v:%v) failed: %v", storeName, key, err)
+	}
+	defer content.Body.Close()
+	data, err := io.ReadAll(content.Body)
+	if err != nil {
+		t.Fatalf("reading %v:%v failed: %v", storeName, key, err)
+	}
+	return string(data)
+}
+
+// exists reports whether GetObject finds the object.
+func exists(repository domain.StorageRepository, storeName string, key string) bool {
+	_, err := repository.GetObject(&domain.ObjectParams{StoreName: storeName, Key: key})
+	return err == nil
+}
+
+// listKeys pages through the objects below prefix with pages of pageSize and returns their keys.
+func listKeys(t *testing.T, repository domain.StorageRepository, storeName string, prefix string, pageSize int32) []string {
+	t.Helper()
+	keys := []string{}
+	token := ""
+	for {
+		page, err := repository.Objects(storeName, pageSize, token, prefix)
+		if err != nil {
+			t.Fatalf("Objects(%v, %v) failed: %v", storeName, prefix, err)
+		}
+		if pageSize > 0 && len(page.Objects) > int(pageSize) {
+			t.Fatalf("page has %v objects, more than %v", len(page.Objects), pageSize)
+		}
+		for _, object := range page.Objects {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated {
+			return keys
+		}
+		if page.NextToken == "" {
+			t.Fatalf("truncated page without a next token")
+		}
+		token = page.NextToken
+	}
+}
+
+func equalKeys(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("got keys %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/nevcodia/smarthub/domain"
+)
+
+// defaultPartSizeMB lets uploads of unknown length grow to 160 GB within the 10,000 parts of S3.
+const defaultPartSizeMB = 16
+
+// S3 stores objects in the buckets of S3 or an S3 compatible server.
+const S3 domain.StorageType = "s3"
+
+func init() {
+	domain.RegisterStorage(S3, newS3Storage)
+}
+
+func newS3Storage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	region := config.Region
+	if region == "" {
+		region = "aws-global"
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
+		awsconfig.WithRegion(region),
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(config.Credential("access_key"), config.Credential("secret_key"), "")),
+	)
+	if err != nil {
+		return nil, err
+	}
+	partSizeMB, err := config.IntOption("part_size_mb", defaultPartSizeMB)
+	if err != nil {
+		return nil, err
+	}
+	if int64(partSizeMB)<<20 < manager.MinUploadPartSize {
+		return nil, fmt.Errorf("option part_size_mb must be at least %d", manager.MinUploadPartSize>>20)
+	}
+	concurrency, err := config.IntOption("upload_concurrency", manager.DefaultUploadConcurrency)
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		return nil, fmt.Errorf("option upload_concurrency must be at least 1")
+	}
+
+	return NewS3Repository(cfg, config.Endpoint, int64(partSizeMB)<<20, concurrency), nil
+}
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+func (s *s3Repository) CreateMultipartUpload(params *domain.ObjectParams, metadata map[string]string) (domain.MultipartUpload, error) {
+	response, err := s.client.CreateMultipartUpload(context.TODO(), &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(params.StoreName),
+		Key:         aws.String(params.Key),
+		Metadata:    metadata,
+		ContentType: aws.String(uploadContentType(params)),
+	})
+	if err != nil {
+		log.Printf("Couldn't create a multipart upload of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.MultipartUpload{}, err
+	}
+	return domain.MultipartUpload{
+		UploadID:  aws.ToString(response.UploadId),
+		StoreName: params.StoreName,
+		Key:       params.Key,
+	}, nil
+}
+
+// UploadPart sends the part without a payload signature, because the body is a stream which can't
+// be read twice. S3 has to be told the size of such a part up front.
+func (s *s3Repository) UploadPart(params *domain.ObjectParams, uploadID string, partNumber int32, body io.Reader, size int64) (domain.UploadedPart, error) {
+	if size < 0 {
+		return domain.UploadedPart{}, errors.New("the size of a part has to be known")
+	}
+	response, err := s.client.UploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:        aws.String(params.StoreName),
+		Key:           aws.String(params.Key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int32(partNumber),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+	}, s3.WithAPIOptions(v4.SwapComputePayloadSHA256ForUnsignedPayloadMiddleware))
+	if err != nil {
+		log.Printf("Couldn't upload part %v of %v:%v. Here's why: %v\n", partNumber, params.StoreName, params.Key, err)
+		return domain.UploadedPart{}, uploadError(err)
+	}
+	return domain.UploadedPart{
+		PartNumber:   partNumber,
+		ETag:         aws.ToString(response.ETag),
+		Size:         size,
+		LastModified: time.Now().UnixMilli(),
+	}, nil
+}
+
+func (s *s3Repository) ListParts(params *domain.ObjectParams, uploadID string) ([]domain.UploadedPart, error) {
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(params.StoreName),
+		Key:      aws.String(params.Key),
+		UploadId: aws.String(uploadID),
+	})
+	parts := []domain.UploadedPart{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Couldn't list the parts of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+			return nil, uploadError(err)
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, domain.UploadedPart{
+				PartNumber:   aws.ToInt32(part.PartNumber),
+				ETag:         aws.ToString(part.ETag),
+				Size:         aws.ToInt64(part.Size),
+				LastModified: aws.ToTime(part.LastModified).UnixMilli(),
+			})
+		}
+	}
+	return parts, nil
+}
+
+func (s *s3Repository) CompleteMultipartUpload(params *domain.ObjectParams, uploadID string, parts []domain.UploadedPart) (domain.StorageObject, error) {
+	completedParts := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completedParts = append(completedParts, types.CompletedPart{
+			PartNumber: aws.Int32(part.PartNumber),
+			ETag:       aws.String(part.ETag),
+		})
+	}
+	_, err := s.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(params.StoreName),
+		Key:             aws.String(params.Key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completedParts},
+	})
+	if err != nil {
+		log.Printf("Couldn't complete the multipart upload of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, uploadError(err)
+	}
+	return s.GetObject(&domain.ObjectParams{StoreName: params.StoreName, Key: params.Key})
+}
+
+func (s *s3Repository) AbortMultipartUpload(params *domain.ObjectParams, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(context.TODO(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(params.StoreName),
+		Key:      aws.String(params.Key),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		log.Printf("Couldn't abort the multipart upload of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return uploadError(err)
+	}
+	return nil
+}
+
+func (s *s3Repository) PresignUploadPart(params *domain.ObjectParams, uploadID string, partNumber int32, exp uint) (string, error) {
+	request, err := s.presignClient.PresignUploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:     aws.String(params.StoreName),
+		Key:        aws.String(params.Key),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(partNumber),
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = time.Duration(exp * uint(time.Millisecond))
+	})
+	if err != nil {
+		log.Printf("Couldn't get a presigned request to upload part %v of %v:%v. Here's why: %v\n",
+			partNumber, params.StoreName, params.Key, err)
+		return "", err
+	}
+	return request.URL, nil
+}
+
+// copyPartSize is the size of the parts large objects are copied in, S3 copies up to 5 GB per part.
+// Tests shrink it.
+var copyPartSize int64 = 512 << 20
+
+// copyInParts copies an object too large for CopyObject with concurrent UploadPartCopy requests.
+// Unlike CopyObject, a multipart upload doesn't take over the metadata, content headers and tags
+// of the source by itself. The copy fails if the source changes meanwhile.
+func (s *s3Repository) copyInParts(current *domain.ObjectParams, destination *domain.ObjectParams, head *s3.HeadObjectOutput) (domain.StorageObject, error) {
+	size := aws.ToInt64(head.ContentLength)
+	partSize := copyPartSize
+	for (size+partSize-1)/partSize > domain.MaxPartNumber {
+		partSize += copyPartSize
+	}
+	tagging, err := s.client.GetObjectTagging(context.TODO(), &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(current.StoreName),
+		Key:       aws.String(current.Key),
+		VersionId: optionalString(current.VersionID),
+	})
+	if err != nil {
+		log.Printf("Couldn't get the tags of %v:%v. Here's why: %v\n", current.StoreName, current.Key, err)
+		return domain.StorageObject{}, err
+	}
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:             aws.String(destination.StoreName),
+		Key:                aws.String(destination.Key),
+		Metadata:           head.Metadata,
+		ContentType:        head.ContentType,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		Expires:            head.Expires,
+		StorageClass:       head.StorageClass,
+	}
+	if len(tagging.TagSet) > 0 {
+		tags := url.Values{}
+		for _, tag := range tagging.TagSet {
+			tags.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+		}
+		input.Tagging = aws.String(strings.ReplaceAll(tags.Encode(), "+", "%20"))
+	}
+	upload, err := s.client.CreateMultipartUpload(context.TODO(), input)
+	if err != nil {
+		log.Printf("Couldn't create a multipart upload of %v:%v. Here's why: %v\n", destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+
+	count := int32((size + partSize - 1) / partSize)
+	parts := make([]types.CompletedPart, count)
+	partNumbers := make(chan int32)
+	go func() {
+		defer close(partNumbers)
+		for partNumber := int32(1); partNumber <= count; partNumber++ {
+			partNumbers <- partNumber
+		}
+	}()
+	var mutex sync.Mutex
+	var copyErr error
+	forEachConcurrently(partNumbers, s.uploader.Concurrency, func(partNumber int32) {
+		mutex.Lock()
+		failed := copyErr != nil
+		mutex.Unlock()
+		if failed {
+			return
+		}
+		first := int64(partNumber-1) * partSize
+		last := min(first+partSize, size) - 1
+		response, err := s.client.UploadPartCopy(context.TODO(), &s3.UploadPartCopyInput{
+			Bucket:            aws.String(destination.StoreName),
+			Key:               aws.String(destination.Key),
+			UploadId:          upload.UploadId,
+			PartNumber:        aws.Int32(partNumber),
+			CopySource:        aws.String(copySource(current)),
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", first, last)),
+			CopySourceIfMatch: head.ETag,
+		})
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			if copyErr == nil {
+				copyErr = err
+			}
+			return
+		}
+		parts[partNumber-1] = types.CompletedPart{
+			PartNumber: aws.Int32(partNumber),
+			ETag:       response.CopyPartResult.ETag,
+		}
+	})
+	if copyErr == nil {
+		_, copyErr = s.client.CompleteMultipartUpload(context.TODO(), &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(destination.StoreName),
+			Key:             aws.String(destination.Key),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if copyErr != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v in parts. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, copyErr)
+		s.AbortMultipartUpload(destination, aws.ToString(upload.UploadId))
+		return domain.StorageObject{}, copyErr
+	}
+	return s.GetObject(&domain.ObjectParams{StoreName: destination.StoreName, Key: destination.Key})
+}
+
+// uploadError reports an unknown upload id as domain.ErrUploadNotFound.
+func uploadError(err error) error {
+	var apiError smithy.APIError
+	if errors.As(err, &apiError) && apiError.ErrorCode() == "NoSuchUpload" {
+		return domain.ErrUploadNotFound
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"encoding/xml"
+	"github.com/nevcodia/smarthub/domain"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// fakeCopyParts answers the requests of a copy in parts of files/big.bin to other/copy.bin and
+// records them. The copy of part failPart fails, as if the source had changed.
+type fakeCopyParts struct {
+	lock      sync.Mutex
+	size      int64
+	failPart  int
+	created   http.Header
+	ranges    map[int]string
+	ifMatches map[int]string
+	completed []int
+	aborted   bool
+}
+
+func (f *fakeCopyParts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/xml")
+	switch {
+	case r.Method == http.MethodHead && r.URL.Path == "/files/big.bin":
+		w.Header().Set("Content-Length", strconv.FormatInt(f.size, 10))
+		w.Header().Set("Content-Type", "application/x-big")
+		w.Header().Set("ETag", `"source"`)
+		w.Header().Set("x-amz-meta-origin", "parts")
+	case r.Method == http.MethodHead && r.URL.Path == "/other/copy.bin" && f.completed != nil:
+		w.Header().Set("Content-Length", strconv.FormatInt(f.size, 10))
+		w.Header().Set("ETag", `"copy-3"`)
+		w.Header().Set("Last-Modified", "Tue, 02 Jan 2024 03:04:05 GMT")
+		w.Header().Set("x-amz-meta-origin", "parts")
+	case r.Method == http.MethodGet && query.Has("tagging"):
+		w.Write([]byte(`<Tagging><TagSet><Tag><Key>team</Key><Value>a b</Value></Tag></TagSet></Tagging>`))
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.created = r.Header.Clone()
+		w.Write([]byte(`<InitiateMultipartUploadResult><Bucket>other</Bucket><Key>copy.bin</Key><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`))
+	case r.Method == http.MethodPut && query.Get("uploadId") == "upload-1" && r.Header.Get("x-amz-copy-source") == "files/big.bin":
+		partNumber, _ := strconv.Atoi(query.Get("partNumber"))
+		f.ranges[partNumber] = r.Header.Get("x-amz-copy-source-range")
+		f.ifMatches[partNumber] = r.Header.Get("x-amz-copy-source-if-match")
+		if partNumber == f.failPart {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`<Error><Code>PreconditionFailed</Code><Message>changed</Message></Error>`))
+			return
+		}
+		w.Write([]byte(`<CopyPartResult><ETag>"part-` + strconv.Itoa(partNumber) + `"</ETag></CopyPartResult>`))
+	case r.Method == http.MethodPost && query.Get("uploadId") == "upload-1":
+		var upload struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&upload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.completed = []int{}
+		for _, part := range upload.Parts {
+			if part.ETag != `"part-`+strconv.Itoa(part.PartNumber)+`"` {
+				http.Error(w, "unexpected etag "+part.ETag, http.StatusBadRequest)
+				return
+			}
+			f.completed = append(f.completed, part.PartNumber)
+		}
+		w.Write([]byte(`<CompleteMultipartUploadResult><Bucket>other</Bucket><Key>copy.bin</Key><ETag>"copy-3"</ETag></CompleteMultipartUploadResult>`))
+	case r.Method == http.MethodDelete && query.Get("uploadId") == "upload-1":
+		f.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestS3CopyInParts(t *testing.T) {
+	defer func(limit int64, partSize int64) {
+		copyObjectLimit, copyPartSize = limit, partSize
+	}(copyObjectLimit, copyPartSize)
+	copyObjectLimit, copyPartSize = 10, 4
+	current := &domain.ObjectParams{StoreName: "files", Key: "big.bin"}
+	destination := &domain.ObjectParams{StoreName: "other", Key: "copy.bin"}
+
+	parts := &fakeCopyParts{size: 11, ranges: map[int]string{}, ifMatches: map[int]string{}}
+	server := httptest.NewServer(parts)
+	defer server.Close()
+	object, err := newTestS3Repository(server.URL, "eu-west-1", "key").Copy(current, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.StoreName != "other" || object.Key != "copy.bin" || object.ETag != `"copy-3"` || object.Size != 11 || object.Metadata["origin"] != "parts" {
+		t.Fatalf("unexpected copy %+v", object)
+	}
+	// the last part is short
+	for partNumber, want := range map[int]string{1: "bytes=0-3", 2: "bytes=4-7", 3: "bytes=8-10"} {
+		if parts.ranges[partNumber] != want || parts.ifMatches[partNumber] != `"source"` {
+			t.Errorf("part %v copied %q if matching %q, want %q if matching \"source\"",
+				partNumber, parts.ranges[partNumber], parts.ifMatches[partNumber], want)
+		}
+	}
+	if len(parts.ranges) != 3 || len(parts.completed) != 3 || parts.completed[0] != 1 || parts.completed[2] != 3 || parts.aborted {
+		t.Fatalf("copied %v, completed %v and aborted %v", parts.ranges, parts.completed, parts.aborted)
+	}
+	// the upload takes over what CopyObject would have taken over
+	for name, want := range map[string]string{
+		"Content-Type":      "application/x-big",
+		"x-amz-meta-origin": "parts",
+		"x-amz-tagging":     "team=a%20b",
+	} {
+		if got := parts.created.Get(name); got != want {
+			t.Errorf("created the upload with %v %q, want %q", name, got, want)
+		}
+	}
+
+	parts = &fakeCopyParts{size: 11, failPart: 2, ranges: map[int]string{}, ifMatches: map[int]string{}}
+	failing := httptest.NewServer(parts)
+	defer failing.Close()
+	if _, err = newTestS3Repository(failing.URL, "eu-west-1", "key").Copy(current, destination); err == nil {
+		t.Fatal("copied although a part failed")
+	}
+	if !parts.aborted || parts.completed != nil {
+		t.Fatalf("completed %v, aborted %v, want the upload aborted", parts.completed, parts.aborted)
+	}
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PresignPost signs a POST policy with Signature Version 4, see
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-HTTPPOSTConstructPolicy.html.
+// The SDK doesn't sign POST policies. Every field handed out becomes an exact condition of the
+// policy, so the form can't change any of them.
+func (s *s3Repository) PresignPost(storeName string, policy domain.PostPolicy) (domain.PresignedPost, error) {
+	credentials, err := s.config.Credentials.Retrieve(context.TODO())
+	if err != nil {
+		log.Printf("Couldn't retrieve the credentials to sign a POST policy for %v. Here's why: %v\n", storeName, err)
+		return domain.PresignedPost{}, err
+	}
+	region := s.config.Region
+	if region == "" || region == "aws-global" {
+		region = "us-east-1"
+	}
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+
+	fields := map[string]string{
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": fmt.Sprintf("%v/%v/%v/s3/aws4_request", credentials.AccessKeyID, date, region),
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if credentials.SessionToken != "" {
+		fields["x-amz-security-token"] = credentials.SessionToken
+	}
+	if policy.Key != "" {
+		fields["key"] = policy.Key
+	}
+	if policy.ContentType != "" {
+		fields["Content-Type"] = policy.ContentType
+	}
+	for key, value := range policy.Metadata {
+		fields["x-amz-meta-"+key] = value
+	}
+
+	conditions := []any{map[string]string{"bucket": storeName}}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		conditions = append(conditions, map[string]string{name: fields[name]})
+	}
+	if policy.Key == "" {
+		conditions = append(conditions, []any{"starts-with", "$key", policy.KeyPrefix})
+		// S3 replaces ${filename} with the name of the file posted
+		fields["key"] = policy.KeyPrefix + "${filename}"
+	}
+	if policy.ContentType == "" && policy.ContentTypePrefix != "" {
+		conditions = append(conditions, []any{"starts-with", "$Content-Type", policy.ContentTypePrefix})
+	}
+	if policy.MaxSize > 0 {
+		conditions = append(conditions, []any{"content-length-range", policy.MinSize, policy.MaxSize})
+	}
+	document, err := json.Marshal(map[string]any{
+		"expiration": now.Add(time.Duration(policy.Expiration * uint(time.Millisecond))).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
+	if err != nil {
+		return domain.PresignedPost{}, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(document)
+	fields["policy"] = encoded
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	for _, scope := range []string{region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, scope)
+	}
+	fields["x-amz-signature"] = hex.EncodeToString(hmacSHA256(key, encoded))
+
+	endpoint := s.endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%v.amazonaws.com", region)
+	}
+	return domain.PresignedPost{
+		URL:    strings.TrimRight(endpoint, "/") + "/" + url.PathEscape(storeName),
+		Fields: fields,
+	}, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	hash := hmac.New(sha256.New, key)
+	hash.Write([]byte(data))
+	return hash.Sum(nil)
+}
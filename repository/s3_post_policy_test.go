@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/nevcodia/smarthub/domain"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// postSignature signs the policy the way S3 checks it, as documented for Signature Version 4.
+func postSignature(secret string, date string, region string, policy string) string {
+	sign := func(key []byte, data string) []byte {
+		hash := hmac.New(sha256.New, key)
+		hash.Write([]byte(data))
+		return hash.Sum(nil)
+	}
+	key := sign(sign(sign(sign([]byte("AWS4"+secret), date), region), "s3"), "aws4_request")
+	return hex.EncodeToString(sign(key, policy))
+}
+
+func TestS3PresignPost(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		region     string
+		endpoint   string
+		token      string
+		policy     domain.PostPolicy
+		url        string
+		fields     map[string]string
+		conditions []any
+	}{
+		{
+			name:     "exact key",
+			region:   "eu-west-1",
+			endpoint: "http://s3.test/",
+			policy:   domain.PostPolicy{Key: "docs/a b.txt", ContentType: "text/plain", Expiration: 60000},
+			url:      "http://s3.test/files",
+			fields:   map[string]string{"key": "docs/a b.txt", "Content-Type": "text/plain"},
+			conditions: []any{
+				map[string]any{"bucket": "files"},
+				map[string]any{"Content-Type": "text/plain"},
+				map[string]any{"key": "docs/a b.txt"},
+			},
+		},
+		{
+			name:     "key prefix",
+			region:   "eu-west-1",
+			endpoint: "http://s3.test",
+			policy: domain.PostPolicy{
+				KeyPrefix:         "uploads/",
+				ContentTypePrefix: "image/",
+				MinSize:           1,
+				MaxSize:           1 << 20,
+				Metadata:          map[string]string{"origin": "form"},
+				Expiration:        60000,
+			},
+			url:    "http://s3.test/files",
+			fields: map[string]string{"key": "uploads/${filename}", "x-amz-meta-origin": "form"},
+			conditions: []any{
+				map[string]any{"bucket": "files"},
+				map[string]any{"x-amz-meta-origin": "form"},
+				[]any{"starts-with", "$key", "uploads/"},
+				[]any{"starts-with", "$Content-Type", "image/"},
+				[]any{"content-length-range", float64(1), float64(1 << 20)},
+			},
+		},
+		{
+			name:   "default region and endpoint",
+			token:  "session",
+			policy: domain.PostPolicy{Key: "a.txt", ContentType: "text/plain", ContentTypePrefix: "image/", Expiration: 60000},
+			url:    "https://s3.us-east-1.amazonaws.com/files",
+			fields: map[string]string{"key": "a.txt", "Content-Type": "text/plain", "x-amz-security-token": "session"},
+			conditions: []any{
+				map[string]any{"bucket": "files"},
+				map[string]any{"Content-Type": "text/plain"},
+				map[string]any{"key": "a.txt"},
+				map[string]any{"x-amz-security-token": "session"},
+			},
+		},
+	} {
+		repository := NewS3Repository(aws.Config{
+			Region:      test.region,
+			Credentials: credentials.NewStaticCredentialsProvider("key", "secret", test.token),
+		}, test.endpoint, 5<<20, 1).(*s3Repository)
+		started := time.Now().UTC()
+		post, err := repository.PresignPost("files", test.policy)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if post.URL != test.url {
+			t.Errorf("%v: posts to %v, want %v", test.name, post.URL, test.url)
+		}
+		region := test.region
+		if region == "" {
+			region = "us-east-1"
+		}
+		date := post.Fields["x-amz-date"]
+		signed, err := time.Parse("20060102T150405Z", date)
+		if err != nil || signed.Before(started.Truncate(time.Second)) || signed.After(time.Now()) {
+			t.Fatalf("%v: signed at %q", test.name, date)
+		}
+		want := map[string]string{
+			"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+			"x-amz-credential": "key/" + date[:8] + "/" + region + "/s3/aws4_request",
+			"x-amz-date":       date,
+			"policy":           post.Fields["policy"],
+			"x-amz-signature":  postSignature("secret", date[:8], region, post.Fields["policy"]),
+		}
+		for name, value := range test.fields {
+			want[name] = value
+		}
+		if !reflect.DeepEqual(post.Fields, want) {
+			t.Errorf("%v: got fields %v, want %v", test.name, post.Fields, want)
+		}
+
+		content, err := base64.StdEncoding.DecodeString(post.Fields["policy"])
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		var document struct {
+			Expiration string `json:"expiration"`
+			Conditions []any  `json:"conditions"`
+		}
+		if err = json.Unmarshal(content, &document); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		expiration, err := time.Parse("2006-01-02T15:04:05.000Z", document.Expiration)
+		if err != nil || expiration.Sub(signed) < time.Minute || expiration.Sub(signed) > time.Minute+time.Second {
+			t.Errorf("%v: policy expires at %q, signed at %v", test.name, document.Expiration, signed)
+		}
+		// the signing fields are conditions as well
+		conditions := append([]any{}, test.conditions[0])
+		for _, name := range []string{"x-amz-algorithm", "x-amz-credential", "x-amz-date"} {
+			conditions = append(conditions, map[string]any{name: want[name]})
+		}
+		conditions = append(conditions, test.conditions[1:]...)
+		if !sameConditions(document.Conditions, conditions) {
+			t.Errorf("%v: got conditions %v, want %v", test.name, document.Conditions, conditions)
+		}
+	}
+}
+
+// sameConditions compares the conditions of a policy regardless of their order.
+func sameConditions(got []any, want []any) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	used := make([]bool, len(got))
+	for _, condition := range want {
+		found := false
+		for i := range got {
+			if !used[i] && reflect.DeepEqual(got[i], condition) {
+				used[i], found = true, true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -2,15 +2,18 @@ package repository
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/nevcodia/smarthub/domain"
 	"io"
 	"log"
-	"mime/multipart"
-	"os"
-	"path"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 )
@@ -18,20 +21,36 @@ import (
 type s3Repository struct {
 	client        *s3.Client
 	presignClient *s3.PresignClient
+	uploader      *manager.Uploader
+	config        aws.Config
+	endpoint      string
 }
 
-func NewS3Repository(client *s3.Client) domain.StorageRepository {
+// NewS3Repository talks to the S3 API at endpoint with path-style addressing. It uploads in parts
+// of partSize bytes, of which concurrency are sent at the same time.
+func NewS3Repository(config aws.Config, endpoint string, partSize int64, concurrency int) domain.StorageRepository {
+	client := s3.NewFromConfig(config, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(endpoint)
+		o.UsePathStyle = true
+	})
 	presignClient := s3.NewPresignClient(client)
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = partSize
+		u.Concurrency = concurrency
+	})
 	return &s3Repository{
 		client:        client,
 		presignClient: presignClient,
+		uploader:      uploader,
+		config:        config,
+		endpoint:      endpoint,
 	}
 }
 
 func (s *s3Repository) StoreNames() ([]string, error) {
 	buckets, err := s.client.ListBuckets(context.TODO(), &s3.ListBucketsInput{})
 	if err != nil {
-		log.Println("Couldn't list buckets for your account. Here's why: %v\n", err)
+		log.Printf("Couldn't list buckets for your account. Here's why: %v\n", err)
 		return nil, err
 	}
 	var bucketNames []string
@@ -41,18 +60,24 @@ func (s *s3Repository) StoreNames() ([]string, error) {
 	return bucketNames, nil
 }
 
-func (s *s3Repository) Objects(storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]domain.StorageObject, error) {
-	prefix = strings.TrimLeft(prefix, "/")
-	response, err := s.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket:  aws.String(storeName),
-		Prefix:  &prefix,
-		MaxKeys: &maxObjectsPerPage,
-	})
+// Objects hands out the continuation token of S3 itself as the next token.
+func (s *s3Repository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(storeName),
+		Prefix: aws.String(strings.TrimLeft(prefix, "/")),
+	}
+	if maxObjectsPerPage > 0 {
+		input.MaxKeys = &maxObjectsPerPage
+	}
+	if token != "" {
+		input.ContinuationToken = &token
+	}
+	response, err := s.client.ListObjectsV2(context.TODO(), input)
 	if err != nil {
 		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
-		return nil, err
+		return domain.ObjectPage{}, err
 	}
-	var storageObjects []domain.StorageObject
+	storageObjects := []domain.StorageObject{}
 	for _, content := range response.Contents {
 		storageObjects = append(storageObjects, domain.StorageObject{
 			StoreName:    storeName,
@@ -62,50 +87,96 @@ func (s *s3Repository) Objects(storeName string, maxObjectsPerPage int32, reques
 			Size:         *content.Size,
 		})
 	}
-	if storageObjects == nil {
-		storageObjects = []domain.StorageObject{}
+	return domain.ObjectPage{
+		Objects:     storageObjects,
+		IsTruncated: aws.ToBool(response.IsTruncated),
+		NextToken:   aws.ToString(response.NextContinuationToken),
+	}, nil
+}
+
+func (s *s3Repository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	page, err := s.Objects(storeName, maxObjectsPerPage, token, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
 	}
-	return storageObjects, nil
+	for i, object := range page.Objects {
+		metadataResponse, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(storeName),
+			Key:    aws.String(object.Key),
+		})
+		if err == nil {
+			page.Objects[i].Metadata = metadataResponse.Metadata
+		}
+	}
+	return page, nil
 }
 
-func (s *s3Repository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]domain.StorageObject, error) {
+func (s *s3Repository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	if delimiter == "" {
+		return s.Objects(storeName, maxObjectsPerPage, token, prefix)
+	}
 	prefix = strings.TrimLeft(prefix, "/")
-	response, err := s.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket:  aws.String(storeName),
-		Prefix:  &prefix,
-		MaxKeys: &maxObjectsPerPage,
-	})
+	input := &s3.ListObjectsV2Input{
+		Bucket:    aws.String(storeName),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String(delimiter),
+	}
+	if maxObjectsPerPage > 0 {
+		input.MaxKeys = &maxObjectsPerPage
+	}
+	if token != "" {
+		input.ContinuationToken = &token
+	}
+	response, err := s.client.ListObjectsV2(context.TODO(), input)
 	if err != nil {
 		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
-		return nil, err
+		return domain.ObjectPage{}, err
+	}
+	page := domain.ObjectPage{
+		Objects:     []domain.StorageObject{},
+		IsTruncated: aws.ToBool(response.IsTruncated),
+		NextToken:   aws.ToString(response.NextContinuationToken),
+	}
+	for _, commonPrefix := range response.CommonPrefixes {
+		page.Folders = append(page.Folders, newFolder(prefix, *commonPrefix.Prefix, delimiter))
 	}
-	var storageObjects []domain.StorageObject
 	for _, content := range response.Contents {
-		metadataResponse, _ := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
-			Bucket: aws.String(storeName),
-			Key:    content.Key,
-		})
-		storageObjects = append(storageObjects, domain.StorageObject{
+		page.Objects = append(page.Objects, domain.StorageObject{
 			StoreName:    storeName,
 			Key:          *content.Key,
 			LastModified: (*content.LastModified).UnixMilli(),
 			ETag:         *content.ETag,
 			Size:         *content.Size,
-			Metadata:     metadataResponse.Metadata,
 		})
 	}
-	if storageObjects == nil {
-		storageObjects = []domain.StorageObject{}
+	if withSizes {
+		err = addFolderSizes(&page, func(prefix string) ([]domain.StorageObject, error) {
+			return s.listAll(storeName, prefix)
+		})
+		if err != nil {
+			return domain.ObjectPage{}, err
+		}
 	}
-	return storageObjects, nil
+	return page, nil
 }
 
 func (s *s3Repository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
-	response, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
-		Bucket: aws.String(params.StoreName),
-		Key:    aws.String(params.Key),
-	})
+	input := &s3.HeadObjectInput{
+		Bucket:    aws.String(params.StoreName),
+		Key:       aws.String(params.Key),
+		VersionId: optionalString(params.VersionID),
+	}
+	if conditions := params.Conditions; conditions != nil {
+		input.IfMatch = optionalString(conditions.IfMatch)
+		input.IfNoneMatch = optionalString(conditions.IfNoneMatch)
+		input.IfModifiedSince = optionalTime(conditions.IfModifiedSince)
+		input.IfUnmodifiedSince = optionalTime(conditions.IfUnmodifiedSince)
+	}
+	response, err := s.client.HeadObject(context.TODO(), input)
 	if err != nil {
+		if unmet := preconditionError(err); unmet != nil {
+			return domain.StorageObject{}, unmet
+		}
 		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
 		return domain.StorageObject{}, err
 	}
@@ -116,26 +187,20 @@ func (s *s3Repository) GetObject(params *domain.ObjectParams) (domain.StorageObj
 		ETag:         *response.ETag,
 		Size:         *response.ContentLength,
 		Metadata:     response.Metadata,
+		VersionID:    aws.ToString(response.VersionId),
 	}, nil
 }
 
-func (s *s3Repository) UploadMultiPart(params *domain.ObjectParams, metadata map[string]string, fileHeader *multipart.FileHeader) (domain.StorageObject, error) {
-	file, err := fileHeader.Open()
-	if err != nil {
-		log.Println("Error opening file:", err)
-		return domain.StorageObject{}, err
-	}
-	defer file.Close()
-
-	return s.Upload(params, metadata, file)
-}
-
+// Upload goes through the transfer manager, which sends large streams as concurrent multipart
+// uploads and also takes streams of unknown length, like an upload request or the body of an
+// object opened on another connection.
 func (s *s3Repository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
-	response, err := s.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket:   aws.String(params.StoreName),
-		Key:      aws.String(params.Key),
-		Metadata: metadata,
-		Body:     file,
+	response, err := s.uploader.Upload(context.TODO(), &s3.PutObjectInput{
+		Bucket:      aws.String(params.StoreName),
+		Key:         aws.String(params.Key),
+		Metadata:    metadata,
+		ContentType: aws.String(uploadContentType(params)),
+		Body:        file,
 	})
 	if err != nil {
 		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
@@ -146,8 +211,9 @@ func (s *s3Repository) Upload(params *domain.ObjectParams, metadata map[string]s
 		StoreName:    params.StoreName,
 		Key:          params.Key,
 		LastModified: time.Now().UnixMilli(),
-		ETag:         *response.ETag,
+		ETag:         aws.ToString(response.ETag),
 		Metadata:     metadata,
+		VersionID:    aws.ToString(response.VersionID),
 	}, nil
 }
 
@@ -168,32 +234,46 @@ func (s *s3Repository) PresignUploadLink(params *domain.ObjectParams, mimeType s
 	return request.URL, err
 }
 
-func (s *s3Repository) Download(params *domain.ObjectParams) (domain.DownloadFileResponse, error) {
-	result, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(params.StoreName),
-		Key:    aws.String(params.Key),
-	})
-	filename := path.Base(params.Key)
-	if err != nil {
-		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
-		return domain.DownloadFileResponse{}, err
+func (s *s3Repository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	input := &s3.GetObjectInput{
+		Bucket:    aws.String(params.StoreName),
+		Key:       aws.String(params.Key),
+		VersionId: optionalString(params.VersionID),
 	}
-	defer result.Body.Close()
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Printf("Couldn't create file %v. Here's why: %v\n", filename, err)
-		return domain.DownloadFileResponse{}, err
+	if byteRange != nil {
+		input.Range = aws.String(rangeHeader(byteRange))
+	}
+	if conditions := params.Conditions; conditions != nil {
+		input.IfMatch = optionalString(conditions.IfMatch)
+		input.IfNoneMatch = optionalString(conditions.IfNoneMatch)
+		input.IfModifiedSince = optionalTime(conditions.IfModifiedSince)
+		input.IfUnmodifiedSince = optionalTime(conditions.IfUnmodifiedSince)
 	}
-	defer file.Close()
-	_, err = io.Copy(file, result.Body)
+	result, err := s.client.GetObject(context.TODO(), input)
 	if err != nil {
-		log.Printf("Couldn't read object body from %v. Here's why: %v\n", params.Key, err)
+		if unmet := preconditionError(err); unmet != nil {
+			return domain.ObjectContent{}, unmet
+		}
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	size := aws.ToInt64(result.ContentLength)
+	if byteRange != nil {
+		size = rangeTotal(aws.ToString(result.ContentRange), size)
 	}
-	return domain.DownloadFileResponse{
-		Filename:    filename,
-		Type:        *result.ContentType,
-		Disposition: "inline;filename=" + filename,
-	}, err
+	return domain.ObjectContent{
+		Object: domain.StorageObject{
+			StoreName:    params.StoreName,
+			Key:          params.Key,
+			LastModified: aws.ToTime(result.LastModified).UnixMilli(),
+			ETag:         aws.ToString(result.ETag),
+			Size:         size,
+			Metadata:     result.Metadata,
+			VersionID:    aws.ToString(result.VersionId),
+		},
+		ContentType: aws.ToString(result.ContentType),
+		Body:        result.Body,
+	}, nil
 }
 
 func (s *s3Repository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
@@ -202,8 +282,9 @@ func (s *s3Repository) PresignDownloadLink(params *domain.ObjectParams) (string,
 
 func (s *s3Repository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
 	request, err := s.presignClient.PresignGetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(params.StoreName),
-		Key:    aws.String(params.Key),
+		Bucket:    aws.String(params.StoreName),
+		Key:       aws.String(params.Key),
+		VersionId: optionalString(params.VersionID),
 	}, func(opts *s3.PresignOptions) {
 		opts.Expires = time.Duration(exp * uint(time.Millisecond))
 	})
@@ -215,29 +296,112 @@ func (s *s3Repository) PresignDownloadLinkWithExpTime(params *domain.ObjectParam
 	return request.URL, err
 }
 
-func (s *s3Repository) DeleteAll(storeName string, pathPrefix string) (bool, error) {
-	//TODO implement me
-	panic("implement me")
+// DeleteAll pages through the prefix and deletes each page of up to 1000 keys with one
+// DeleteObjects call, keeping bulkConcurrency calls in flight while listing goes on.
+func (s *s3Repository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	pathPrefix = strings.TrimLeft(pathPrefix, "/")
+	report := newDeleteReport(storeName, pathPrefix, dryRun)
+	batches := make(chan []string)
+	var listErr error
+	go func() {
+		defer close(batches)
+		paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(storeName),
+			Prefix:  aws.String(pathPrefix),
+			MaxKeys: aws.Int32(1000),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(context.TODO())
+			if err != nil {
+				log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+				listErr = err
+				return
+			}
+			batch := make([]string, 0, len(page.Contents))
+			for _, content := range page.Contents {
+				batch = append(batch, *content.Key)
+			}
+			if len(batch) > 0 {
+				batches <- batch
+			}
+		}
+	}()
+	forEachConcurrently(batches, bulkConcurrency, func(batch []string) {
+		if dryRun {
+			for _, key := range batch {
+				report.add(key, nil)
+			}
+			return
+		}
+		s.deleteBatch(storeName, batch, report)
+	})
+	// a listing error leaves the keys of earlier pages deleted, which the report still lists
+	return report.done(), listErr
+}
+
+// deleteBatch deletes up to 1000 keys at once. In quiet mode S3 only reports the keys it
+// couldn't delete.
+func (s *s3Repository) deleteBatch(storeName string, keys []string, report *deleteReport) {
+	identifiers := make([]types.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		identifiers = append(identifiers, types.ObjectIdentifier{Key: aws.String(key)})
+	}
+	response, err := s.client.DeleteObjects(context.TODO(), &s3.DeleteObjectsInput{
+		Bucket: aws.String(storeName),
+		Delete: &types.Delete{Objects: identifiers, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		log.Printf("Couldn't delete objects from %v. Here's why: %v\n", storeName, err)
+		for _, key := range keys {
+			report.add(key, err)
+		}
+		return
+	}
+	failed := map[string]error{}
+	for _, failure := range response.Errors {
+		failed[aws.ToString(failure.Key)] = fmt.Errorf("%v: %v", aws.ToString(failure.Code), aws.ToString(failure.Message))
+	}
+	for _, key := range keys {
+		report.add(key, failed[key])
+	}
 }
 
+// Delete leaves a delete marker in a versioned bucket, unless it deletes a version.
 func (s *s3Repository) Delete(params *domain.ObjectParams) (bool, error) {
-	response, err := s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(params.StoreName),
-		Key:    aws.String(params.Key),
+	_, err := s.client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
+		Bucket:    aws.String(params.StoreName),
+		Key:       aws.String(params.Key),
+		VersionId: optionalString(params.VersionID),
 	})
 	if err != nil {
 		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
 			params.StoreName, params.Key, err)
 		return false, err
 	}
-	return *response.DeleteMarker, nil
+	return true, nil
 }
 
+// copyObjectLimit is the size of the largest object a single CopyObject copies. Tests shrink it.
+var copyObjectLimit int64 = 5 << 30
+
+// Copy copies server side. Objects larger than a single CopyObject copies are copied in parts.
 func (s *s3Repository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
-	_, err := s.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
+	head, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket:    aws.String(current.StoreName),
+		Key:       aws.String(current.Key),
+		VersionId: optionalString(current.VersionID),
+	})
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", current.StoreName, current.Key, err)
+		return domain.StorageObject{}, err
+	}
+	if aws.ToInt64(head.ContentLength) > copyObjectLimit {
+		return s.copyInParts(current, destination, head)
+	}
+	response, err := s.client.CopyObject(context.TODO(), &s3.CopyObjectInput{
 		Bucket:     aws.String(destination.StoreName),
 		Key:        aws.String(destination.Key),
-		CopySource: aws.String(fmt.Sprintf("%v/%v", current.StoreName, strings.TrimLeft(current.Key, "/"))),
+		CopySource: aws.String(copySource(current)),
 	})
 	if err != nil {
 		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
@@ -247,25 +411,150 @@ func (s *s3Repository) Copy(current *domain.ObjectParams, destination *domain.Ob
 	return domain.StorageObject{
 		StoreName:    destination.StoreName,
 		Key:          destination.Key,
-		LastModified: time.Now().UnixMilli(),
+		LastModified: aws.ToTime(response.CopyObjectResult.LastModified).UnixMilli(),
+		ETag:         aws.ToString(response.CopyObjectResult.ETag),
+		Size:         aws.ToInt64(head.ContentLength),
+		Metadata:     head.Metadata,
+		VersionID:    aws.ToString(response.VersionId),
 	}, nil
 }
 
-func (s *s3Repository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) ([]domain.StorageObject, error) {
-	panic("implement me")
+// CopyAll copies server side with CopyObject, which keeps the metadata of every object.
+func (s *s3Repository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		s.listAll,
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := s.Copy(current, destination)
+			return err
+		})
 }
 
-func (s *s3Repository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
-	_, err := s.Copy(current, destination)
-	_, err = s.Delete(current)
+// listAll pages through every object below prefix.
+func (s *s3Repository) listAll(storeName string, prefix string) ([]domain.StorageObject, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(storeName),
+		Prefix: aws.String(prefix),
+	})
+	storageObjects := []domain.StorageObject{}
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return nil, err
+		}
+		for _, content := range page.Contents {
+			storageObjects = append(storageObjects, domain.StorageObject{
+				StoreName:    storeName,
+				Key:          *content.Key,
+				LastModified: (*content.LastModified).UnixMilli(),
+				ETag:         *content.ETag,
+				Size:         *content.Size,
+			})
+		}
+	}
+	return storageObjects, nil
+}
+
+// CopyFrom copies server side from another S3 connection to the same endpoint and region with the
+// same access key, since this connection can read its buckets just as well.
+func (s *s3Repository) CopyFrom(source domain.StorageRepository, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	other, ok := source.(*s3Repository)
+	if !ok || !s.sameAccount(other) {
+		return domain.StorageObject{}, domain.ErrNotSupported
+	}
+	return s.Copy(current, destination)
+}
+
+// sameAccount reports whether other talks to the same endpoint and region with the same access key.
+func (s *s3Repository) sameAccount(other *s3Repository) bool {
+	if s.endpoint != other.endpoint || s.config.Region != other.config.Region ||
+		s.config.Credentials == nil || other.config.Credentials == nil {
+		return false
+	}
+	credentials, err := s.config.Credentials.Retrieve(context.TODO())
 	if err != nil {
-		log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
-			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
-		return domain.StorageObject{}, err
+		return false
 	}
-	return domain.StorageObject{
-		StoreName:    destination.StoreName,
-		Key:          destination.Key,
-		LastModified: time.Now().UnixMilli(),
-	}, nil
+	otherCredentials, err := other.config.Credentials.Retrieve(context.TODO())
+	return err == nil && credentials.AccessKeyID == otherCredentials.AccessKeyID
+}
+
+// Move copies the object and deletes the source once the copy is verified, objects can't be renamed.
+func (s *s3Repository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	return moveByCopy(s, current, destination, s.sameContent)
+}
+
+// sameContent compares the ETags of the copy and its source where they are MD5 digests of the
+// content, which they aren't for multipart uploads and objects encrypted with KMS or customer keys.
+func (s *s3Repository) sameContent(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+	etags := make([]string, 0, 2)
+	for _, params := range []*domain.ObjectParams{current, destination} {
+		head, err := s.client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+			Bucket: aws.String(params.StoreName),
+			Key:    aws.String(params.Key),
+		})
+		if err != nil {
+			return err
+		}
+		etag := aws.ToString(head.ETag)
+		if strings.Contains(etag, "-") || strings.HasPrefix(string(head.ServerSideEncryption), "aws:kms") || head.SSECustomerAlgorithm != nil {
+			return nil
+		}
+		etags = append(etags, etag)
+	}
+	if etags[0] != etags[1] {
+		return domain.ErrCopyMismatch
+	}
+	return nil
+}
+
+// copySource URL-encodes bucket and key for the x-amz-copy-source header. Slashes stay as they
+// are, "+" is encoded as well since some servers decode it as a space. A version is selected
+// with the versionId parameter.
+func copySource(params *domain.ObjectParams) string {
+	segments := strings.Split(params.StoreName+"/"+strings.TrimLeft(params.Key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	if params.VersionID != "" {
+		return strings.Join(segments, "/") + "?versionId=" + url.QueryEscape(params.VersionID)
+	}
+	return strings.Join(segments, "/")
+}
+
+// preconditionError turns the answers of S3 to unmet preconditions into the errors of the domain,
+// a 304 keeps the validators it was sent with. It returns nil for any other error.
+func preconditionError(err error) error {
+	var responseError *awshttp.ResponseError
+	if errors.As(err, &responseError) {
+		switch responseError.HTTPStatusCode() {
+		case http.StatusNotModified:
+			notModified := &domain.NotModifiedError{}
+			if responseError.Response != nil && responseError.Response.Response != nil {
+				header := responseError.Response.Header
+				notModified.Object.ETag = header.Get("ETag")
+				if modified, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+					notModified.Object.LastModified = modified.UnixMilli()
+				}
+			}
+			return notModified
+		case http.StatusPreconditionFailed:
+			return domain.ErrPreconditionFailed
+		}
+	}
+	return nil
+}
+
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func optionalTime(value time.Time) *time.Time {
+	if value.IsZero() {
+		return nil
+	}
+	return &value
 }
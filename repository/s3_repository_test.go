@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/nevcodia/smarthub/domain"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestS3Repository(endpoint string, region string, accessKey string) *s3Repository {
+	return NewS3Repository(aws.Config{
+		Region:      region,
+		Credentials: credentials.NewStaticCredentialsProvider(accessKey, "secret", ""),
+	}, endpoint, 5<<20, 1).(*s3Repository)
+}
+
+// fakeCopies answers HeadObject and CopyObject and records the copy sources.
+type fakeCopies struct {
+	lock    sync.Mutex
+	sources []string
+}
+
+func (f *fakeCopies) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodHead:
+		w.Header().Set("Content-Length", "5")
+		w.Header().Set("ETag", `"source"`)
+	case r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != "":
+		f.lock.Lock()
+		f.sources = append(f.sources, r.Header.Get("x-amz-copy-source"))
+		f.lock.Unlock()
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<CopyObjectResult><ETag>"copy"</ETag><LastModified>2024-01-02T03:04:05.000Z</LastModified></CopyObjectResult>`))
+	default:
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+	}
+}
+
+func TestS3CopyFrom(t *testing.T) {
+	copies := &fakeCopies{}
+	server := httptest.NewServer(copies)
+	defer server.Close()
+	target := newTestS3Repository(server.URL, "eu-west-1", "key")
+	current := &domain.ObjectParams{StoreName: "files", Key: "a.txt"}
+	destination := &domain.ObjectParams{StoreName: "other", Key: "b.txt"}
+
+	object, err := target.CopyFrom(newTestS3Repository(server.URL, "eu-west-1", "key"), current, destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.StoreName != "other" || object.Key != "b.txt" || object.ETag != `"copy"` || object.Size != 5 {
+		t.Fatalf("unexpected copy %+v", object)
+	}
+	if len(copies.sources) != 1 || copies.sources[0] != "files/a.txt" {
+		t.Fatalf("copied from %v", copies.sources)
+	}
+
+	for name, source := range map[string]domain.StorageRepository{
+		"other access key": newTestS3Repository(server.URL, "eu-west-1", "other"),
+		"other region":     newTestS3Repository(server.URL, "us-east-1", "key"),
+		"other endpoint":   newTestS3Repository("http://s3.test", "eu-west-1", "key"),
+		"other backend":    newTestMemoryRepository(),
+	} {
+		if _, err = target.CopyFrom(source, current, destination); !errors.Is(err, domain.ErrNotSupported) {
+			t.Errorf("%v: got %v, want ErrNotSupported", name, err)
+		}
+	}
+	if len(copies.sources) != 1 {
+		t.Fatalf("copied server side from another account: %v", copies.sources)
+	}
+}
+
+func TestS3DeleteAllTrimsPrefix(t *testing.T) {
+	var prefixes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		prefixes = append(prefixes, r.URL.Query().Get("prefix"))
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<ListBucketResult><Name>files</Name><KeyCount>1</KeyCount><IsTruncated>false</IsTruncated>` +
+			`<Contents><Key>logs/a.txt</Key><Size>1</Size></Contents></ListBucketResult>`))
+	}))
+	defer server.Close()
+	report, err := newTestS3Repository(server.URL, "eu-west-1", "key").DeleteAll("files", "/logs/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 1 || prefixes[0] != "logs/" || report.Prefix != "logs/" || len(report.Results) != 1 {
+		t.Fatalf("listed %v and reported %+v", prefixes, report)
+	}
+}
+
+func TestS3NotModifiedKeepsValidators(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != `"abc"` {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", "Tue, 02 Jan 2024 03:04:05 GMT")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+	_, err := newTestS3Repository(server.URL, "eu-west-1", "key").GetObject(&domain.ObjectParams{
+		StoreName:  "files",
+		Key:        "a.txt",
+		Conditions: &domain.Conditions{IfNoneMatch: `"abc"`},
+	})
+	var notModified *domain.NotModifiedError
+	if !errors.As(err, &notModified) || !errors.Is(err, domain.ErrNotModified) {
+		t.Fatalf("got %v, want a NotModifiedError", err)
+	}
+	if notModified.Object.ETag != `"abc"` || notModified.Object.LastModified != time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).UnixMilli() {
+		t.Fatalf("not modified %+v", notModified.Object)
+	}
+}
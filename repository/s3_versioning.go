@@ -0,0 +1,119 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/nevcodia/smarthub/domain"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Versions lists the versions and delete markers below prefix. S3 continues a listing of versions
+// after a key and version id, which the next token carries both.
+func (s *s3Repository) Versions(storeName string, maxVersionsPerPage int32, token string, prefix string) (domain.VersionPage, error) {
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(storeName),
+		Prefix: aws.String(strings.TrimLeft(prefix, "/")),
+	}
+	if maxVersionsPerPage > 0 {
+		input.MaxKeys = &maxVersionsPerPage
+	}
+	if token != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil {
+			return domain.VersionPage{}, domain.ErrPageTokenInvalid
+		}
+		keyMarker, versionIDMarker, found := strings.Cut(string(decoded), "\x00")
+		if !found {
+			return domain.VersionPage{}, domain.ErrPageTokenInvalid
+		}
+		input.KeyMarker = aws.String(keyMarker)
+		input.VersionIdMarker = optionalString(versionIDMarker)
+	}
+	response, err := s.client.ListObjectVersions(context.TODO(), input)
+	if err != nil {
+		log.Printf("Couldn't get the versions of objects from %v. Here's why: %v\n", storeName, err)
+		return domain.VersionPage{}, err
+	}
+	versions := make([]domain.ObjectVersion, 0, len(response.Versions)+len(response.DeleteMarkers))
+	for _, version := range response.Versions {
+		versions = append(versions, domain.ObjectVersion{
+			StorageObject: domain.StorageObject{
+				StoreName:    storeName,
+				Key:          aws.ToString(version.Key),
+				LastModified: aws.ToTime(version.LastModified).UnixMilli(),
+				ETag:         aws.ToString(version.ETag),
+				Size:         aws.ToInt64(version.Size),
+				VersionID:    aws.ToString(version.VersionId),
+				IsLatest:     aws.ToBool(version.IsLatest),
+			},
+		})
+	}
+	for _, marker := range response.DeleteMarkers {
+		versions = append(versions, domain.ObjectVersion{
+			StorageObject: domain.StorageObject{
+				StoreName:    storeName,
+				Key:          aws.ToString(marker.Key),
+				LastModified: aws.ToTime(marker.LastModified).UnixMilli(),
+				VersionID:    aws.ToString(marker.VersionId),
+				IsLatest:     aws.ToBool(marker.IsLatest),
+			},
+			IsDeleteMarker: true,
+		})
+	}
+	// S3 lists versions and delete markers apart, each by key and the newest first
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].Key != versions[j].Key {
+			return versions[i].Key < versions[j].Key
+		}
+		return versions[i].LastModified > versions[j].LastModified
+	})
+	page := domain.VersionPage{
+		Versions:    versions,
+		IsTruncated: aws.ToBool(response.IsTruncated),
+	}
+	if page.IsTruncated && aws.ToString(response.NextKeyMarker) != "" {
+		page.NextToken = base64.RawURLEncoding.EncodeToString(
+			[]byte(aws.ToString(response.NextKeyMarker) + "\x00" + aws.ToString(response.NextVersionIdMarker)))
+	}
+	return page, nil
+}
+
+func (s *s3Repository) Versioning(storeName string) (domain.Versioning, error) {
+	response, err := s.client.GetBucketVersioning(context.TODO(), &s3.GetBucketVersioningInput{
+		Bucket: aws.String(storeName),
+	})
+	if err != nil {
+		log.Printf("Couldn't get the versioning of %v. Here's why: %v\n", storeName, err)
+		return domain.Versioning{}, err
+	}
+	status := domain.VersioningUnversioned
+	switch response.Status {
+	case types.BucketVersioningStatusEnabled:
+		status = domain.VersioningEnabled
+	case types.BucketVersioningStatusSuspended:
+		status = domain.VersioningSuspended
+	}
+	return domain.Versioning{StoreName: storeName, Status: status}, nil
+}
+
+// SetVersioning enables or suspends versioning. Suspending it keeps the versions there are.
+func (s *s3Repository) SetVersioning(storeName string, enabled bool) (domain.Versioning, error) {
+	status := types.BucketVersioningStatusSuspended
+	if enabled {
+		status = types.BucketVersioningStatusEnabled
+	}
+	_, err := s.client.PutBucketVersioning(context.TODO(), &s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(storeName),
+		VersioningConfiguration: &types.VersioningConfiguration{Status: status},
+	})
+	if err != nil {
+		log.Printf("Couldn't set the versioning of %v to %v. Here's why: %v\n", storeName, status, err)
+		return domain.Versioning{}, err
+	}
+	return s.Versioning(storeName)
+}
@@ -0,0 +1,80 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// listVersionsResult lists the versions and delete markers of a.txt and b.txt apart, as S3 does.
+const listVersionsResult = `<ListVersionsResult>
+<Name>files</Name><IsTruncated>true</IsTruncated>
+<NextKeyMarker>b.txt</NextKeyMarker><NextVersionIdMarker>b1</NextVersionIdMarker>
+<Version><Key>a.txt</Key><VersionId>a3</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-03T00:00:00.000Z</LastModified><ETag>"e3"</ETag><Size>3</Size></Version>
+<Version><Key>a.txt</Key><VersionId>a1</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-01T00:00:00.000Z</LastModified><ETag>"e1"</ETag><Size>1</Size></Version>
+<Version><Key>b.txt</Key><VersionId>b1</VersionId><IsLatest>true</IsLatest><LastModified>2024-01-02T00:00:00.000Z</LastModified><ETag>"e2"</ETag><Size>2</Size></Version>
+<DeleteMarker><Key>a.txt</Key><VersionId>a4</VersionId><IsLatest>true</IsLatest><LastModified>2024-01-04T00:00:00.000Z</LastModified></DeleteMarker>
+<DeleteMarker><Key>a.txt</Key><VersionId>a2</VersionId><IsLatest>false</IsLatest><LastModified>2024-01-02T00:00:00.000Z</LastModified></DeleteMarker>
+</ListVersionsResult>`
+
+func TestS3Versions(t *testing.T) {
+	var queries []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.Query())
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(listVersionsResult))
+	}))
+	defer server.Close()
+	repository := newTestS3Repository(server.URL, "eu-west-1", "key")
+
+	page, err := repository.Versions("files", 5, "", "/docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, version := range page.Versions {
+		entry := version.Key + "@" + version.VersionID
+		if version.IsDeleteMarker {
+			entry += " deleted"
+		}
+		if version.IsLatest {
+			entry += " latest"
+		}
+		got = append(got, entry)
+	}
+	want := "a.txt@a4 deleted latest,a.txt@a3,a.txt@a2 deleted,a.txt@a1,b.txt@b1 latest"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got versions %v, want %v", got, want)
+	}
+	if version := page.Versions[1]; version.ETag != `"e3"` || version.Size != 3 || version.StoreName != "files" {
+		t.Fatalf("unexpected version %+v", version)
+	}
+	query := queries[0]
+	if query.Get("prefix") != "docs/" || query.Get("max-keys") != "5" || query.Has("key-marker") {
+		t.Fatalf("listed with %v", query)
+	}
+	if !page.IsTruncated || page.NextToken == "" {
+		t.Fatalf("page isn't truncated: %+v", page)
+	}
+
+	// the token continues after the key and version id of the last version listed
+	if _, err = repository.Versions("files", 5, page.NextToken, "/docs/"); err != nil {
+		t.Fatal(err)
+	}
+	if query = queries[1]; query.Get("key-marker") != "b.txt" || query.Get("version-id-marker") != "b1" {
+		t.Fatalf("continued with %v", query)
+	}
+	for _, token := range []string{"not base64!", base64.RawURLEncoding.EncodeToString([]byte("b.txt"))} {
+		if _, err = repository.Versions("files", 5, token, ""); !errors.Is(err, domain.ErrPageTokenInvalid) {
+			t.Errorf("token %q: got %v, want ErrPageTokenInvalid", token, err)
+		}
+	}
+	if len(queries) != 2 {
+		t.Fatalf("invalid tokens were sent to S3: %v", queries[2:])
+	}
+}
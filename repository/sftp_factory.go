@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SFTP stores objects as files below the directories of an SFTP server.
+const SFTP domain.StorageType = "sftp"
+
+func init() {
+	domain.RegisterStorage(SFTP, newSFTPStorage)
+}
+
+func newSFTPStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	var auth []ssh.AuthMethod
+	if privateKey := config.Credential("private_key"); privateKey != "" {
+		key, err := os.ReadFile(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the private key: %w", err)
+		}
+		var signer ssh.Signer
+		if passphrase := config.Credential("private_key_passphrase"); passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't parse the private key: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password := config.Credential("password"); password != "" {
+		auth = append(auth, ssh.Password(password))
+	}
+	hostKeyCallback, err := newHostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
+
+	clientConfig := &ssh.ClientConfig{
+		User:            config.Credential("user"),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	}
+	dial := func() (*ssh.Client, error) {
+		return ssh.Dial("tcp", config.Endpoint, clientConfig)
+	}
+	return NewSFTPRepository(dial, remoteRoots(config.Option("roots"))), nil
+}
+
+// newHostKeyCallback checks host keys against the known_hosts option, or ~/.ssh/known_hosts when it isn't set.
+func newHostKeyCallback(config domain.ConnectionConfig) (ssh.HostKeyCallback, error) {
+	insecure, err := config.BoolOption("insecure_ignore_host_key")
+	if err != nil {
+		return nil, err
+	}
+	if insecure {
+		log.Printf("Connection %v doesn't verify SFTP host keys, don't use insecure_ignore_host_key in production\n", config.Name)
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	knownHosts := config.Option("known_hosts")
+	if knownHosts == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("known_hosts is not set and the home directory is unknown: %w", err)
+		}
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("can't load known hosts: %w", err)
+	}
+	return callback, nil
+}
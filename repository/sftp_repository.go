@@ -0,0 +1,395 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type sftpRepository struct {
+	dial   func() (*ssh.Client, error)
+	roots  map[string]string
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+// NewSFTPRepository exposes the configured root directories of an SFTP server as stores.
+// A single SSH connection is shared by all operations and dialed again once it drops.
+func NewSFTPRepository(dial func() (*ssh.Client, error), roots map[string]string) domain.StorageRepository {
+	return &sftpRepository{
+		dial:  dial,
+		roots: roots,
+	}
+}
+
+func (s *sftpRepository) StoreNames() ([]string, error) {
+	storeNames := make([]string, 0, len(s.roots))
+	for name := range s.roots {
+		storeNames = append(storeNames, name)
+	}
+	sort.Strings(storeNames)
+	return storeNames, nil
+}
+
+func (s *sftpRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := s.list(storeName, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+// ObjectsWithMetadata is the same as Objects, SFTP has no object metadata.
+func (s *sftpRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	return s.Objects(storeName, maxObjectsPerPage, token, prefix)
+}
+
+func (s *sftpRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	storageObjects, err := s.list(storeName, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return browseOf(storageObjects, maxObjectsPerPage, token, prefix, delimiter, withSizes)
+}
+
+func (s *sftpRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	remotePath, err := s.remotePath(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	info, err := client.Stat(remotePath)
+	if err == nil && info.IsDir() {
+		err = fmt.Errorf("%v is a directory", params.Key)
+	}
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return s.toStorageObject(params.StoreName, cleanKey(params.Key), info), nil
+}
+
+// Upload streams file into a temporary file next to the target and renames it into place.
+// Metadata is ignored because SFTP can't store it.
+func (s *sftpRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	remotePath, err := s.remotePath(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if err = s.write(client, remotePath, file); err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	return s.GetObject(params)
+}
+
+func (s *sftpRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (s *sftpRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	object, err := s.GetObject(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	if err = params.Conditions.Check(object); err != nil {
+		return domain.ObjectContent{}, err
+	}
+	remotePath, err := s.remotePath(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	source, err := client.Open(remotePath)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	var body io.ReadCloser = source
+	if byteRange != nil {
+		if _, err = source.Seek(byteRange.Offset, io.SeekStart); err != nil {
+			source.Close()
+			log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+			return domain.ObjectContent{}, err
+		}
+		body = limitBody(source, byteRange.Length)
+	}
+	return domain.ObjectContent{
+		Object:      object,
+		ContentType: contentTypeOf(params.Key),
+		Body:        body,
+	}, nil
+}
+
+func (s *sftpRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (s *sftpRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (s *sftpRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	objects, err := s.list(storeName, pathPrefix)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	return deleteEach(storeName, pathPrefix, objects, dryRun, func(key string) error {
+		_, err := s.Delete(&domain.ObjectParams{StoreName: storeName, Key: key})
+		return err
+	}), nil
+}
+
+func (s *sftpRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	remotePath, err := s.remotePath(params)
+	if err != nil {
+		return false, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return false, err
+	}
+	if err = client.Remove(remotePath); err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy streams the object through the hub, SFTP has no portable server side copy.
+func (s *sftpRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentPath, err := s.remotePath(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationPath, err := s.remotePath(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	source, err := client.Open(currentPath)
+	if err == nil {
+		err = s.write(client, destinationPath, source)
+		source.Close()
+	}
+	if err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return s.GetObject(destination)
+}
+
+func (s *sftpRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		func(storeName string, prefix string) ([]domain.StorageObject, error) {
+			return s.list(storeName, prefix)
+		},
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := s.Copy(current, destination)
+			return err
+		})
+}
+
+// Move renames the object on the server, the roots of all stores live on the same server.
+func (s *sftpRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentPath, err := s.remotePath(current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationPath, err := s.remotePath(destination)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if err = client.MkdirAll(path.Dir(destinationPath)); err == nil {
+		err = rename(client, currentPath, destinationPath)
+	}
+	if err != nil {
+		log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return s.GetObject(destination)
+}
+
+func (s *sftpRepository) list(storeName string, prefix string) ([]domain.StorageObject, error) {
+	root, err := s.root(storeName)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	storageObjects := []domain.StorageObject{}
+	walker := client.Walk(walkRoot(root, prefix))
+	for walker.Step() {
+		if err = walker.Err(); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return nil, err
+		}
+		info := walker.Stat()
+		key := keyOf(root, walker.Path())
+		if info.IsDir() {
+			if key != "" && !canContain(key, prefix) {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() || isInternalName(info.Name()) || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		storageObjects = append(storageObjects, s.toStorageObject(storeName, key, info))
+	}
+	sort.Slice(storageObjects, func(i, j int) bool {
+		return storageObjects[i].Key < storageObjects[j].Key
+	})
+	return storageObjects, nil
+}
+
+// write streams content into a temporary file next to remotePath and renames it into place.
+func (s *sftpRepository) write(client *sftp.Client, remotePath string, content io.Reader) error {
+	dir := path.Dir(remotePath)
+	if err := client.MkdirAll(dir); err != nil {
+		return err
+	}
+	tempPath := path.Join(dir, fmt.Sprintf("%v%v-%v", uploadTempPrefix, path.Base(remotePath), randomSuffix()))
+	file, err := client.Create(tempPath)
+	if err != nil {
+		return err
+	}
+	_, err = file.ReadFrom(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = rename(client, tempPath, remotePath)
+	}
+	if err != nil {
+		_ = client.Remove(tempPath)
+	}
+	return err
+}
+
+// sftpClient returns the shared client, connecting first when there is none or the last one dropped.
+func (s *sftpRepository) sftpClient() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	conn, err := s.dial()
+	if err != nil {
+		log.Printf("Couldn't connect to sftp server. Here's why: %v\n", err)
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		conn.Close()
+		log.Printf("Couldn't start sftp session. Here's why: %v\n", err)
+		return nil, err
+	}
+	s.client = client
+	go func() {
+		_ = client.Wait()
+		conn.Close()
+		s.mu.Lock()
+		if s.client == client {
+			s.client = nil
+		}
+		s.mu.Unlock()
+	}()
+	return client, nil
+}
+
+func (s *sftpRepository) toStorageObject(storeName string, key string, info fs.FileInfo) domain.StorageObject {
+	return domain.StorageObject{
+		StoreName:    storeName,
+		Key:          key,
+		LastModified: info.ModTime().UnixMilli(),
+		ETag:         fmt.Sprintf("\"%x-%x\"", info.ModTime().Unix(), info.Size()),
+		Size:         info.Size(),
+	}
+}
+
+func (s *sftpRepository) root(storeName string) (string, error) {
+	root, ok := s.roots[storeName]
+	if !ok {
+		return "", fmt.Errorf("store %v is not configured", storeName)
+	}
+	return root, nil
+}
+
+func (s *sftpRepository) remotePath(params *domain.ObjectParams) (string, error) {
+	root, err := s.root(params.StoreName)
+	if err != nil {
+		return "", err
+	}
+	key := cleanKey(params.Key)
+	if key == "" || isInternalName(path.Base(key)) {
+		return "", fmt.Errorf("%v is not a valid key", params.Key)
+	}
+	return joinKey(root, key), nil
+}
+
+// rename replaces newPath atomically where the server supports posix-rename@openssh.com.
+// Plain SFTP rename refuses to overwrite, so an existing target is set aside under an internal
+// name first, put back if the rename fails and only removed once oldPath took its place.
+func rename(client *sftp.Client, oldPath string, newPath string) error {
+	err := client.PosixRename(oldPath, newPath)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := client.Stat(oldPath); statErr != nil {
+		return err
+	}
+	if _, statErr := client.Lstat(newPath); errors.Is(statErr, fs.ErrNotExist) {
+		return client.Rename(oldPath, newPath)
+	}
+	asidePath := path.Join(path.Dir(newPath), fmt.Sprintf("%v%v-%v", uploadTempPrefix, path.Base(newPath), randomSuffix()))
+	if err = client.Rename(newPath, asidePath); err != nil {
+		return err
+	}
+	if err = client.Rename(oldPath, newPath); err != nil {
+		if restoreErr := client.Rename(asidePath, newPath); restoreErr != nil {
+			log.Printf("Couldn't restore %v from %v. Here's why: %v\n", newPath, asidePath, restoreErr)
+		}
+		return err
+	}
+	if err = client.Remove(asidePath); err != nil {
+		log.Printf("Couldn't remove the replaced %v. Here's why: %v\n", asidePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+package repository
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// serveSFTP runs an SSH server accepting any password, which hands sftp subsystems to serve.
+func serveSFTP(t *testing.T, serve func(channel ssh.Channel)) func() (*ssh.Client, error) {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, channels, requests, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					return
+				}
+				go ssh.DiscardRequests(requests)
+				for newChannel := range channels {
+					if newChannel.ChannelType() != "session" {
+						newChannel.Reject(ssh.UnknownChannelType, "only sessions are served")
+						continue
+					}
+					channel, channelRequests, err := newChannel.Accept()
+					if err != nil {
+						continue
+					}
+					go func() {
+						for request := range channelRequests {
+							isSFTP := request.Type == "subsystem" && len(request.Payload) > 4 && string(request.Payload[4:]) == "sftp"
+							request.Reply(isSFTP, nil)
+							if isSFTP {
+								go serve(channel)
+							}
+						}
+					}()
+				}
+			}()
+		}
+	}()
+
+	return func() (*ssh.Client, error) {
+		return ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            "test",
+			Auth:            []ssh.AuthMethod{ssh.Password("test")},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+	}
+}
+
+// newTestSFTPRepository serves the stores "files" and "other" from a temporary directory.
+func newTestSFTPRepository(t *testing.T) (domain.StorageRepository, string) {
+	t.Helper()
+	dir := t.TempDir()
+	roots := map[string]string{}
+	for _, root := range []string{"files", "other"} {
+		if err := os.Mkdir(filepath.Join(dir, root), 0700); err != nil {
+			t.Fatal(err)
+		}
+		roots[root] = filepath.ToSlash(filepath.Join(dir, root))
+	}
+	dial := serveSFTP(t, func(channel ssh.Channel) {
+		server, err := sftp.NewServer(channel)
+		if err != nil {
+			channel.Close()
+			return
+		}
+		_ = server.Serve()
+		server.Close()
+	})
+	return NewSFTPRepository(dial, roots), dir
+}
+
+// plainRenamer makes the in-memory server behave like one without posix-rename@openssh.com,
+// whose rename refuses to overwrite. Renames of failRename fail.
+type plainRenamer struct {
+	sftp.FileCmder
+	files      sftp.FileLister
+	failRename string
+}
+
+func (p *plainRenamer) PosixRename(r *sftp.Request) error {
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func (p *plainRenamer) Filecmd(r *sftp.Request) error {
+	if r.Method == "Rename" {
+		if r.Filepath == p.failRename {
+			return errors.New("rename failed")
+		}
+		if _, err := p.files.Filelist(sftp.NewRequest("Stat", r.Target)); err == nil {
+			return os.ErrExist
+		}
+	}
+	return p.FileCmder.Filecmd(r)
+}
+
+// newTestPlainSFTPRepository serves the store "files" from memory on a server with plain renames.
+func newTestPlainSFTPRepository(t *testing.T, failRename string) domain.StorageRepository {
+	t.Helper()
+	handlers := sftp.InMemHandler()
+	handlers.FileCmd = &plainRenamer{FileCmder: handlers.FileCmd, files: handlers.FileList, failRename: failRename}
+	dial := serveSFTP(t, func(channel ssh.Channel) {
+		server := sftp.NewRequestServer(channel, handlers)
+		_ = server.Serve()
+		server.Close()
+	})
+	return NewSFTPRepository(dial, map[string]string{"files": "/files"})
+}
+
+func TestSFTPObjectsPages(t *testing.T) {
+	repository, _ := newTestSFTPRepository(t)
+	for _, key := range []string{"b/2.txt", "a.txt", "b/1.txt", "b/c/3.txt", "d.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 2), "a.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/", 0), "b/1.txt", "b/2.txt", "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/c", 1), "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "missing/", 0))
+
+	page, err := repository.Browse("files", 0, "", "b/", "/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 2 || len(page.Folders) != 1 || page.Folders[0].Prefix != "b/c/" || page.Folders[0].Size != 9 {
+		t.Fatalf("unexpected browse page %+v", page)
+	}
+	if _, err = repository.Objects("files", 2, "%%%", ""); !errors.Is(err, domain.ErrPageTokenInvalid) {
+		t.Fatalf("got %v for an invalid token, want ErrPageTokenInvalid", err)
+	}
+}
+
+func TestSFTPUploadAndOpenRange(t *testing.T) {
+	repository, dir := newTestSFTPRepository(t)
+	upload(t, repository, "files", "/docs/hello.txt", "hello")
+	object := upload(t, repository, "files", "/docs/hello.txt", "hello world")
+	if object.Key != "docs/hello.txt" || object.Size != 11 {
+		t.Fatalf("unexpected uploaded object %+v", object)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "files", "docs", "hello.txt")); err != nil || string(data) != "hello world" {
+		t.Fatalf("server has %q, %v", data, err)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "docs/hello.txt")
+
+	content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"}, &domain.ByteRange{Offset: 6, Length: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(content.Body)
+	content.Body.Close()
+	if err != nil || string(data) != "wor" {
+		t.Fatalf("range read %q, %v, want \"wor\"", data, err)
+	}
+	if content.Object.Size != 11 || content.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content %+v", content.Object)
+	}
+	if _, err = repository.Open(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"}, nil); err == nil {
+		t.Fatal("opened a missing object")
+	}
+}
+
+func TestSFTPCopy(t *testing.T) {
+	repository, _ := newTestSFTPRepository(t)
+	upload(t, repository, "files", "a/one.txt", "one")
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a/one.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "x/y/one.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.StoreName != "other" || copied.Size != 3 {
+		t.Fatalf("unexpected copy %+v", copied)
+	}
+	if got := read(t, repository, "other", "x/y/one.txt"); got != "one" {
+		t.Fatalf("copy has %q", got)
+	}
+	if !exists(repository, "files", "a/one.txt") {
+		t.Fatal("copy removed the source")
+	}
+}
+
+func TestSFTPMoveOntoExisting(t *testing.T) {
+	repository, _ := newTestSFTPRepository(t)
+	upload(t, repository, "files", "a/one.txt", "one")
+	upload(t, repository, "other", "b/one.txt", "old")
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a/one.txt"},
+		&domain.ObjectParams{StoreName: "other", Key: "b/one.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Key != "b/one.txt" || moved.Size != 3 {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	if got := read(t, repository, "other", "b/one.txt"); got != "one" {
+		t.Fatalf("moved object has %q", got)
+	}
+	if exists(repository, "files", "a/one.txt") {
+		t.Fatal("move kept the source")
+	}
+}
+
+func TestSFTPDeleteAll(t *testing.T) {
+	repository, _ := newTestSFTPRepository(t)
+	for _, key := range []string{"logs/1.log", "logs/2/3.log", "logs-old/4.log", "keep.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	report, err := repository.DeleteAll("files", "logs/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 0 || len(report.Results) != 2 || report.Results[0].Status != domain.StatusDryRun {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/4.log", "logs/1.log", "logs/2/3.log")
+
+	report, err = repository.DeleteAll("files", "logs/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 2 || report.Failed != 0 || report.Results[0].Key != "logs/1.log" || report.Results[1].Key != "logs/2/3.log" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "keep.txt", "logs-old/4.log")
+}
+
+func TestSFTPPlainRenameReplacesTarget(t *testing.T) {
+	repository := newTestPlainSFTPRepository(t, "")
+	upload(t, repository, "files", "a.txt", "old")
+	upload(t, repository, "files", "a.txt", "new")
+	upload(t, repository, "files", "b.txt", "moved")
+	if _, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "b.txt"},
+		&domain.ObjectParams{StoreName: "files", Key: "a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, repository, "files", "a.txt"); got != "moved" {
+		t.Fatalf("a.txt has %q", got)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "a.txt")
+}
+
+func TestSFTPPlainRenameKeepsTargetOnFailure(t *testing.T) {
+	repository := newTestPlainSFTPRepository(t, "/files/b.txt")
+	upload(t, repository, "files", "a.txt", "kept")
+	upload(t, repository, "files", "b.txt", "moved")
+	if _, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "b.txt"},
+		&domain.ObjectParams{StoreName: "files", Key: "a.txt"}); err == nil {
+		t.Fatal("move succeeded although the rename failed")
+	}
+	if got := read(t, repository, "files", "a.txt"); got != "kept" {
+		t.Fatalf("a.txt has %q", got)
+	}
+	if got := read(t, repository, "files", "b.txt"); got != "moved" {
+		t.Fatalf("b.txt has %q", got)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "a.txt", "b.txt")
+}
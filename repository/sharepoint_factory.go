@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"golang.org/x/oauth2/clientcredentials"
+	"path"
+	"strings"
+)
+
+// SHAREPOINT stores objects in the document libraries of SharePoint sites.
+const SHAREPOINT domain.StorageType = "sharepoint"
+
+func init() {
+	domain.RegisterStorage(SHAREPOINT, newSharePointStorage)
+}
+
+// newSharePointStorage signs in with the client credentials of an app registration. The endpoint
+// overrides the Graph URL.
+func newSharePointStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	tokenURL := config.Option("token_url")
+	if tokenURL == "" {
+		tokenURL = fmt.Sprintf("https://login.microsoftonline.com/%v/oauth2/v2.0/token", config.Credential("tenant_id"))
+	}
+	graphURL := config.Endpoint
+	if graphURL == "" {
+		graphURL = "https://graph.microsoft.com/v1.0"
+	}
+	linkScope := config.Option("link_scope")
+	if linkScope == "" {
+		linkScope = "organization"
+	}
+	sites := parseSites(config.Option("sites"))
+	if len(sites) == 0 {
+		return nil, errors.New("option sites must name at least one site")
+	}
+	credentials := &clientcredentials.Config{
+		ClientID:     config.Credential("client_id"),
+		ClientSecret: config.Credential("client_secret"),
+		TokenURL:     tokenURL,
+		Scopes:       []string{"https://graph.microsoft.com/.default"},
+	}
+
+	return NewSharePointRepository(credentials.Client(context.Background()),
+		strings.TrimRight(graphURL, "/"), sites, linkScope), nil
+}
+
+// parseSites turns a comma separated list of "alias=site" or "site" entries into an
+// alias to Graph site identifier map. A site is either a site id or "hostname:/server/relative/path",
+// bare entries are named after the last element of their path.
+func parseSites(value string) map[string]string {
+	sites := map[string]string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		alias, site, found := strings.Cut(entry, "=")
+		if !found {
+			site = alias
+			_, relativePath, _ := strings.Cut(site, ":")
+			alias = path.Base(path.Clean("/" + relativePath))
+			if alias == "/" {
+				alias = strings.Split(site, ".")[0]
+			}
+		}
+		sites[strings.TrimSpace(alias)] = strings.TrimSpace(site)
+	}
+	return sites
+}
@@ -0,0 +1,781 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Graph accepts a single PUT up to 4 MiB, anything larger goes through an upload session
+	sharePointSimpleUploadLimit = 4 << 20
+	// upload session fragments must be a multiple of 320 KiB
+	sharePointChunkSize = 32 * 320 << 10
+	// sharePointTimeout bounds a Graph request and the wait for the response to a download
+	sharePointTimeout = time.Minute
+	// sharePointTransferTimeout bounds the upload of a file or a fragment of it
+	sharePointTransferTimeout = 10 * time.Minute
+)
+
+type sharePointRepository struct {
+	// client sends Graph requests, content transfers content with the same authentication but
+	// without a timeout, a download takes as long as it takes. transfer talks to the
+	// pre-authenticated URLs of upload sessions and copy monitors.
+	client    *http.Client
+	content   *http.Client
+	transfer  *http.Client
+	graphURL  string
+	sites     map[string]string
+	linkScope string
+	drives    sync.Map
+}
+
+type driveItem struct {
+	ID                   string    `json:"id"`
+	Name                 string    `json:"name"`
+	ETag                 string    `json:"eTag"`
+	Size                 int64     `json:"size"`
+	LastModifiedDateTime time.Time `json:"lastModifiedDateTime"`
+	File                 *struct {
+		MimeType string `json:"mimeType"`
+	} `json:"file"`
+	Folder *struct {
+		ChildCount int `json:"childCount"`
+	} `json:"folder"`
+}
+
+type driveItemPage struct {
+	Value    []driveItem `json:"value"`
+	NextLink string      `json:"@odata.nextLink"`
+}
+
+type graphError struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *graphError) Error() string {
+	return fmt.Sprintf("graph api responded %v %v: %v", e.StatusCode, e.Code, e.Message)
+}
+
+// NewSharePointRepository exposes the document libraries (drives) of the given SharePoint sites.
+// Store names are "<site alias>/<drive name>" and keys are paths of driveItems inside the drive.
+// client must authenticate against graphURL, see newSharePointStorage.
+func NewSharePointRepository(client *http.Client, graphURL string, sites map[string]string, linkScope string) domain.StorageRepository {
+	api := *client
+	api.Timeout = sharePointTimeout
+	content := *client
+	content.Timeout = 0
+	return &sharePointRepository{
+		client:    &api,
+		content:   &content,
+		transfer:  &http.Client{Timeout: sharePointTransferTimeout},
+		graphURL:  graphURL,
+		sites:     sites,
+		linkScope: linkScope,
+	}
+}
+
+func (s *sharePointRepository) StoreNames() ([]string, error) {
+	var storeNames []string
+	for alias, site := range s.sites {
+		drives, err := s.siteDrives(alias, site)
+		if err != nil {
+			log.Printf("Couldn't list drives of site %v. Here's why: %v\n", alias, err)
+			return nil, err
+		}
+		storeNames = append(storeNames, drives...)
+	}
+	sort.Strings(storeNames)
+	return storeNames, nil
+}
+
+func (s *sharePointRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := s.objects(storeName, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+// ObjectsWithMetadata reads the list item fields of the objects on the requested page only.
+func (s *sharePointRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	page, err := s.Objects(storeName, maxObjectsPerPage, token, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	driveID, err := s.driveID(storeName)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	for i := range page.Objects {
+		page.Objects[i].Metadata, err = s.fields(driveID, page.Objects[i].Key)
+		if err != nil {
+			log.Printf("Couldn't get fields of %v:%v. Here's why: %v\n", storeName, page.Objects[i].Key, err)
+		}
+	}
+	return page, nil
+}
+
+func (s *sharePointRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	storageObjects, err := s.objects(storeName, prefix)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return browseOf(storageObjects, maxObjectsPerPage, token, prefix, delimiter, withSizes)
+}
+
+// objects returns all objects below prefix, sorted by key.
+func (s *sharePointRepository) objects(storeName string, prefix string) ([]domain.StorageObject, error) {
+	items, err := s.list(storeName, prefix)
+	if err != nil {
+		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+		return nil, err
+	}
+	storageObjects := make([]domain.StorageObject, 0, len(items))
+	for key, item := range items {
+		storageObjects = append(storageObjects, s.toStorageObject(storeName, key, item))
+	}
+	sort.Slice(storageObjects, func(i, j int) bool {
+		return storageObjects[i].Key < storageObjects[j].Key
+	})
+	return storageObjects, nil
+}
+
+func (s *sharePointRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	driveID, err := s.driveID(params.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	var item driveItem
+	if _, err = s.call(http.MethodGet, itemURL(driveID, params.Key), nil, &item); err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	object := s.toStorageObject(params.StoreName, params.Key, item)
+	object.Metadata, err = s.fields(driveID, params.Key)
+	if err != nil {
+		log.Printf("Couldn't get fields of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+	}
+	return object, nil
+}
+
+// Upload sends small files with a single request and larger ones through an upload session.
+// Upload sessions need the total size up front, so readers which can't seek are spooled to a
+// temporary file once they outgrow a single request. Metadata is written to the list item fields,
+// which have to exist as columns in the document library.
+func (s *sharePointRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	driveID, err := s.driveID(params.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	size := int64(-1)
+	if seeker, ok := file.(io.Seeker); ok {
+		if size, err = seeker.Seek(0, io.SeekEnd); err == nil {
+			_, err = seeker.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			return domain.StorageObject{}, err
+		}
+	}
+	if size < 0 {
+		head := make([]byte, sharePointSimpleUploadLimit+1)
+		n, err := io.ReadFull(file, head)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+			return domain.StorageObject{}, err
+		}
+		if n <= sharePointSimpleUploadLimit {
+			size = int64(n)
+			file = bytes.NewReader(head[:n])
+		} else {
+			spool, err := os.CreateTemp("", "sharepoint-upload-*")
+			if err != nil {
+				return domain.StorageObject{}, err
+			}
+			defer os.Remove(spool.Name())
+			defer spool.Close()
+			if size, err = io.Copy(spool, io.MultiReader(bytes.NewReader(head), file)); err != nil {
+				return domain.StorageObject{}, err
+			}
+			if _, err = spool.Seek(0, io.SeekStart); err != nil {
+				return domain.StorageObject{}, err
+			}
+			file = spool
+		}
+	}
+
+	var item driveItem
+	if size <= sharePointSimpleUploadLimit {
+		item, err = s.uploadSimple(driveID, params.Key, uploadContentType(params), file, size)
+	} else {
+		item, err = s.uploadSession(driveID, params.Key, file, size)
+	}
+	if err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	object := s.toStorageObject(params.StoreName, params.Key, item)
+	if len(metadata) > 0 {
+		if _, err = s.call(http.MethodPatch, itemURL(driveID, params.Key)+"/listItem/fields", metadata, nil); err != nil {
+			log.Printf("Couldn't set fields of %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+			return object, err
+		}
+		object.Metadata = metadata
+	}
+	return object, nil
+}
+
+// PresignUploadLink returns the URL of a new upload session, which accepts the file without
+// further authentication. Graph decides how long the session lives, exp, mimeType and metadata
+// can't be applied to it.
+func (s *sharePointRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	driveID, err := s.driveID(params.StoreName)
+	if err != nil {
+		return "", err
+	}
+	uploadURL, err := s.createUploadSession(driveID, params.Key)
+	if err != nil {
+		log.Printf("Couldn't create an upload session for %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return "", err
+	}
+	return uploadURL, nil
+}
+
+func (s *sharePointRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	object, err := s.GetObject(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	if err = params.Conditions.Check(object); err != nil {
+		return domain.ObjectContent{}, err
+	}
+	driveID, err := s.driveID(params.StoreName)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	request, err := http.NewRequest(http.MethodGet, s.graphURL+itemURL(driveID, params.Key)+"/content", nil)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	if byteRange != nil {
+		request.Header.Set("Range", rangeHeader(byteRange))
+	}
+	// only the wait for the response is bounded, reading the body is up to the caller
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(sharePointTimeout, cancel)
+	response, err := s.content.Do(request.WithContext(ctx))
+	timer.Stop()
+	if err == nil && response.StatusCode >= http.StatusMultipleChoices {
+		err = readGraphError(response)
+		response.Body.Close()
+	}
+	if err != nil {
+		cancel()
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	body, err := rangedBody(response, byteRange)
+	if err != nil {
+		cancel()
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	body = cancelBody{ReadCloser: body, cancel: cancel}
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = contentTypeOf(params.Key)
+	}
+	return domain.ObjectContent{
+		Object:      object,
+		ContentType: contentType,
+		Body:        body,
+	}, nil
+}
+
+// PresignDownloadLink returns a view sharing link with the configured scope.
+func (s *sharePointRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return s.createLink(params, time.Time{})
+}
+
+// PresignDownloadLinkWithExpTime returns a view sharing link. Graph only lets anonymous links
+// expire, so exp is ignored for every other scope.
+func (s *sharePointRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	if s.linkScope != "anonymous" {
+		return s.createLink(params, time.Time{})
+	}
+	return s.createLink(params, time.Now().Add(time.Duration(exp*uint(time.Millisecond))))
+}
+
+func (s *sharePointRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	driveID, err := s.driveID(storeName)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	items, err := s.list(storeName, pathPrefix)
+	if err != nil {
+		log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+		return domain.DeleteAllReport{}, err
+	}
+	objects := make([]domain.StorageObject, 0, len(items))
+	for key := range items {
+		objects = append(objects, domain.StorageObject{StoreName: storeName, Key: key})
+	}
+	return deleteEach(storeName, pathPrefix, objects, dryRun, func(key string) error {
+		_, err := s.call(http.MethodDelete, itemURL(driveID, key), nil, nil)
+		if err != nil {
+			log.Printf("Couldn't delete %v:%v. Here's why: %v\n", storeName, key, err)
+		}
+		return err
+	}), nil
+}
+
+func (s *sharePointRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	driveID, err := s.driveID(params.StoreName)
+	if err != nil {
+		return false, err
+	}
+	if _, err = s.call(http.MethodDelete, itemURL(driveID, params.Key), nil, nil); err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	return true, nil
+}
+
+// Copy starts an asynchronous Graph copy and waits for its monitor to report completion.
+func (s *sharePointRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentDriveID, err := s.driveID(current.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationDriveID, err := s.driveID(destination.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	err = s.copy(currentDriveID, current.Key, destinationDriveID, destination.Key)
+	if err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return s.GetObject(destination)
+}
+
+func (s *sharePointRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		s.objects,
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := s.Copy(current, destination)
+			return err
+		})
+}
+
+// Move updates the parent reference inside a drive. Graph can't move between drives,
+// so that case is a copy followed by a delete of the source once the copy is verified.
+func (s *sharePointRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	currentDriveID, err := s.driveID(current.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	destinationDriveID, err := s.driveID(destination.StoreName)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if currentDriveID != destinationDriveID {
+		return moveByCopy(s, current, destination, nil)
+	}
+
+	parentID, err := s.ensureFolder(destinationDriveID, path.Dir(cleanKey(destination.Key)))
+	if err == nil {
+		var item driveItem
+		_, err = s.call(http.MethodPatch, itemURL(currentDriveID, current.Key)+"?@microsoft.graph.conflictBehavior=replace", map[string]any{
+			"parentReference": map[string]string{"id": parentID},
+			"name":            path.Base(cleanKey(destination.Key)),
+		}, &item)
+		if err == nil {
+			return s.toStorageObject(destination.StoreName, destination.Key, item), nil
+		}
+	}
+	log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+		current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+	return domain.StorageObject{}, err
+}
+
+func (s *sharePointRepository) siteDrives(alias string, site string) ([]string, error) {
+	siteURL := "/sites/" + site
+	if strings.Contains(site, ":") {
+		siteURL += ":"
+	}
+	var drives struct {
+		Value []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	if _, err := s.call(http.MethodGet, siteURL+"/drives", nil, &drives); err != nil {
+		return nil, err
+	}
+	storeNames := make([]string, 0, len(drives.Value))
+	for _, drive := range drives.Value {
+		storeName := alias + "/" + drive.Name
+		s.drives.Store(storeName, drive.ID)
+		storeNames = append(storeNames, storeName)
+	}
+	return storeNames, nil
+}
+
+func (s *sharePointRepository) driveID(storeName string) (string, error) {
+	if driveID, ok := s.drives.Load(storeName); ok {
+		return driveID.(string), nil
+	}
+	alias, _, _ := strings.Cut(storeName, "/")
+	site, ok := s.sites[alias]
+	if !ok {
+		return "", fmt.Errorf("site %v is not configured", alias)
+	}
+	if _, err := s.siteDrives(alias, site); err != nil {
+		return "", err
+	}
+	if driveID, ok := s.drives.Load(storeName); ok {
+		return driveID.(string), nil
+	}
+	return "", fmt.Errorf("store %v does not exist", storeName)
+}
+
+// list returns every file below the deepest folder that can hold prefix, keyed by its path.
+func (s *sharePointRepository) list(storeName string, prefix string) (map[string]driveItem, error) {
+	driveID, err := s.driveID(storeName)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	folder := keyOf("/", walkRoot("/", prefix))
+	items := map[string]driveItem{}
+	err = s.walk(driveID, folder, prefix, items)
+	var notFound *graphError
+	if errors.As(err, &notFound) && notFound.StatusCode == http.StatusNotFound {
+		return items, nil
+	}
+	return items, err
+}
+
+func (s *sharePointRepository) walk(driveID string, folder string, prefix string, items map[string]driveItem) error {
+	next := itemURL(driveID, folder) + "/children"
+	for next != "" {
+		var page driveItemPage
+		if _, err := s.call(http.MethodGet, next, nil, &page); err != nil {
+			return err
+		}
+		for _, item := range page.Value {
+			key := path.Join(folder, item.Name)
+			if item.Folder != nil {
+				if canContain(key, prefix) {
+					if err := s.walk(driveID, key, prefix, items); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if item.File != nil && strings.HasPrefix(key, prefix) {
+				items[key] = item
+			}
+		}
+		next = page.NextLink
+	}
+	return nil
+}
+
+// fields returns the plain values of the list item behind a driveItem, hidden and
+// OData annotation fields are left out.
+func (s *sharePointRepository) fields(driveID string, key string) (map[string]string, error) {
+	var fields map[string]any
+	if _, err := s.call(http.MethodGet, itemURL(driveID, key)+"/listItem/fields", nil, &fields); err != nil {
+		return nil, err
+	}
+	metadata := map[string]string{}
+	for name, value := range fields {
+		if strings.HasPrefix(name, "@") || strings.HasPrefix(name, "_") || value == nil {
+			continue
+		}
+		switch value.(type) {
+		case map[string]any, []any:
+			continue
+		}
+		metadata[name] = fmt.Sprint(value)
+	}
+	return metadata, nil
+}
+
+func (s *sharePointRepository) uploadSimple(driveID string, key string, contentType string, file io.Reader, size int64) (driveItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharePointTransferTimeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, s.graphURL+itemURL(driveID, key)+"/content", file)
+	if err != nil {
+		return driveItem{}, err
+	}
+	request.ContentLength = size
+	request.Header.Set("Content-Type", contentType)
+	var item driveItem
+	_, err = s.do(s.content, request, &item)
+	return item, err
+}
+
+func (s *sharePointRepository) uploadSession(driveID string, key string, file io.Reader, size int64) (driveItem, error) {
+	uploadURL, err := s.createUploadSession(driveID, key)
+	if err != nil {
+		return driveItem{}, err
+	}
+	item, err := s.uploadFragments(uploadURL, file, size)
+	if err != nil {
+		// the session would keep the fragments sent so far until it expires
+		s.cancelUploadSession(uploadURL)
+		return driveItem{}, err
+	}
+	return item, nil
+}
+
+// uploadFragments sends file to the upload session in fragments of sharePointChunkSize bytes.
+func (s *sharePointRepository) uploadFragments(uploadURL string, file io.Reader, size int64) (driveItem, error) {
+	chunk := make([]byte, sharePointChunkSize)
+	var item driveItem
+	for offset := int64(0); offset < size; {
+		n, err := io.ReadFull(file, chunk)
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return driveItem{}, err
+		}
+		request, err := http.NewRequest(http.MethodPut, uploadURL, bytes.NewReader(chunk[:n]))
+		if err != nil {
+			return driveItem{}, err
+		}
+		request.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(n)-1, size))
+		// the last fragment answers with the created driveItem, every other one with the session state
+		if _, err = s.do(s.transfer, request, &item); err != nil {
+			return driveItem{}, err
+		}
+		offset += int64(n)
+	}
+	return item, nil
+}
+
+func (s *sharePointRepository) createUploadSession(driveID string, key string) (string, error) {
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	_, err := s.call(http.MethodPost, itemURL(driveID, key)+"/createUploadSession", map[string]any{
+		"item": map[string]string{"@microsoft.graph.conflictBehavior": "replace"},
+	}, &session)
+	return session.UploadURL, err
+}
+
+func (s *sharePointRepository) cancelUploadSession(uploadURL string) {
+	request, err := http.NewRequest(http.MethodDelete, uploadURL, nil)
+	if err == nil {
+		_, _ = s.do(s.transfer, request, nil)
+	}
+}
+
+func (s *sharePointRepository) createLink(params *domain.ObjectParams, expiration time.Time) (string, error) {
+	driveID, err := s.driveID(params.StoreName)
+	if err != nil {
+		return "", err
+	}
+	body := map[string]string{
+		"type":  "view",
+		"scope": s.linkScope,
+	}
+	if !expiration.IsZero() {
+		body["expirationDateTime"] = expiration.UTC().Format(time.RFC3339)
+	}
+	var permission struct {
+		Link struct {
+			WebURL string `json:"webUrl"`
+		} `json:"link"`
+	}
+	if _, err = s.call(http.MethodPost, itemURL(driveID, params.Key)+"/createLink", body, &permission); err != nil {
+		log.Printf("Couldn't create a sharing link for %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return "", err
+	}
+	return permission.Link.WebURL, nil
+}
+
+func (s *sharePointRepository) copy(currentDriveID string, currentKey string, destinationDriveID string, destinationKey string) error {
+	parentID, err := s.ensureFolder(destinationDriveID, path.Dir(cleanKey(destinationKey)))
+	if err != nil {
+		return err
+	}
+	header, err := s.call(http.MethodPost, itemURL(currentDriveID, currentKey)+"/copy?@microsoft.graph.conflictBehavior=replace", map[string]any{
+		"parentReference": map[string]string{"driveId": destinationDriveID, "id": parentID},
+		"name":            path.Base(cleanKey(destinationKey)),
+	}, nil)
+	if err != nil {
+		return err
+	}
+	monitor := header.Get("Location")
+	if monitor == "" {
+		return nil
+	}
+	wait := 250 * time.Millisecond
+	for deadline := time.Now().Add(30 * time.Minute); time.Now().Before(deadline); {
+		request, err := http.NewRequest(http.MethodGet, monitor, nil)
+		if err != nil {
+			return err
+		}
+		var status struct {
+			Status string `json:"status"`
+			Error  *struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if _, err = s.do(s.transfer, request, &status); err != nil {
+			return err
+		}
+		switch status.Status {
+		case "completed":
+			return nil
+		case "failed":
+			if status.Error != nil {
+				return &graphError{StatusCode: http.StatusInternalServerError, Code: status.Error.Code, Message: status.Error.Message}
+			}
+			return errors.New("graph api reported a failed copy")
+		}
+		time.Sleep(wait)
+		if wait < 5*time.Second {
+			wait *= 2
+		}
+	}
+	return errors.New("graph api copy did not finish in time")
+}
+
+// ensureFolder returns the id of the folder at dir, creating it and its parents when missing.
+func (s *sharePointRepository) ensureFolder(driveID string, dir string) (string, error) {
+	var folder driveItem
+	_, err := s.call(http.MethodGet, itemURL(driveID, dir), nil, &folder)
+	var notFound *graphError
+	if !errors.As(err, &notFound) || notFound.StatusCode != http.StatusNotFound {
+		return folder.ID, err
+	}
+	parentID, err := s.ensureFolder(driveID, path.Dir(dir))
+	if err != nil {
+		return "", err
+	}
+	_, err = s.call(http.MethodPost, "/drives/"+driveID+"/items/"+parentID+"/children", map[string]any{
+		"name":                              path.Base(dir),
+		"folder":                            map[string]any{},
+		"@microsoft.graph.conflictBehavior": "replace",
+	}, &folder)
+	return folder.ID, err
+}
+
+// call sends body as JSON to a Graph path or an absolute URL and decodes the response into out.
+func (s *sharePointRepository) call(method string, target string, body any, out any) (http.Header, error) {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		target = s.graphURL + target
+	}
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	return s.do(s.client, request, out)
+}
+
+// do sends request with client and decodes a JSON response into out, if there is one.
+func (s *sharePointRepository) do(client *http.Client, request *http.Request, out any) (http.Header, error) {
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return response.Header, readGraphError(response)
+	}
+	if out == nil || response.StatusCode == http.StatusNoContent {
+		return response.Header, nil
+	}
+	err = json.NewDecoder(response.Body).Decode(out)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+	return response.Header, err
+}
+
+func (s *sharePointRepository) toStorageObject(storeName string, key string, item driveItem) domain.StorageObject {
+	object := domain.StorageObject{
+		StoreName: storeName,
+		Key:       strings.TrimLeft(key, "/"),
+		ETag:      item.ETag,
+		Size:      item.Size,
+	}
+	if !item.LastModifiedDateTime.IsZero() {
+		object.LastModified = item.LastModifiedDateTime.UnixMilli()
+	}
+	return object
+}
+
+// cancelBody cancels the request of a download once its body is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func readGraphError(response *http.Response) error {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.NewDecoder(response.Body).Decode(&body)
+	return &graphError{
+		StatusCode: response.StatusCode,
+		Code:       body.Error.Code,
+		Message:    body.Error.Message,
+	}
+}
+
+// itemURL addresses a driveItem by its path relative to the drive root.
+func itemURL(driveID string, key string) string {
+	key = cleanKey(key)
+	if key == "" {
+		return "/drives/" + driveID + "/root"
+	}
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/drives/" + driveID + "/root:/" + strings.Join(segments, "/") + ":"
+}
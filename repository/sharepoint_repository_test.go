@@ -0,0 +1,515 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGraph stands in for the Graph API with a single site "site1" holding the drive "Documents".
+// Items are addressed by path; folders have ids, files are stored with their content.
+type fakeGraph struct {
+	server   *httptest.Server
+	mutex    sync.Mutex
+	files    map[string][]byte
+	fields   map[string]map[string]string
+	folders  map[string]string
+	sessions map[string]*fakeUploadSession
+	monitors map[string]*fakeCopy
+	// pageSize limits the children listed per page, later pages are linked with @odata.nextLink
+	pageSize int
+	// copyPolls is the number of times a copy monitor reports the copy in progress
+	copyPolls int
+	// failCopy makes copy monitors report a failure
+	failCopy bool
+	// failFragment makes upload sessions fail the fragment with this number, counted from 1
+	failFragment int
+	requests     []string
+}
+
+type fakeUploadSession struct {
+	key       string
+	data      []byte
+	fragments int
+	cancelled bool
+}
+
+type fakeCopy struct {
+	source      string
+	destination string
+	polls       int
+}
+
+func newFakeGraph(t *testing.T) *fakeGraph {
+	graph := &fakeGraph{
+		files:    map[string][]byte{},
+		fields:   map[string]map[string]string{},
+		folders:  map[string]string{"": "root"},
+		sessions: map[string]*fakeUploadSession{},
+		monitors: map[string]*fakeCopy{},
+		pageSize: 100,
+	}
+	graph.server = httptest.NewServer(http.HandlerFunc(graph.serve))
+	t.Cleanup(graph.server.Close)
+	return graph
+}
+
+func (g *fakeGraph) repository() domain.StorageRepository {
+	return NewSharePointRepository(g.server.Client(), g.server.URL, map[string]string{"team": "site1"}, "organization")
+}
+
+// count returns the number of requests whose method and path start with prefix, e.g. "PUT /upload/".
+func (g *fakeGraph) count(prefix string) int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	count := 0
+	for _, request := range g.requests {
+		if strings.HasPrefix(request, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func (g *fakeGraph) serve(w http.ResponseWriter, r *http.Request) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.requests = append(g.requests, r.Method+" "+r.URL.Path)
+	route := r.URL.Path
+	switch {
+	case route == "/sites/site1/drives":
+		writeJSON(w, http.StatusOK, map[string]any{"value": []map[string]string{{"id": "d1", "name": "Documents"}}})
+	case strings.HasPrefix(route, "/upload/"):
+		g.serveFragment(w, r, strings.TrimPrefix(route, "/upload/"))
+	case strings.HasPrefix(route, "/monitor/"):
+		g.serveMonitor(w, strings.TrimPrefix(route, "/monitor/"))
+	case strings.HasPrefix(route, "/drives/d1/items/") && strings.HasSuffix(route, "/children") && r.Method == http.MethodPost:
+		g.createFolder(w, r, strings.TrimSuffix(strings.TrimPrefix(route, "/drives/d1/items/"), "/children"))
+	case route == "/drives/d1/root" || route == "/drives/d1/root/children":
+		g.serveItem(w, r, "", strings.TrimPrefix(route, "/drives/d1/root"))
+	case strings.HasPrefix(route, "/drives/d1/root:/"):
+		key, action, found := strings.Cut(strings.TrimPrefix(route, "/drives/d1/root:/"), ":")
+		if !found {
+			writeGraphError(w, http.StatusBadRequest, "invalidRequest")
+			return
+		}
+		g.serveItem(w, r, key, action)
+	default:
+		writeGraphError(w, http.StatusNotFound, "itemNotFound")
+	}
+}
+
+func (g *fakeGraph) serveItem(w http.ResponseWriter, r *http.Request, key string, action string) {
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		if item, ok := g.item(key); ok {
+			writeJSON(w, http.StatusOK, item)
+			return
+		}
+		writeGraphError(w, http.StatusNotFound, "itemNotFound")
+	case action == "" && r.Method == http.MethodDelete:
+		if _, ok := g.files[key]; !ok {
+			writeGraphError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		delete(g.files, key)
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" && r.Method == http.MethodPatch:
+		var body struct {
+			ParentReference struct {
+				ID string `json:"id"`
+			} `json:"parentReference"`
+			Name string `json:"name"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		data, ok := g.files[key]
+		parent, found := g.folderPath(body.ParentReference.ID)
+		if !ok || !found {
+			writeGraphError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		delete(g.files, key)
+		destination := path.Join(parent, body.Name)
+		g.files[destination] = data
+		item, _ := g.item(destination)
+		writeJSON(w, http.StatusOK, item)
+	case action == "/children":
+		g.serveChildren(w, r, key)
+	case action == "/content" && r.Method == http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		g.files[key] = data
+		item, _ := g.item(key)
+		writeJSON(w, http.StatusCreated, item)
+	case action == "/content" && r.Method == http.MethodGet:
+		data, ok := g.files[key]
+		if !ok {
+			writeGraphError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(data)
+	case action == "/createUploadSession":
+		id := strconv.Itoa(len(g.sessions) + 1)
+		g.sessions[id] = &fakeUploadSession{key: key}
+		writeJSON(w, http.StatusOK, map[string]string{"uploadUrl": g.server.URL + "/upload/" + id})
+	case action == "/copy":
+		var body struct {
+			ParentReference struct {
+				ID string `json:"id"`
+			} `json:"parentReference"`
+			Name string `json:"name"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		parent, found := g.folderPath(body.ParentReference.ID)
+		if _, ok := g.files[key]; !ok || !found {
+			writeGraphError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		id := strconv.Itoa(len(g.monitors) + 1)
+		g.monitors[id] = &fakeCopy{source: key, destination: path.Join(parent, body.Name)}
+		w.Header().Set("Location", g.server.URL+"/monitor/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case action == "/listItem/fields" && r.Method == http.MethodGet:
+		fields, ok := g.fields[key]
+		if !ok {
+			writeGraphError(w, http.StatusNotFound, "itemNotFound")
+			return
+		}
+		writeJSON(w, http.StatusOK, fields)
+	case action == "/listItem/fields" && r.Method == http.MethodPatch:
+		fields := map[string]string{}
+		json.NewDecoder(r.Body).Decode(&fields)
+		g.fields[key] = fields
+		writeJSON(w, http.StatusOK, fields)
+	default:
+		writeGraphError(w, http.StatusBadRequest, "invalidRequest")
+	}
+}
+
+// serveChildren lists the files and folders directly in the folder at key, pageSize at a time.
+func (g *fakeGraph) serveChildren(w http.ResponseWriter, r *http.Request, key string) {
+	if _, ok := g.folders[key]; !ok {
+		writeGraphError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	var children []string
+	for file := range g.files {
+		if parentOf(file) == key {
+			children = append(children, file)
+		}
+	}
+	for folder := range g.folders {
+		if folder != "" && parentOf(folder) == key {
+			children = append(children, folder)
+		}
+	}
+	sort.Strings(children)
+	skip, _ := strconv.Atoi(r.URL.Query().Get("$skiptoken"))
+	end := min(skip+g.pageSize, len(children))
+	page := map[string]any{}
+	items := []map[string]any{}
+	for _, child := range children[skip:end] {
+		item, _ := g.item(child)
+		items = append(items, item)
+	}
+	page["value"] = items
+	if end < len(children) {
+		page["@odata.nextLink"] = fmt.Sprintf("%v%v?$skiptoken=%d", g.server.URL, r.URL.Path, end)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (g *fakeGraph) createFolder(w http.ResponseWriter, r *http.Request, parentID string) {
+	parent, found := g.folderPath(parentID)
+	if !found {
+		writeGraphError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	var body struct {
+		Name string `json:"name"`
+	}
+	json.NewDecoder(r.Body).Decode(&body)
+	folder := path.Join(parent, body.Name)
+	g.folders[folder] = fmt.Sprintf("folder-%d", len(g.folders))
+	item, _ := g.item(folder)
+	writeJSON(w, http.StatusCreated, item)
+}
+
+// serveFragment appends a fragment to an upload session, the last one creates the file.
+func (g *fakeGraph) serveFragment(w http.ResponseWriter, r *http.Request, id string) {
+	session, ok := g.sessions[id]
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	if r.Header.Get("Authorization") != "" {
+		writeGraphError(w, http.StatusUnauthorized, "unauthenticated")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		session.cancelled = true
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if session.fragments+1 == g.failFragment {
+		writeGraphError(w, http.StatusInternalServerError, "generalException")
+		return
+	}
+	var first, last, total int
+	if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &total); err != nil || first != len(session.data) {
+		writeGraphError(w, http.StatusRequestedRangeNotSatisfiable, "invalidRange")
+		return
+	}
+	data, _ := io.ReadAll(r.Body)
+	if len(data) != last-first+1 {
+		writeGraphError(w, http.StatusBadRequest, "invalidRange")
+		return
+	}
+	session.data = append(session.data, data...)
+	session.fragments++
+	if len(session.data) < total {
+		writeJSON(w, http.StatusAccepted, map[string]any{"nextExpectedRanges": []string{fmt.Sprintf("%d-", len(session.data))}})
+		return
+	}
+	g.files[session.key] = session.data
+	item, _ := g.item(session.key)
+	writeJSON(w, http.StatusCreated, item)
+}
+
+func (g *fakeGraph) serveMonitor(w http.ResponseWriter, id string) {
+	copying, ok := g.monitors[id]
+	if !ok {
+		writeGraphError(w, http.StatusNotFound, "itemNotFound")
+		return
+	}
+	copying.polls++
+	switch {
+	case g.failCopy:
+		writeJSON(w, http.StatusOK, map[string]any{"status": "failed", "error": map[string]string{"code": "nameAlreadyExists", "message": "copy failed"}})
+	case copying.polls <= g.copyPolls:
+		writeJSON(w, http.StatusAccepted, map[string]any{"status": "inProgress"})
+	default:
+		g.files[copying.destination] = g.files[copying.source]
+		writeJSON(w, http.StatusOK, map[string]any{"status": "completed"})
+	}
+}
+
+func (g *fakeGraph) item(key string) (map[string]any, bool) {
+	item := map[string]any{
+		"name":                 path.Base(key),
+		"lastModifiedDateTime": time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC),
+	}
+	if id, ok := g.folders[key]; ok {
+		item["id"] = id
+		item["folder"] = map[string]int{"childCount": 0}
+		return item, true
+	}
+	data, ok := g.files[key]
+	if !ok {
+		return nil, false
+	}
+	item["id"] = "file-" + key
+	item["eTag"] = fmt.Sprintf(`"{%x},1"`, len(data))
+	item["size"] = len(data)
+	item["file"] = map[string]string{"mimeType": "application/octet-stream"}
+	return item, true
+}
+
+func (g *fakeGraph) folderPath(id string) (string, bool) {
+	for folder, folderID := range g.folders {
+		if folderID == id {
+			return folder, true
+		}
+	}
+	return "", false
+}
+
+func parentOf(key string) string {
+	if parent := path.Dir(key); parent != "." {
+		return parent
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeGraphError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]any{"error": map[string]string{"code": code, "message": code}})
+}
+
+// hideSeeker hides the Seek method of a reader, like a request body does.
+type hideSeeker struct {
+	io.Reader
+}
+
+func TestSharePointUploadSwitchesToSession(t *testing.T) {
+	graph := newFakeGraph(t)
+	repository := graph.repository()
+
+	small := upload(t, repository, "team/Documents", "small.txt", "hello")
+	if small.Size != 5 || graph.count("PUT /drives/d1/root:/small.txt:/content") != 1 || graph.count("POST") != 0 {
+		t.Fatalf("small upload %+v didn't go through a single PUT: %v", small, graph.requests)
+	}
+
+	// seekable, one fragment and a half
+	large := bytes.Repeat([]byte("0123456789abcdef"), (sharePointChunkSize+sharePointChunkSize/2)/16)
+	object, err := repository.Upload(&domain.ObjectParams{StoreName: "team/Documents", Key: "large.bin"}, nil, bytes.NewReader(large))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Size != int64(len(large)) || graph.sessions["1"] == nil || graph.sessions["1"].fragments != 2 {
+		t.Fatalf("large upload %+v didn't go through an upload session of 2 fragments", object)
+	}
+	if !bytes.Equal(graph.files["large.bin"], large) {
+		t.Fatal("large upload was assembled wrongly")
+	}
+
+	// not seekable and just over the limit of a single PUT, spooled before the session
+	spooled := bytes.Repeat([]byte{7}, sharePointSimpleUploadLimit+1)
+	if _, err = repository.Upload(&domain.ObjectParams{StoreName: "team/Documents", Key: "spooled.bin"}, nil, hideSeeker{bytes.NewReader(spooled)}); err != nil {
+		t.Fatal(err)
+	}
+	if graph.sessions["2"] == nil || graph.sessions["2"].fragments != 1 || !bytes.Equal(graph.files["spooled.bin"], spooled) {
+		t.Fatal("spooled upload didn't go through an upload session")
+	}
+
+	// not seekable and small enough for a single PUT
+	if _, err = repository.Upload(&domain.ObjectParams{StoreName: "team/Documents", Key: "stream.txt"}, nil, hideSeeker{strings.NewReader("stream")}); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.sessions) != 2 || string(graph.files["stream.txt"]) != "stream" {
+		t.Fatal("small stream went through an upload session")
+	}
+}
+
+func TestSharePointUploadCancelsFailedSession(t *testing.T) {
+	graph := newFakeGraph(t)
+	graph.failFragment = 2
+	repository := graph.repository()
+	large := bytes.Repeat([]byte{7}, sharePointChunkSize+sharePointChunkSize/2)
+	if _, err := repository.Upload(&domain.ObjectParams{StoreName: "team/Documents", Key: "large.bin"}, nil, bytes.NewReader(large)); err == nil {
+		t.Fatal("upload succeeded although a fragment failed")
+	}
+	if session := graph.sessions["1"]; session == nil || session.fragments != 1 || !session.cancelled {
+		t.Fatalf("the failed upload session wasn't cancelled: %+v", session)
+	}
+	if _, ok := graph.files["large.bin"]; ok {
+		t.Fatal("the failed upload created the file")
+	}
+}
+
+func TestSharePointUploadWritesFields(t *testing.T) {
+	graph := newFakeGraph(t)
+	repository := graph.repository()
+	_, err := repository.Upload(&domain.ObjectParams{StoreName: "team/Documents", Key: "a.txt"}, map[string]string{"Owner": "me"}, strings.NewReader("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload(t, repository, "team/Documents", "b.txt", "b")
+	page, err := repository.ObjectsWithMetadata("team/Documents", 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b.txt has no list item fields, which doesn't fail the listing
+	if len(page.Objects) != 2 || page.Objects[0].Metadata["Owner"] != "me" || page.Objects[1].Metadata != nil {
+		t.Fatalf("unexpected page %+v", page)
+	}
+}
+
+func TestSharePointWalkFollowsNextLink(t *testing.T) {
+	graph := newFakeGraph(t)
+	graph.pageSize = 2
+	repository := graph.repository()
+	keys := []string{"1.txt", "2.txt", "3.txt", "4.txt", "5.txt", "docs/a.txt", "docs/b.txt", "docs/c.txt", "docs/sub/d.txt"}
+	for _, key := range keys {
+		graph.files[key] = []byte(key)
+	}
+	graph.folders["docs"] = "folder-docs"
+	graph.folders["docs/sub"] = "folder-sub"
+
+	equalKeys(t, listKeys(t, repository, "team/Documents", "", 4), keys...)
+	equalKeys(t, listKeys(t, repository, "team/Documents", "docs/", 0), "docs/a.txt", "docs/b.txt", "docs/c.txt", "docs/sub/d.txt")
+	if graph.count("GET /drives/d1/root:/docs:/children") < 4 {
+		t.Fatalf("listing didn't follow the next links of docs: %v", graph.requests)
+	}
+	equalKeys(t, listKeys(t, repository, "team/Documents", "missing/", 0))
+}
+
+func TestSharePointCopyPollsMonitor(t *testing.T) {
+	graph := newFakeGraph(t)
+	graph.copyPolls = 2
+	repository := graph.repository()
+	upload(t, repository, "team/Documents", "a.txt", "content")
+
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "team/Documents", Key: "a.txt"},
+		&domain.ObjectParams{StoreName: "team/Documents", Key: "x/y/b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.Key != "x/y/b.txt" || copied.Size != 7 || graph.monitors["1"].polls != 3 {
+		t.Fatalf("copy %+v finished after %v polls, want 3", copied, graph.monitors["1"].polls)
+	}
+	if got := read(t, repository, "team/Documents", "x/y/b.txt"); got != "content" {
+		t.Fatalf("copy has %q", got)
+	}
+
+	graph.failCopy = true
+	_, err = repository.Copy(&domain.ObjectParams{StoreName: "team/Documents", Key: "a.txt"},
+		&domain.ObjectParams{StoreName: "team/Documents", Key: "c.txt"})
+	if err == nil || !strings.Contains(err.Error(), "nameAlreadyExists") {
+		t.Fatalf("got %v for a failed copy", err)
+	}
+}
+
+func TestSharePointEnsureFolderCreatesParents(t *testing.T) {
+	graph := newFakeGraph(t)
+	repository := graph.repository().(*sharePointRepository)
+	graph.folders["a"] = "folder-a"
+
+	id, err := repository.ensureFolder("d1", "a/b/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || graph.folders["a/b/c"] != id || graph.folders["a/b"] == "" {
+		t.Fatalf("folders %v don't hold a/b/c with id %v", graph.folders, id)
+	}
+	if graph.count("POST /drives/d1/items/folder-a/children") != 1 || graph.count("POST") != 2 {
+		t.Fatalf("unexpected requests %v", graph.requests)
+	}
+	again, err := repository.ensureFolder("d1", "a/b/c")
+	if err != nil || again != id || graph.count("POST") != 2 {
+		t.Fatalf("existing folder wasn't reused: %v, %v", again, err)
+	}
+}
+
+func TestSharePointMoveWithinDrive(t *testing.T) {
+	graph := newFakeGraph(t)
+	repository := graph.repository()
+	upload(t, repository, "team/Documents", "a.txt", "content")
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "team/Documents", Key: "a.txt"},
+		&domain.ObjectParams{StoreName: "team/Documents", Key: "archive/a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Key != "archive/a.txt" || exists(repository, "team/Documents", "a.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	if got := read(t, repository, "team/Documents", "archive/a.txt"); got != "content" {
+		t.Fatalf("moved object has %q", got)
+	}
+}
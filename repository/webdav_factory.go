@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"net/http"
+	"net/url"
+)
+
+// WEBDAV stores objects as resources below the collections of a WebDAV server.
+const WEBDAV domain.StorageType = "webdav"
+
+func init() {
+	domain.RegisterStorage(WEBDAV, newWebDAVStorage)
+}
+
+// newWebDAVStorage talks to the endpoint, authenticating with basic auth when a user is set.
+func newWebDAVStorage(config domain.ConnectionConfig) (domain.StorageRepository, error) {
+	baseURL, err := url.Parse(config.Endpoint)
+	if err != nil || baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an absolute URL, got: %v", config.Endpoint)
+	}
+	transport := http.DefaultTransport
+	if user := config.Credential("user"); user != "" {
+		transport = &basicAuthTransport{user: user, password: config.Credential("password"), next: transport}
+	}
+	client := &http.Client{Transport: transport}
+	return NewWebDAVRepository(client, baseURL, remoteRoots(config.Option("roots"))), nil
+}
+
+type basicAuthTransport struct {
+	user     string
+	password string
+	next     http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	request = request.Clone(request.Context())
+	request.SetBasicAuth(t.user, t.password)
+	return t.next.RoundTrip(request)
+}
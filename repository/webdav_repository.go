@@ -0,0 +1,486 @@
+package repository
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// webDAVNamespace holds the dead property in which object metadata is stored as JSON
+const webDAVNamespace = "urn:smarthub:"
+
+const webDAVPropfind = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:sh="` + webDAVNamespace + `"><d:prop>
+<d:resourcetype/><d:getcontentlength/><d:getlastmodified/><d:getetag/><d:getcontenttype/><sh:metadata/>
+</d:prop></d:propfind>`
+
+type webDAVRepository struct {
+	client  *http.Client
+	baseURL *url.URL
+	roots   map[string]string
+}
+
+type davMultiStatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href     string `xml:"DAV: href"`
+	PropStat []struct {
+		Status string  `xml:"DAV: status"`
+		Prop   davProp `xml:"DAV: prop"`
+	} `xml:"DAV: propstat"`
+}
+
+type davProp struct {
+	ResourceType struct {
+		Collection *struct{} `xml:"DAV: collection"`
+	} `xml:"DAV: resourcetype"`
+	ContentLength string `xml:"DAV: getcontentlength"`
+	LastModified  string `xml:"DAV: getlastmodified"`
+	ETag          string `xml:"DAV: getetag"`
+	ContentType   string `xml:"DAV: getcontenttype"`
+	Metadata      string `xml:"urn:smarthub: metadata"`
+}
+
+type webDAVError struct {
+	Method     string
+	URL        string
+	StatusCode int
+}
+
+func (e *webDAVError) Error() string {
+	return fmt.Sprintf("webdav %v %v responded %v", e.Method, e.URL, e.StatusCode)
+}
+
+// Is matches a missing resource with fs.ErrNotExist, as the errors of the local backend do.
+func (e *webDAVError) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+// NewWebDAVRepository exposes collections of a WebDAV share as stores. roots maps store
+// names to collection paths relative to baseURL, client carries the authentication.
+func NewWebDAVRepository(client *http.Client, baseURL *url.URL, roots map[string]string) domain.StorageRepository {
+	return &webDAVRepository{
+		client:  client,
+		baseURL: baseURL,
+		roots:   roots,
+	}
+}
+
+func (w *webDAVRepository) StoreNames() ([]string, error) {
+	storeNames := make([]string, 0, len(w.roots))
+	for name := range w.roots {
+		storeNames = append(storeNames, name)
+	}
+	sort.Strings(storeNames)
+	return storeNames, nil
+}
+
+func (w *webDAVRepository) Objects(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := w.list(storeName, prefix, false)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+func (w *webDAVRepository) ObjectsWithMetadata(storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	storageObjects, err := w.list(storeName, prefix, true)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return pageOf(storageObjects, maxObjectsPerPage, token)
+}
+
+func (w *webDAVRepository) Browse(storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	storageObjects, err := w.list(storeName, prefix, false)
+	if err != nil {
+		return domain.ObjectPage{}, err
+	}
+	return browseOf(storageObjects, maxObjectsPerPage, token, prefix, delimiter, withSizes)
+}
+
+func (w *webDAVRepository) GetObject(params *domain.ObjectParams) (domain.StorageObject, error) {
+	target, err := w.objectURL(params.StoreName, params.Key)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	responses, err := w.propfind(target, "0")
+	if err == nil && (len(responses) == 0 || responses[0].ResourceType.Collection != nil) {
+		err = fmt.Errorf("%v is not a file", params.Key)
+	}
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return toWebDAVStorageObject(params.StoreName, cleanKey(params.Key), responses[0].davProp, true), nil
+}
+
+// Upload streams file with a PUT and stores metadata in a dead property afterwards.
+func (w *webDAVRepository) Upload(params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	target, err := w.objectURL(params.StoreName, params.Key)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	err = w.makeCollections(params.StoreName, path.Dir(cleanKey(params.Key)))
+	if err == nil {
+		var response *http.Response
+		response, err = w.do(http.MethodPut, target, file, map[string]string{"Content-Type": uploadContentType(params)})
+		if err == nil {
+			response.Body.Close()
+			err = w.setMetadata(target, metadata)
+		}
+	}
+	if err != nil {
+		log.Printf("Couldn't upload file %v to %v. Here's why: %v\n",
+			params.Key, params.StoreName, err)
+		return domain.StorageObject{}, err
+	}
+	return w.GetObject(params)
+}
+
+func (w *webDAVRepository) PresignUploadLink(params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (w *webDAVRepository) Open(params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	object, err := w.GetObject(params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	if err = params.Conditions.Check(object); err != nil {
+		return domain.ObjectContent{}, err
+	}
+	target, err := w.objectURL(params.StoreName, params.Key)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	var headers map[string]string
+	if byteRange != nil {
+		headers = map[string]string{"Range": rangeHeader(byteRange)}
+	}
+	response, err := w.do(http.MethodGet, target, nil, headers)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	body, err := rangedBody(response, byteRange)
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v. Here's why: %v\n", params.StoreName, params.Key, err)
+		return domain.ObjectContent{}, err
+	}
+	contentType := response.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = contentTypeOf(params.Key)
+	}
+	return domain.ObjectContent{
+		Object:      object,
+		ContentType: contentType,
+		Body:        body,
+	}, nil
+}
+
+func (w *webDAVRepository) PresignDownloadLink(params *domain.ObjectParams) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (w *webDAVRepository) PresignDownloadLinkWithExpTime(params *domain.ObjectParams, exp uint) (string, error) {
+	return "", domain.ErrNotSupported
+}
+
+func (w *webDAVRepository) DeleteAll(storeName string, pathPrefix string, dryRun bool) (domain.DeleteAllReport, error) {
+	objects, err := w.list(storeName, pathPrefix, false)
+	if err != nil {
+		return domain.DeleteAllReport{}, err
+	}
+	return deleteEach(storeName, pathPrefix, objects, dryRun, func(key string) error {
+		_, err := w.Delete(&domain.ObjectParams{StoreName: storeName, Key: key})
+		return err
+	}), nil
+}
+
+func (w *webDAVRepository) Delete(params *domain.ObjectParams) (bool, error) {
+	target, err := w.objectURL(params.StoreName, params.Key)
+	if err != nil {
+		return false, err
+	}
+	response, err := w.do(http.MethodDelete, target, nil, nil)
+	if err != nil {
+		log.Printf("Couldn't delete %v:%v. Here's why: %v\n",
+			params.StoreName, params.Key, err)
+		return false, err
+	}
+	response.Body.Close()
+	return true, nil
+}
+
+// Copy uses the COPY verb, the server copies dead properties and therefore metadata along.
+func (w *webDAVRepository) Copy(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	if err := w.transfer("COPY", current, destination); err != nil {
+		log.Printf("Couldn't copy object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return w.GetObject(destination)
+}
+
+func (w *webDAVRepository) CopyAll(sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	return copyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical, bulkConcurrency,
+		func(storeName string, prefix string) ([]domain.StorageObject, error) {
+			return w.list(storeName, prefix, false)
+		},
+		func(current *domain.ObjectParams, destination *domain.ObjectParams) error {
+			_, err := w.Copy(current, destination)
+			return err
+		})
+}
+
+// Move uses the MOVE verb, which renames on the server.
+func (w *webDAVRepository) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	if err := w.transfer("MOVE", current, destination); err != nil {
+		log.Printf("Couldn't move object from %v:%v to %v:%v. Here's why: %v\n",
+			current.StoreName, current.Key, destination.StoreName, destination.Key, err)
+		return domain.StorageObject{}, err
+	}
+	return w.GetObject(destination)
+}
+
+func (w *webDAVRepository) transfer(method string, current *domain.ObjectParams, destination *domain.ObjectParams) error {
+	source, err := w.objectURL(current.StoreName, current.Key)
+	if err != nil {
+		return err
+	}
+	target, err := w.objectURL(destination.StoreName, destination.Key)
+	if err != nil {
+		return err
+	}
+	if err = w.makeCollections(destination.StoreName, path.Dir(cleanKey(destination.Key))); err != nil {
+		return err
+	}
+	response, err := w.do(method, source, nil, map[string]string{
+		"Destination": target,
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	// a multi-status answer to COPY or MOVE only ever reports failures
+	if response.StatusCode == http.StatusMultiStatus {
+		return &webDAVError{Method: method, URL: source, StatusCode: response.StatusCode}
+	}
+	return nil
+}
+
+// list walks the collections which can hold keys starting with prefix, one level per PROPFIND,
+// since many servers refuse "Depth: infinity".
+func (w *webDAVRepository) list(storeName string, prefix string, withMetadata bool) ([]domain.StorageObject, error) {
+	rootPath, err := w.rootPath(storeName)
+	if err != nil {
+		return nil, err
+	}
+	prefix = strings.TrimLeft(prefix, "/")
+	storageObjects := []domain.StorageObject{}
+	pending := []string{keyOf("/", walkRoot("/", prefix))}
+	for len(pending) > 0 {
+		collection := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		target, _ := w.objectURL(storeName, collection)
+		responses, err := w.propfind(target+"/", "1")
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Couldn't get objects from %v. Here's why: %v\n", storeName, err)
+			return nil, err
+		}
+		for _, response := range responses {
+			key := keyOf(rootPath, strings.TrimRight(response.path, "/"))
+			if key == collection || !strings.HasPrefix(key, collection) {
+				continue
+			}
+			if response.ResourceType.Collection != nil {
+				if canContain(key, prefix) {
+					pending = append(pending, key)
+				}
+				continue
+			}
+			if strings.HasPrefix(key, prefix) {
+				storageObjects = append(storageObjects, toWebDAVStorageObject(storeName, key, response.davProp, withMetadata))
+			}
+		}
+	}
+	sort.Slice(storageObjects, func(i, j int) bool {
+		return storageObjects[i].Key < storageObjects[j].Key
+	})
+	return storageObjects, nil
+}
+
+type davEntry struct {
+	path string
+	davProp
+}
+
+func (w *webDAVRepository) propfind(target string, depth string) ([]davEntry, error) {
+	response, err := w.do("PROPFIND", target, strings.NewReader(webDAVPropfind), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	var multiStatus davMultiStatus
+	if err = xml.NewDecoder(response.Body).Decode(&multiStatus); err != nil {
+		return nil, err
+	}
+	entries := make([]davEntry, 0, len(multiStatus.Responses))
+	for _, davResponse := range multiStatus.Responses {
+		href, err := url.Parse(davResponse.Href)
+		if err != nil {
+			return nil, err
+		}
+		entry := davEntry{path: href.Path}
+		// properties the server doesn't know come back in their own propstat with a 404 status
+		for _, propStat := range davResponse.PropStat {
+			if strings.Contains(propStat.Status, " 200 ") {
+				entry.davProp = propStat.Prop
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (w *webDAVRepository) setMetadata(target string, metadata map[string]string) error {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:propertyupdate xmlns:d="DAV:" xmlns:sh="` + webDAVNamespace + `">`)
+	if len(metadata) == 0 {
+		body.WriteString(`<d:remove><d:prop><sh:metadata/></d:prop></d:remove>`)
+	} else {
+		content, err := json.Marshal(metadata)
+		if err != nil {
+			return err
+		}
+		body.WriteString(`<d:set><d:prop><sh:metadata>`)
+		if err = xml.EscapeText(&body, content); err != nil {
+			return err
+		}
+		body.WriteString(`</sh:metadata></d:prop></d:set>`)
+	}
+	body.WriteString(`</d:propertyupdate>`)
+	response, err := w.do("PROPPATCH", target, &body, map[string]string{"Content-Type": "application/xml; charset=utf-8"})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var multiStatus davMultiStatus
+	if err = xml.NewDecoder(response.Body).Decode(&multiStatus); err != nil && err != io.EOF {
+		return err
+	}
+	for _, davResponse := range multiStatus.Responses {
+		for _, propStat := range davResponse.PropStat {
+			if !strings.Contains(propStat.Status, " 200 ") {
+				return fmt.Errorf("webdav PROPPATCH %v failed: %v", target, propStat.Status)
+			}
+		}
+	}
+	return nil
+}
+
+// makeCollections creates dir and its parents, including the store root itself, below the
+// base URL. MKCOL on an existing collection answers 405, which is fine.
+func (w *webDAVRepository) makeCollections(storeName string, dir string) error {
+	root, ok := w.roots[storeName]
+	if !ok {
+		return fmt.Errorf("store %v is not configured", storeName)
+	}
+	target := *w.baseURL
+	target.RawPath = ""
+	current := path.Join("/", w.baseURL.Path)
+	for _, segment := range strings.Split(path.Join(root, path.Clean("/"+dir)), "/") {
+		if segment == "" {
+			continue
+		}
+		current = path.Join(current, segment)
+		target.Path = current + "/"
+		response, err := w.do("MKCOL", target.String(), nil, nil)
+		if davErr, ok := err.(*webDAVError); ok && davErr.StatusCode == http.StatusMethodNotAllowed {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+	}
+	return nil
+}
+
+// do sends the request and turns every status outside of 2xx into a webDAVError.
+func (w *webDAVRepository) do(method string, target string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	request, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response, err := w.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		response.Body.Close()
+		return nil, &webDAVError{Method: method, URL: target, StatusCode: response.StatusCode}
+	}
+	return response, nil
+}
+
+func (w *webDAVRepository) rootPath(storeName string) (string, error) {
+	root, ok := w.roots[storeName]
+	if !ok {
+		return "", fmt.Errorf("store %v is not configured", storeName)
+	}
+	return path.Join("/", w.baseURL.Path, root), nil
+}
+
+func (w *webDAVRepository) objectURL(storeName string, key string) (string, error) {
+	rootPath, err := w.rootPath(storeName)
+	if err != nil {
+		return "", err
+	}
+	target := *w.baseURL
+	target.Path = joinKey(rootPath, key)
+	target.RawPath = ""
+	return target.String(), nil
+}
+
+func toWebDAVStorageObject(storeName string, key string, prop davProp, withMetadata bool) domain.StorageObject {
+	object := domain.StorageObject{
+		StoreName: storeName,
+		Key:       key,
+		ETag:      prop.ETag,
+	}
+	object.Size, _ = strconv.ParseInt(prop.ContentLength, 10, 64)
+	if modified, err := http.ParseTime(prop.LastModified); err == nil {
+		object.LastModified = modified.UnixMilli()
+	}
+	if withMetadata && prop.Metadata != "" {
+		_ = json.Unmarshal([]byte(prop.Metadata), &object.Metadata)
+	}
+	return object
+}
@@ -0,0 +1,207 @@
+package repository
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"golang.org/x/net/webdav"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// newTestWebDAVRepository serves the stores "files" and "other" from an in-memory WebDAV server
+// below /base. failing answers every request for which it returns true with a 207 failure.
+func newTestWebDAVRepository(t *testing.T, failing func(request *http.Request) bool) domain.StorageRepository {
+	t.Helper()
+	handler := &webdav.Handler{Prefix: "/base", FileSystem: webdav.NewMemFS(), LockSystem: webdav.NewMemLS()}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if failing != nil && failing(request) {
+			writer.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			writer.WriteHeader(http.StatusMultiStatus)
+			io.WriteString(writer, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:"><d:response>`+
+				`<d:href>`+request.URL.Path+`</d:href><d:status>HTTP/1.1 423 Locked</d:status></d:response></d:multistatus>`)
+			return
+		}
+		handler.ServeHTTP(writer, request)
+	}))
+	t.Cleanup(server.Close)
+	baseURL, err := url.Parse(server.URL + "/base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWebDAVRepository(server.Client(), baseURL, map[string]string{"files": "dav/files", "other": "dav/other"})
+}
+
+func TestWebDAVObjectsPages(t *testing.T) {
+	repository := newTestWebDAVRepository(t, nil)
+	equalKeys(t, listKeys(t, repository, "files", "", 0))
+	for _, key := range []string{"b/2.txt", "a.txt", "b/1.txt", "b/c/3.txt", "d.txt", "e f.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	upload(t, repository, "other", "x.txt", "x")
+	equalKeys(t, listKeys(t, repository, "files", "", 2), "a.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "d.txt", "e f.txt")
+	equalKeys(t, listKeys(t, repository, "files", "/b/", 0), "b/1.txt", "b/2.txt", "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "b/c", 1), "b/c/3.txt")
+	equalKeys(t, listKeys(t, repository, "files", "missing/", 0))
+
+	page, err := repository.Browse("files", 0, "", "b/", "/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 2 || len(page.Folders) != 1 || page.Folders[0].Prefix != "b/c/" || page.Folders[0].Size != 9 {
+		t.Fatalf("unexpected browse page %+v", page)
+	}
+	if _, err = repository.Objects("files", 2, "%%%", ""); !errors.Is(err, domain.ErrPageTokenInvalid) {
+		t.Fatalf("got %v for an invalid token, want ErrPageTokenInvalid", err)
+	}
+	if _, err = repository.Objects("missing", 0, "", ""); err == nil {
+		t.Fatal("listed a store which isn't configured")
+	}
+
+	object, err := repository.GetObject(&domain.ObjectParams{StoreName: "files", Key: "/b/c/3.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.StoreName != "files" || object.Key != "b/c/3.txt" || object.Size != 9 || object.ETag == "" || object.LastModified == 0 {
+		t.Fatalf("unexpected object %+v", object)
+	}
+	// collections aren't objects
+	if _, err = repository.GetObject(&domain.ObjectParams{StoreName: "files", Key: "b/c"}); err == nil {
+		t.Fatal("got a collection as an object")
+	}
+	if _, err = repository.GetObject(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v for a missing object, want fs.ErrNotExist", err)
+	}
+}
+
+func TestWebDAVUploadAndOpenRange(t *testing.T) {
+	repository := newTestWebDAVRepository(t, nil)
+	object, err := repository.Upload(&domain.ObjectParams{StoreName: "files", Key: "/docs/hello.txt"},
+		map[string]string{"origin": "dav", "note": "<a & b>"}, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Key != "docs/hello.txt" || object.Size != 11 || object.Metadata["origin"] != "dav" || object.Metadata["note"] != "<a & b>" {
+		t.Fatalf("unexpected uploaded object %+v", object)
+	}
+	page, err := repository.ObjectsWithMetadata("files", 0, "", "docs/")
+	if err != nil || len(page.Objects) != 1 || page.Objects[0].Metadata["origin"] != "dav" {
+		t.Fatalf("listed %+v, %v", page, err)
+	}
+	if page, err = repository.Objects("files", 0, "", "docs/"); err != nil || len(page.Objects[0].Metadata) != 0 {
+		t.Fatalf("listed %+v, %v without metadata", page, err)
+	}
+
+	content, err := repository.Open(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"}, &domain.ByteRange{Offset: 6, Length: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(content.Body)
+	content.Body.Close()
+	if err != nil || string(data) != "wor" {
+		t.Fatalf("range read %q, %v, want \"wor\"", data, err)
+	}
+	if content.Object.Size != 11 || content.Object.Metadata["origin"] != "dav" || content.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content %+v with type %v", content.Object, content.ContentType)
+	}
+
+	// uploading again without metadata removes the metadata of the replaced object
+	upload(t, repository, "files", "docs/hello.txt", "replaced")
+	if got := read(t, repository, "files", "docs/hello.txt"); got != "replaced" {
+		t.Fatalf("docs/hello.txt has %q", got)
+	}
+	if object, err = repository.GetObject(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt"}); err != nil || len(object.Metadata) != 0 {
+		t.Fatalf("got %+v, %v", object, err)
+	}
+	if _, err = repository.Open(&domain.ObjectParams{StoreName: "files", Key: "missing.txt"}, nil); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v opening a missing object, want fs.ErrNotExist", err)
+	}
+	if _, err = repository.Open(&domain.ObjectParams{StoreName: "files", Key: "docs/hello.txt", Conditions: &domain.Conditions{IfMatch: `"other"`}}, nil); !errors.Is(err, domain.ErrPreconditionFailed) {
+		t.Fatalf("got %v for a failed If-Match, want ErrPreconditionFailed", err)
+	}
+}
+
+func TestWebDAVCopyAndMove(t *testing.T) {
+	repository := newTestWebDAVRepository(t, nil)
+	uploadWithMetadata(t, repository, "files", "a.txt", "hello", map[string]string{"origin": "dav"})
+	uploadWithMetadata(t, repository, "other", "b.txt", "replaced", map[string]string{"stale": "yes"})
+
+	// the parents of the destination don't exist yet and are created with MKCOL
+	copied, err := repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "/new/dir/c.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied.StoreName != "other" || copied.Key != "new/dir/c.txt" || copied.Size != 5 || copied.Metadata["origin"] != "dav" {
+		t.Fatalf("unexpected copy %+v", copied)
+	}
+	if got := read(t, repository, "other", "new/dir/c.txt"); got != "hello" || !exists(repository, "files", "a.txt") {
+		t.Fatalf("copy has %q", got)
+	}
+
+	// an existing destination is overwritten
+	moved, err := repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "b.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Size != 5 || moved.Metadata["origin"] != "dav" || moved.Metadata["stale"] != "" || exists(repository, "files", "a.txt") {
+		t.Fatalf("unexpected move %+v", moved)
+	}
+	if got := read(t, repository, "other", "b.txt"); got != "hello" {
+		t.Fatalf("b.txt has %q", got)
+	}
+	equalKeys(t, listKeys(t, repository, "other", "", 0), "b.txt", "new/dir/c.txt")
+
+	if _, err = repository.Move(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "other", Key: "d.txt"}); err == nil {
+		t.Fatal("moved a missing object")
+	}
+	if _, err = repository.Copy(&domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ObjectParams{StoreName: "missing", Key: "d.txt"}); err == nil {
+		t.Fatal("copied into a store which isn't configured")
+	}
+}
+
+func TestWebDAVCopyMultiStatusFails(t *testing.T) {
+	repository := newTestWebDAVRepository(t, func(request *http.Request) bool {
+		return (request.Method == "COPY" || request.Method == "MOVE") && request.URL.Path == "/base/dav/files/locked.txt"
+	})
+	upload(t, repository, "files", "locked.txt", "locked")
+	for _, transfer := range []func(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error){repository.Copy, repository.Move} {
+		_, err := transfer(&domain.ObjectParams{StoreName: "files", Key: "locked.txt"}, &domain.ObjectParams{StoreName: "other", Key: "locked.txt"})
+		var davErr *webDAVError
+		if !errors.As(err, &davErr) || davErr.StatusCode != http.StatusMultiStatus || errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("got %v, want a 207 failure", err)
+		}
+	}
+	if !exists(repository, "files", "locked.txt") || exists(repository, "other", "locked.txt") {
+		t.Fatal("the failed transfers changed the stores")
+	}
+}
+
+func TestWebDAVDeleteAll(t *testing.T) {
+	repository := newTestWebDAVRepository(t, nil)
+	for _, key := range []string{"logs/a.txt", "logs/b/c.txt", "logs-old/d.txt", "keep.txt"} {
+		upload(t, repository, "files", key, key)
+	}
+	if deleted, err := repository.Delete(&domain.ObjectParams{StoreName: "files", Key: "keep.txt"}); err != nil || !deleted {
+		t.Fatalf("Delete returned %v, %v", deleted, err)
+	}
+	if _, err := repository.Delete(&domain.ObjectParams{StoreName: "files", Key: "keep.txt"}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("got %v deleting a missing object, want fs.ErrNotExist", err)
+	}
+
+	report, err := repository.DeleteAll("files", "logs/", true)
+	if err != nil || report.Deleted != 0 || len(report.Results) != 2 || report.Results[0].Status != domain.StatusDryRun {
+		t.Fatalf("dry run returned %+v, %v", report, err)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "logs-old/d.txt", "logs/a.txt", "logs/b/c.txt")
+	if report, err = repository.DeleteAll("files", "/logs/", false); err != nil || report.Deleted != 2 || report.Failed != 0 {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if report.Results[0].Key != "logs/a.txt" || report.Results[1].Key != "logs/b/c.txt" {
+		t.Fatalf("unexpected results %+v", report.Results)
+	}
+	equalKeys(t, listKeys(t, repository, "files", "", 0), "logs-old/d.txt")
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// moveAllConcurrency bounds the objects a move of a path moves at once.
+	moveAllConcurrency = 8
+	// moveProgressInterval is the number of objects after which a move of a path saves its record.
+	moveProgressInterval = 100
+)
+
+// MoveAll moves every object below sourcePath to the same relative key below targetPath, one by
+// one with the verified Move of the repository. Both paths are folders, an empty one is the whole
+// store. Its progress is recorded below the journal dir. The objects moved already are gone from
+// the source, so running an interrupted or failed move again moves what is left. That's why the
+// target must not lie below the source.
+func (s *smartService) MoveAll(connection string, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) (domain.MoveAllRecord, error) {
+	repository, err := s.GetRepository(connection)
+	if err != nil {
+		return domain.MoveAllRecord{}, err
+	}
+	sourcePath = domain.FolderPath(sourcePath)
+	targetPath = domain.FolderPath(targetPath)
+	if sourceStoreName == targetStoreName && strings.HasPrefix(targetPath, sourcePath) {
+		return domain.MoveAllRecord{}, errors.New("target path must not lie below the source path")
+	}
+	id := moveID(connection, sourceStoreName, sourcePath, targetStoreName, targetPath)
+	if _, running := s.moves.LoadOrStore(connection+"/"+id, true); running {
+		return domain.MoveAllRecord{}, fmt.Errorf("move %v is running already", id)
+	}
+	defer s.moves.Delete(connection + "/" + id)
+
+	record, err := s.moveRecord(connection, id)
+	if errors.Is(err, domain.ErrMoveNotFound) {
+		record, err = domain.MoveAllRecord{
+			ID:              id,
+			SourceStoreName: sourceStoreName,
+			SourcePath:      sourcePath,
+			TargetStoreName: targetStoreName,
+			TargetPath:      targetPath,
+			Started:         time.Now().UnixMilli(),
+		}, nil
+	}
+	if err != nil {
+		return domain.MoveAllRecord{}, err
+	}
+	objects, err := s.listAll(repository, sourceStoreName, sourcePath)
+	if err != nil {
+		return domain.MoveAllRecord{}, err
+	}
+	record.Status = domain.MoveRunning
+	record.Failed = 0
+	record.Failures = nil
+	record.Remaining = len(objects)
+	if err = s.saveMoveRecord(connection, &record); err != nil {
+		return domain.MoveAllRecord{}, err
+	}
+
+	var mutex sync.Mutex
+	pending := make(chan domain.StorageObject)
+	go func() {
+		defer close(pending)
+		for _, object := range objects {
+			pending <- object
+		}
+	}()
+	var workers sync.WaitGroup
+	for i := 0; i < moveAllConcurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for object := range pending {
+				result := domain.CopyResult{
+					SourceKey: object.Key,
+					TargetKey: targetPath + strings.TrimPrefix(object.Key, sourcePath),
+					Status:    domain.StatusMoved,
+				}
+				_, err := repository.Move(&domain.ObjectParams{StoreName: sourceStoreName, Key: result.SourceKey},
+					&domain.ObjectParams{StoreName: targetStoreName, Key: result.TargetKey})
+				mutex.Lock()
+				if err != nil {
+					result.Status = domain.StatusFailed
+					result.Error = err.Error()
+					record.Failed++
+					record.Failures = append(record.Failures, result)
+				} else {
+					record.Moved++
+				}
+				record.Remaining--
+				if done := len(objects) - record.Remaining; done%moveProgressInterval == 0 {
+					log.Printf("Moved %v of %v objects from %v:%v to %v:%v\n",
+						done, len(objects), sourceStoreName, sourcePath, targetStoreName, targetPath)
+					s.saveMoveRecord(connection, &record)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+	workers.Wait()
+
+	sort.Slice(record.Failures, func(i, j int) bool {
+		return record.Failures[i].SourceKey < record.Failures[j].SourceKey
+	})
+	if record.Failed > 0 {
+		record.Status = domain.MoveFailed
+		return record, s.saveMoveRecord(connection, &record)
+	}
+	record.Status = domain.MoveCompleted
+	record.Updated = time.Now().UnixMilli()
+	if err = os.Remove(s.moveRecordPath(connection, id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return domain.MoveAllRecord{}, err
+	}
+	return record, nil
+}
+
+// ResumeMoveAll runs the move with the given record again.
+func (s *smartService) ResumeMoveAll(connection string, id string) (domain.MoveAllRecord, error) {
+	record, err := s.moveRecord(connection, id)
+	if err != nil {
+		return domain.MoveAllRecord{}, err
+	}
+	return s.MoveAll(connection, record.SourceStoreName, record.SourcePath, record.TargetStoreName, record.TargetPath)
+}
+
+// MoveAllRecords lists the moves of the connection which haven't completed. A move recorded as
+// running which doesn't run in this hub has been interrupted.
+func (s *smartService) MoveAllRecords(connection string) ([]domain.MoveAllRecord, error) {
+	if _, err := s.GetRepository(connection); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(filepath.Join(s.journalDir, connection))
+	if errors.Is(err, os.ErrNotExist) {
+		return []domain.MoveAllRecord{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	records := []domain.MoveAllRecord{}
+	for _, entry := range entries {
+		id, found := strings.CutSuffix(entry.Name(), ".json")
+		if !found || !hexID.MatchString(id) {
+			continue
+		}
+		record, err := s.moveRecord(connection, id)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Started < records[j].Started
+	})
+	return records, nil
+}
+
+// moveID derives the id of a move from what it moves, so running the same move again finds its record.
+func moveID(connection string, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{connection, sourceStoreName, sourcePath, targetStoreName, targetPath}, "\x00")))
+	return hex.EncodeToString(sum[:16])
+}
+
+// listAll pages through every object below prefix.
+func (s *smartService) listAll(repository domain.StorageRepository, storeName string, prefix string) ([]domain.StorageObject, error) {
+	objects := []domain.StorageObject{}
+	token := ""
+	for {
+		page, err := repository.Objects(storeName, 1000, token, prefix)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Objects...)
+		if !page.IsTruncated || page.NextToken == "" {
+			return objects, nil
+		}
+		token = page.NextToken
+	}
+}
+
+func (s *smartService) moveRecord(connection string, id string) (domain.MoveAllRecord, error) {
+	var record domain.MoveAllRecord
+	if !hexID.MatchString(id) {
+		return record, domain.ErrMoveNotFound
+	}
+	content, err := os.ReadFile(s.moveRecordPath(connection, id))
+	if errors.Is(err, os.ErrNotExist) {
+		return record, domain.ErrMoveNotFound
+	}
+	if err != nil {
+		return record, err
+	}
+	if err = json.Unmarshal(content, &record); err != nil {
+		return record, err
+	}
+	if _, running := s.moves.Load(connection + "/" + id); record.Status == domain.MoveRunning && !running {
+		record.Status = domain.MoveInterrupted
+	}
+	return record, nil
+}
+
+// saveMoveRecord writes the record through a temporary file, so it's never read half-written.
+func (s *smartService) saveMoveRecord(connection string, record *domain.MoveAllRecord) error {
+	record.Updated = time.Now().UnixMilli()
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	path := s.moveRecordPath(connection, record.ID)
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	if err = os.WriteFile(path+".tmp", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *smartService) moveRecordPath(connection string, id string) string {
+	return filepath.Join(s.journalDir, connection, id+".json")
+}
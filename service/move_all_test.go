@@ -0,0 +1,149 @@
+package service
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"strings"
+	"testing"
+)
+
+// failingMoves fails the moves of the source keys in fail.
+type failingMoves struct {
+	domain.StorageRepository
+	fail map[string]bool
+}
+
+func (f *failingMoves) Move(current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	if f.fail[current.Key] {
+		return domain.StorageObject{}, errors.New("move failed")
+	}
+	return f.StorageRepository.Move(current, destination)
+}
+
+func newMoveTestService(t *testing.T, fail ...string) (SmartService, *failingMoves) {
+	t.Helper()
+	moves := &failingMoves{
+		StorageRepository: repository.NewMemoryRepository([]string{"files", "other"}, "http://hub.test/api/mem/presigned", []byte("test key")),
+		fail:              map[string]bool{},
+	}
+	for _, key := range fail {
+		moves.fail[key] = true
+	}
+	return NewSmartService([]domain.Connection{{Name: "mem", Type: repository.MEMORY, Repository: moves}}, t.TempDir(), t.TempDir()), moves
+}
+
+func keysOf(t *testing.T, service SmartService, storeName string) string {
+	t.Helper()
+	page, err := service.Objects("mem", storeName, 0, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, 0, len(page.Objects))
+	for _, object := range page.Objects {
+		keys = append(keys, object.Key)
+	}
+	return strings.Join(keys, ",")
+}
+
+func TestMoveAllMapsFolders(t *testing.T) {
+	service, _ := newMoveTestService(t)
+	for _, key := range []string{"docs/a.txt", "docs/b/c.txt", "docs-old/d.txt"} {
+		put(t, service, "files", key, key)
+	}
+	record, err := service.MoveAll("mem", "files", "/docs", "files", "docs-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != domain.MoveCompleted || record.Moved != 2 || record.SourcePath != "docs/" || record.TargetPath != "docs-archive/" {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if got := keysOf(t, service, "files"); got != "docs-archive/a.txt,docs-archive/b/c.txt,docs-old/d.txt" {
+		t.Fatalf("got keys %v", got)
+	}
+
+	if _, err = service.MoveAll("mem", "files", "docs-archive", "other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := keysOf(t, service, "other"); got != "a.txt,b/c.txt" {
+		t.Fatalf("got keys %v in the root of the other store", got)
+	}
+
+	for _, paths := range [][2]string{{"docs-old", "docs-old/sub"}, {"docs-old/", "docs-old"}, {"", "archive"}} {
+		if _, err = service.MoveAll("mem", "files", paths[0], "files", paths[1]); err == nil {
+			t.Errorf("moved %q into %q below it", paths[0], paths[1])
+		}
+	}
+}
+
+func TestMoveAllResumesFailedMove(t *testing.T) {
+	service, moves := newMoveTestService(t, "docs/b.txt")
+	for _, key := range []string{"docs/a.txt", "docs/b.txt", "docs/c.txt"} {
+		put(t, service, "files", key, key)
+	}
+	record, err := service.MoveAll("mem", "files", "docs", "other", "docs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != domain.MoveFailed || record.Moved != 2 || record.Failed != 1 || record.Failures[0].SourceKey != "docs/b.txt" {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	records, err := service.MoveAllRecords("mem")
+	if err != nil || len(records) != 1 || records[0].ID != record.ID || records[0].Status != domain.MoveFailed {
+		t.Fatalf("got records %+v, %v", records, err)
+	}
+
+	delete(moves.fail, "docs/b.txt")
+	record, err = service.ResumeMoveAll("mem", record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Status != domain.MoveCompleted || record.Moved != 3 || record.Failed != 0 || record.Remaining != 0 {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	if got := keysOf(t, service, "other"); got != "docs/a.txt,docs/b.txt,docs/c.txt" {
+		t.Fatalf("got keys %v", got)
+	}
+	if records, _ = service.MoveAllRecords("mem"); len(records) != 0 {
+		t.Fatalf("completed move is still recorded: %+v", records)
+	}
+	if _, err = service.ResumeMoveAll("mem", record.ID); !errors.Is(err, domain.ErrMoveNotFound) {
+		t.Fatalf("got %v resuming a completed move, want ErrMoveNotFound", err)
+	}
+}
+
+func TestMoveAllResumesInterruptedMove(t *testing.T) {
+	service, _ := newMoveTestService(t)
+	for _, key := range []string{"docs/a.txt", "docs/b.txt"} {
+		put(t, service, "files", key, key)
+	}
+	// a hub which stopped after it moved one object left this record behind
+	record := domain.MoveAllRecord{
+		ID:              moveID("mem", "files", "docs/", "other", "moved/"),
+		SourceStoreName: "files",
+		SourcePath:      "docs/",
+		TargetStoreName: "other",
+		TargetPath:      "moved/",
+		Status:          domain.MoveRunning,
+		Moved:           1,
+		Remaining:       2,
+		Started:         1000,
+	}
+	if err := service.(*smartService).saveMoveRecord("mem", &record); err != nil {
+		t.Fatal(err)
+	}
+	records, err := service.MoveAllRecords("mem")
+	if err != nil || len(records) != 1 || records[0].Status != domain.MoveInterrupted {
+		t.Fatalf("got records %+v, %v", records, err)
+	}
+	resumed, err := service.ResumeMoveAll("mem", record.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Status != domain.MoveCompleted || resumed.Moved != 3 || resumed.Started != 1000 {
+		t.Fatalf("unexpected record %+v", resumed)
+	}
+	if got := keysOf(t, service, "other"); got != "moved/a.txt,moved/b.txt" {
+		t.Fatalf("got keys %v", got)
+	}
+}
@@ -5,83 +5,148 @@ import (
 	"fmt"
 	"github.com/nevcodia/smarthub/domain"
 	"io"
-	"mime/multipart"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 )
 
 type SmartService interface {
-	StoreNames(storeType domain.StorageType) ([]string, error)
-	Objects(storeType domain.StorageType, storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]domain.StorageObject, error)
-	ObjectsWithMetadata(storeType domain.StorageType, storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]domain.StorageObject, error)
-	GetObject(storeType domain.StorageType, params *domain.ObjectParams) (domain.StorageObject, error)
-	UploadMultiPart(storeType domain.StorageType, params *domain.ObjectParams, metadata map[string]string, fileHeader *multipart.FileHeader) (domain.StorageObject, error)
-	Upload(storeType domain.StorageType, params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error)
-	PresignUploadLink(storeType domain.StorageType, params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error)
-	Download(storeType domain.StorageType, params *domain.ObjectParams) (domain.DownloadFileResponse, error)
-	PresignDownloadLink(storeType domain.StorageType, params *domain.ObjectParams) (string, error)
-	PresignDownloadLinkWithExpTime(storeType domain.StorageType, params *domain.ObjectParams, exp uint) (string, error)
-	DeleteAll(storeType domain.StorageType, storeName string, pathPrefix string) (bool, error)
-	Delete(storeType domain.StorageType, params *domain.ObjectParams) (bool, error)
-	Copy(storeType domain.StorageType, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error)
-	CopyAll(storeType domain.StorageType, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) ([]domain.StorageObject, error)
-	Move(storeType domain.StorageType, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error)
+	Connections() []domain.Connection
+	StoreNames(connection string) ([]string, error)
+	Objects(connection string, storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error)
+	ObjectsWithMetadata(connection string, storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error)
+	Browse(connection string, storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error)
+	GetObject(connection string, params *domain.ObjectParams) (domain.StorageObject, error)
+	Upload(connection string, params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error)
+	PresignUploadLink(connection string, params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error)
+	PresignPost(connection string, storeName string, policy domain.PostPolicy) (domain.PresignedPost, error)
+	Download(connection string, params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error)
+	PresignDownloadLink(connection string, params *domain.ObjectParams) (string, error)
+	PresignDownloadLinkWithExpTime(connection string, params *domain.ObjectParams, exp uint) (string, error)
+	DeleteAll(connection string, storeName string, pathPrefix string, all bool, dryRun bool) (domain.DeleteAllReport, error)
+	Delete(connection string, params *domain.ObjectParams) (bool, error)
+	Copy(connection string, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error)
+	CopyAll(connection string, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error)
+	Move(connection string, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error)
+	CopyBetween(sourceConnection string, current *domain.ObjectParams, destinationConnection string, destination *domain.ObjectParams) (domain.StorageObject, error)
+	MoveBetween(sourceConnection string, current *domain.ObjectParams, destinationConnection string, destination *domain.ObjectParams) (domain.StorageObject, error)
+	MoveAll(connection string, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) (domain.MoveAllRecord, error)
+	ResumeMoveAll(connection string, id string) (domain.MoveAllRecord, error)
+	MoveAllRecords(connection string) ([]domain.MoveAllRecord, error)
+	Versions(connection string, storeName string, maxVersionsPerPage int32, token string, prefix string, key string) (domain.VersionPage, error)
+	RestoreVersion(connection string, params *domain.ObjectParams) (domain.StorageObject, error)
+	DeleteVersion(connection string, params *domain.ObjectParams) error
+	Versioning(connection string, storeName string) (domain.Versioning, error)
+	SetVersioning(connection string, storeName string, enabled bool) (domain.Versioning, error)
+	CreateMultipartUpload(connection string, params *domain.ObjectParams, metadata map[string]string) (domain.MultipartUpload, error)
+	UploadPart(connection string, params *domain.ObjectParams, uploadID string, partNumber int32, body io.Reader, size int64) (domain.UploadedPart, error)
+	ListParts(connection string, params *domain.ObjectParams, uploadID string) ([]domain.UploadedPart, error)
+	CompleteMultipartUpload(connection string, params *domain.ObjectParams, uploadID string, parts []domain.UploadedPart) (domain.StorageObject, error)
+	AbortMultipartUpload(connection string, params *domain.ObjectParams, uploadID string) error
+	PresignMultipartUpload(connection string, params *domain.ObjectParams, metadata map[string]string, size int64, partSize int64, exp uint) (domain.PresignedMultipartUpload, error)
+	OpenPresigned(connection string, query url.Values) (domain.ObjectContent, error)
+	UploadPresigned(connection string, query url.Values, file io.Reader) (domain.StorageObject, error)
 }
 
+// The part sizes of presigned multipart uploads. S3 accepts parts of 5 MB to 5 GB, but the last.
+const (
+	defaultPresignedPartSize = 16 << 20
+	minPresignedPartSize     = 5 << 20
+	maxPresignedPartSize     = 5 << 30
+)
+
 type smartService struct {
-	repos map[domain.StorageType]domain.StorageRepository
+	connections []domain.Connection
+	repos       map[string]domain.StorageRepository
+	uploaders   map[string]domain.MultipartUploader
+	journalDir  string
+	moves       sync.Map
 }
 
-func NewSmartService(repos map[domain.StorageType]domain.StorageRepository) SmartService {
+// NewSmartService stages the parts of multipart uploads below stagingDir for the connections
+// whose storage can't upload in parts itself, and records the progress of moves of whole paths
+// below journalDir. Both default to a directory in the system's temp dir.
+func NewSmartService(connections []domain.Connection, stagingDir string, journalDir string) SmartService {
+	if stagingDir == "" {
+		stagingDir = filepath.Join(os.TempDir(), "smarthub-multipart")
+	}
+	if journalDir == "" {
+		journalDir = filepath.Join(os.TempDir(), "smarthub-moves")
+	}
+	repos := make(map[string]domain.StorageRepository, len(connections))
+	uploaders := make(map[string]domain.MultipartUploader, len(connections))
+	for _, connection := range connections {
+		repos[connection.Name] = connection.Repository
+		if uploader, ok := connection.Repository.(domain.MultipartUploader); ok {
+			uploaders[connection.Name] = uploader
+		} else {
+			uploaders[connection.Name] = newStagedUploader(connection.Repository, filepath.Join(stagingDir, connection.Name))
+		}
+	}
 	return &smartService{
-		repos: repos,
+		connections: connections,
+		repos:       repos,
+		uploaders:   uploaders,
+		journalDir:  journalDir,
 	}
 }
 
-func (s *smartService) StoreNames(storeType domain.StorageType) ([]string, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Connections() []domain.Connection {
+	return s.connections
+}
+
+func (s *smartService) StoreNames(connection string) ([]string, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
 		return nil, err
 	}
 	return repository.StoreNames()
 }
 
-func (s *smartService) Objects(storeType domain.StorageType, storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Objects(connection string, storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
-		return nil, err
+		return domain.ObjectPage{}, err
 	}
-	return repository.Objects(storeName, maxObjectsPerPage, requestedPage, prefix)
+	return repository.Objects(storeName, maxObjectsPerPage, token, prefix)
 }
 
-func (s *smartService) ObjectsWithMetadata(storeType domain.StorageType, storeName string, maxObjectsPerPage int32, requestedPage int32, prefix string) ([]domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) ObjectsWithMetadata(connection string, storeName string, maxObjectsPerPage int32, token string, prefix string) (domain.ObjectPage, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
-		return nil, err
+		return domain.ObjectPage{}, err
 	}
-	return repository.ObjectsWithMetadata(storeName, maxObjectsPerPage, requestedPage, prefix)
+	return repository.ObjectsWithMetadata(storeName, maxObjectsPerPage, token, prefix)
 }
 
-func (s *smartService) GetObject(storeType domain.StorageType, params *domain.ObjectParams) (domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Browse(connection string, storeName string, maxObjectsPerPage int32, token string, prefix string, delimiter string, withSizes bool) (domain.ObjectPage, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
-		return domain.StorageObject{}, err
+		return domain.ObjectPage{}, err
 	}
-	return repository.GetObject(params)
+	return repository.Browse(storeName, maxObjectsPerPage, token, prefix, delimiter, withSizes)
 }
 
-func (s *smartService) UploadMultiPart(storeType domain.StorageType, params *domain.ObjectParams, metadata map[string]string, fileHeader *multipart.FileHeader) (domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+// GetObject also checks the conditions of params for backends which can't evaluate them themselves.
+func (s *smartService) GetObject(connection string, params *domain.ObjectParams) (domain.StorageObject, error) {
+	repository, err := s.getRepositoryOf(connection, params)
 	if err != nil {
 		return domain.StorageObject{}, err
 	}
-	if metadata == nil {
-		metadata = map[string]string{}
+	object, err := repository.GetObject(params)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if err = params.Conditions.Check(object); err != nil {
+		return domain.StorageObject{}, err
 	}
-	return repository.UploadMultiPart(params, metadata, fileHeader)
+	return object, nil
 }
 
-func (s *smartService) Upload(storeType domain.StorageType, params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Upload(connection string, params *domain.ObjectParams, metadata map[string]string, file io.Reader) (domain.StorageObject, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
 		return domain.StorageObject{}, err
 	}
@@ -91,8 +156,8 @@ func (s *smartService) Upload(storeType domain.StorageType, params *domain.Objec
 	return repository.Upload(params, metadata, file)
 }
 
-func (s *smartService) PresignUploadLink(storeType domain.StorageType, params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) PresignUploadLink(connection string, params *domain.ObjectParams, mimeType string, metadata map[string]string, exp uint) (string, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
 		return "", err
 	}
@@ -109,24 +174,61 @@ func (s *smartService) PresignUploadLink(storeType domain.StorageType, params *d
 	return repository.PresignUploadLink(params, mimeType, metadata, exp)
 }
 
-func (s *smartService) Download(storeType domain.StorageType, params *domain.ObjectParams) (domain.DownloadFileResponse, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) PresignPost(connection string, storeName string, policy domain.PostPolicy) (domain.PresignedPost, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
-		return domain.DownloadFileResponse{}, err
+		return domain.PresignedPost{}, err
 	}
-	return repository.Download(params)
+	presigner, ok := repository.(domain.PostPresigner)
+	if !ok {
+		return domain.PresignedPost{}, domain.ErrNotSupported
+	}
+	if (policy.Key == "") == (policy.KeyPrefix == "") {
+		return domain.PresignedPost{}, errors.New("either key or key prefix has to be given")
+	}
+	if policy.ContentType != "" && policy.ContentTypePrefix != "" {
+		return domain.PresignedPost{}, errors.New("content type and content type prefix exclude each other")
+	}
+	if policy.MinSize < 0 || policy.MaxSize < 0 || (policy.MaxSize > 0 && policy.MinSize > policy.MaxSize) {
+		return domain.PresignedPost{}, errors.New("size range is invalid")
+	}
+	if policy.MaxSize == 0 && policy.MinSize > 0 {
+		return domain.PresignedPost{}, errors.New("minimum size needs a maximum size")
+	}
+	if policy.Expiration == 0 {
+		policy.Expiration = 900000 //15 minutes
+	}
+	return presigner.PresignPost(storeName, policy)
+}
+
+// Download opens the object, or the selected bytes of it, for streaming. The caller has to close the body.
+// Like GetObject it checks the conditions of params, before anything is read from the body.
+func (s *smartService) Download(connection string, params *domain.ObjectParams, byteRange *domain.ByteRange) (domain.ObjectContent, error) {
+	repository, err := s.getRepositoryOf(connection, params)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	content, err := repository.Open(params, byteRange)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	if err = params.Conditions.Check(content.Object); err != nil {
+		content.Body.Close()
+		return domain.ObjectContent{}, err
+	}
+	return content, nil
 }
 
-func (s *smartService) PresignDownloadLink(storeType domain.StorageType, params *domain.ObjectParams) (string, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) PresignDownloadLink(connection string, params *domain.ObjectParams) (string, error) {
+	repository, err := s.getRepositoryOf(connection, params)
 	if err != nil {
 		return "", err
 	}
 	return repository.PresignDownloadLink(params)
 }
 
-func (s *smartService) PresignDownloadLinkWithExpTime(storeType domain.StorageType, params *domain.ObjectParams, exp uint) (string, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) PresignDownloadLinkWithExpTime(connection string, params *domain.ObjectParams, exp uint) (string, error) {
+	repository, err := s.getRepositoryOf(connection, params)
 	if err != nil {
 		return "", err
 	}
@@ -136,50 +238,292 @@ func (s *smartService) PresignDownloadLinkWithExpTime(storeType domain.StorageTy
 	return repository.PresignDownloadLinkWithExpTime(params, exp)
 }
 
-func (s *smartService) DeleteAll(storeType domain.StorageType, storeName string, pathPrefix string) (bool, error) {
-	repository, err := s.GetRepository(storeType)
+// DeleteAll deletes the objects below pathPrefix. An empty prefix deletes the whole store, which
+// the caller has to ask for with all.
+func (s *smartService) DeleteAll(connection string, storeName string, pathPrefix string, all bool, dryRun bool) (domain.DeleteAllReport, error) {
+	pathPrefix = strings.TrimLeft(pathPrefix, "/")
+	if pathPrefix == "" && !all {
+		return domain.DeleteAllReport{}, domain.ErrPrefixRequired
+	}
+	repository, err := s.GetRepository(connection)
 	if err != nil {
-		return false, err
+		return domain.DeleteAllReport{}, err
 	}
-	return repository.DeleteAll(storeName, pathPrefix)
+	return repository.DeleteAll(storeName, pathPrefix, dryRun)
 }
 
-func (s *smartService) Delete(storeType domain.StorageType, params *domain.ObjectParams) (bool, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Delete(connection string, params *domain.ObjectParams) (bool, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
 		return false, err
 	}
 	return repository.Delete(params)
 }
 
-func (s *smartService) Copy(storeType domain.StorageType, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Copy(connection string, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
 		return domain.StorageObject{}, err
 	}
 	return repository.Copy(current, destination)
 }
 
-func (s *smartService) CopyAll(storeType domain.StorageType, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string) ([]domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) CopyAll(connection string, sourceStoreName string, sourcePath string, targetStoreName string, targetPath string, skipIdentical bool) (domain.CopyAllReport, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
-		return []domain.StorageObject{}, err
+		return domain.CopyAllReport{}, err
 	}
-	return repository.CopyAll(sourceStoreName, sourcePath, targetStoreName, targetPath)
+	return repository.CopyAll(sourceStoreName, sourcePath, targetStoreName, targetPath, skipIdentical)
 }
 
-func (s *smartService) Move(storeType domain.StorageType, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
-	repository, err := s.GetRepository(storeType)
+func (s *smartService) Move(connection string, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	repository, err := s.GetRepository(connection)
 	if err != nil {
 		return domain.StorageObject{}, err
 	}
 	return repository.Move(current, destination)
 }
 
-func (s *smartService) GetRepository(storeType domain.StorageType) (domain.StorageRepository, error) {
-	repository := s.repos[storeType]
+// CopyBetween copies an object from one connection to another. Within a single connection, or
+// between connections the destination backend can copy from, the backend copies it server-side.
+// Otherwise the object is streamed from the source to the destination together with its content
+// type and metadata, which are dropped by backends that can't store them.
+func (s *smartService) CopyBetween(sourceConnection string, current *domain.ObjectParams, destinationConnection string, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	if sourceConnection == destinationConnection {
+		return s.Copy(sourceConnection, current, destination)
+	}
+	source, err := s.GetRepository(sourceConnection)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	target, err := s.GetRepository(destinationConnection)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if copier, ok := target.(domain.ServerSideCopier); ok {
+		object, err := copier.CopyFrom(source, current, destination)
+		if !errors.Is(err, domain.ErrNotSupported) {
+			return object, err
+		}
+	}
+	content, err := source.Open(current, nil)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	defer content.Body.Close()
+	typed := *destination
+	typed.ContentType = content.ContentType
+	return target.Upload(&typed, content.Object.Metadata, content.Body)
+}
+
+// MoveBetween is CopyBetween followed by deleting the source once the copy has the size of the
+// source. If the source can't be deleted, the copy is deleted again.
+func (s *smartService) MoveBetween(sourceConnection string, current *domain.ObjectParams, destinationConnection string, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	if sourceConnection == destinationConnection {
+		return s.Move(sourceConnection, current, destination)
+	}
+	source, err := s.GetObject(sourceConnection, current)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if _, err = s.CopyBetween(sourceConnection, current, destinationConnection, destination); err != nil {
+		return domain.StorageObject{}, err
+	}
+	copied, err := s.GetObject(destinationConnection, destination)
+	if err == nil && copied.Size != source.Size {
+		err = domain.ErrCopyMismatch
+	}
+	if err == nil {
+		if _, err = s.Delete(sourceConnection, current); err == nil {
+			return copied, nil
+		}
+		err = fmt.Errorf("couldn't delete the source %v:%v: %w", current.StoreName, current.Key, err)
+	}
+	if _, sourceErr := s.GetObject(sourceConnection, current); sourceErr == nil {
+		s.Delete(destinationConnection, destination)
+	}
+	return domain.StorageObject{}, err
+}
+
+func (s *smartService) CreateMultipartUpload(connection string, params *domain.ObjectParams, metadata map[string]string) (domain.MultipartUpload, error) {
+	uploader, err := s.GetMultipartUploader(connection)
+	if err != nil {
+		return domain.MultipartUpload{}, err
+	}
+	return uploader.CreateMultipartUpload(params, metadata)
+}
+
+func (s *smartService) UploadPart(connection string, params *domain.ObjectParams, uploadID string, partNumber int32, body io.Reader, size int64) (domain.UploadedPart, error) {
+	if partNumber < domain.MinPartNumber || partNumber > domain.MaxPartNumber {
+		return domain.UploadedPart{}, fmt.Errorf("part number must be between %v and %v", domain.MinPartNumber, domain.MaxPartNumber)
+	}
+	uploader, err := s.GetMultipartUploader(connection)
+	if err != nil {
+		return domain.UploadedPart{}, err
+	}
+	return uploader.UploadPart(params, uploadID, partNumber, body, size)
+}
+
+func (s *smartService) ListParts(connection string, params *domain.ObjectParams, uploadID string) ([]domain.UploadedPart, error) {
+	uploader, err := s.GetMultipartUploader(connection)
+	if err != nil {
+		return nil, err
+	}
+	return uploader.ListParts(params, uploadID)
+}
+
+// CompleteMultipartUpload assembles the object from all uploaded parts if parts is empty.
+func (s *smartService) CompleteMultipartUpload(connection string, params *domain.ObjectParams, uploadID string, parts []domain.UploadedPart) (domain.StorageObject, error) {
+	uploader, err := s.GetMultipartUploader(connection)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	if len(parts) == 0 {
+		if parts, err = uploader.ListParts(params, uploadID); err != nil {
+			return domain.StorageObject{}, err
+		}
+		if len(parts) == 0 {
+			return domain.StorageObject{}, errors.New("no part has been uploaded yet")
+		}
+	}
+	return uploader.CompleteMultipartUpload(params, uploadID, parts)
+}
+
+func (s *smartService) AbortMultipartUpload(connection string, params *domain.ObjectParams, uploadID string) error {
+	uploader, err := s.GetMultipartUploader(connection)
+	if err != nil {
+		return err
+	}
+	return uploader.AbortMultipartUpload(params, uploadID)
+}
+
+// PresignMultipartUpload starts a multipart upload of size bytes and presigns the upload of each of
+// its parts. It's completed and aborted like any other multipart upload. The part size defaults to
+// the smallest multiple of 16 MB which gets along with the parts allowed.
+func (s *smartService) PresignMultipartUpload(connection string, params *domain.ObjectParams, metadata map[string]string, size int64, partSize int64, exp uint) (domain.PresignedMultipartUpload, error) {
+	repository, err := s.GetRepository(connection)
+	if err != nil {
+		return domain.PresignedMultipartUpload{}, err
+	}
+	uploader, isUploader := repository.(domain.MultipartUploader)
+	presigner, isPresigner := repository.(domain.MultipartPresigner)
+	if !isUploader || !isPresigner {
+		return domain.PresignedMultipartUpload{}, domain.ErrNotSupported
+	}
+	if size <= 0 {
+		return domain.PresignedMultipartUpload{}, errors.New("size of the upload has to be given")
+	}
+	if partSize == 0 {
+		partSize = defaultPresignedPartSize
+		for (size+partSize-1)/partSize > domain.MaxPartNumber {
+			partSize += defaultPresignedPartSize
+		}
+	}
+	if partSize < minPresignedPartSize || partSize > maxPresignedPartSize {
+		return domain.PresignedMultipartUpload{}, fmt.Errorf("part size must be between %v and %v bytes", minPresignedPartSize, maxPresignedPartSize)
+	}
+	count := (size + partSize - 1) / partSize
+	if count > domain.MaxPartNumber {
+		return domain.PresignedMultipartUpload{}, fmt.Errorf("part size is too small for %v parts at most", domain.MaxPartNumber)
+	}
+	if exp == 0 {
+		exp = 900000 //15 minutes
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+
+	upload, err := uploader.CreateMultipartUpload(params, metadata)
+	if err != nil {
+		return domain.PresignedMultipartUpload{}, err
+	}
+	parts := make([]domain.PresignedPart, 0, count)
+	for partNumber := int32(1); int64(partNumber) <= count; partNumber++ {
+		url, err := presigner.PresignUploadPart(params, upload.UploadID, partNumber, exp)
+		if err != nil {
+			uploader.AbortMultipartUpload(params, upload.UploadID)
+			return domain.PresignedMultipartUpload{}, err
+		}
+		parts = append(parts, domain.PresignedPart{
+			PartNumber: partNumber,
+			Size:       min(partSize, size-int64(partNumber-1)*partSize),
+			URL:        url,
+		})
+	}
+	return domain.PresignedMultipartUpload{
+		MultipartUpload: upload,
+		PartSize:        partSize,
+		Parts:           parts,
+	}, nil
+}
+
+// GetMultipartUploader returns the repository of the connection if it uploads in parts natively,
+// otherwise the hub's emulation.
+func (s *smartService) GetMultipartUploader(connection string) (domain.MultipartUploader, error) {
+	uploader := s.uploaders[connection]
+	if uploader == nil {
+		return nil, errors.New(fmt.Sprintf("connection %v is not configured", connection))
+	}
+	return uploader, nil
+}
+
+func (s *smartService) OpenPresigned(connection string, query url.Values) (domain.ObjectContent, error) {
+	presigner, err := s.GetHubPresigner(connection)
+	if err != nil {
+		return domain.ObjectContent{}, err
+	}
+	return presigner.OpenPresigned(query)
+}
+
+func (s *smartService) UploadPresigned(connection string, query url.Values, file io.Reader) (domain.StorageObject, error) {
+	presigner, err := s.GetHubPresigner(connection)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	return presigner.UploadPresigned(query, file)
+}
+
+// GetHubPresigner returns the repository of the connection if its presigned links are served by the hub.
+func (s *smartService) GetHubPresigner(connection string) (domain.HubPresigner, error) {
+	repository, err := s.GetRepository(connection)
+	if err != nil {
+		return nil, err
+	}
+	presigner, ok := repository.(domain.HubPresigner)
+	if !ok {
+		return nil, domain.ErrNotSupported
+	}
+	return presigner, nil
+}
+
+// GetVersioner returns the repository of the connection if it keeps versions of its objects.
+func (s *smartService) GetVersioner(connection string) (domain.Versioner, error) {
+	repository, err := s.GetRepository(connection)
+	if err != nil {
+		return nil, err
+	}
+	versioner, ok := repository.(domain.Versioner)
+	if !ok {
+		return nil, domain.ErrNotSupported
+	}
+	return versioner, nil
+}
+
+// getRepositoryOf returns the repository of the connection, which has to keep versions if
+// params selects a version. Other repositories would ignore the version.
+func (s *smartService) getRepositoryOf(connection string, params *domain.ObjectParams) (domain.StorageRepository, error) {
+	if params.VersionID != "" {
+		if _, err := s.GetVersioner(connection); err != nil {
+			return nil, err
+		}
+	}
+	return s.GetRepository(connection)
+}
+
+func (s *smartService) GetRepository(connection string) (domain.StorageRepository, error) {
+	repository := s.repos[connection]
 	if repository == nil {
-		return nil, errors.New(fmt.Sprintf("%v is not supported", storeType.String()))
+		return nil, errors.New(fmt.Sprintf("connection %v is not configured", connection))
 	}
 	return repository, nil
 }
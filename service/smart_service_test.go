@@ -0,0 +1,407 @@
+package service
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestService serves the memory connection "mem" with the stores "files" and "other".
+func newTestService(t *testing.T) SmartService {
+	t.Helper()
+	return NewSmartService([]domain.Connection{{
+		Name:       "mem",
+		Type:       repository.MEMORY,
+		Repository: repository.NewMemoryRepository([]string{"files", "other"}, "http://hub.test/api/mem/presigned", []byte("test key")),
+	}}, t.TempDir(), t.TempDir())
+}
+
+func put(t *testing.T, service SmartService, storeName string, key string, content string) domain.StorageObject {
+	t.Helper()
+	object, err := service.Upload("mem", &domain.ObjectParams{StoreName: storeName, Key: key}, nil, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Upload(%v:%v) failed: %v", storeName, key, err)
+	}
+	return object
+}
+
+func TestGetObjectAndDownloadCheckConditions(t *testing.T) {
+	service := newTestService(t)
+	object := put(t, service, "files", "a.txt", "hello")
+	modified := time.UnixMilli(object.LastModified).Truncate(time.Second)
+	for _, test := range []struct {
+		name       string
+		conditions *domain.Conditions
+		want       error
+	}{
+		{"no conditions", nil, nil},
+		{"If-Match", &domain.Conditions{IfMatch: object.ETag}, nil},
+		{"If-Match other", &domain.Conditions{IfMatch: `"other"`}, domain.ErrPreconditionFailed},
+		{"If-Match any", &domain.Conditions{IfMatch: "*"}, nil},
+		{"If-None-Match", &domain.Conditions{IfNoneMatch: `"other", ` + object.ETag}, domain.ErrNotModified},
+		{"If-None-Match weak", &domain.Conditions{IfNoneMatch: "W/" + object.ETag}, domain.ErrNotModified},
+		{"If-None-Match other", &domain.Conditions{IfNoneMatch: `"other"`}, nil},
+		{"If-Modified-Since", &domain.Conditions{IfModifiedSince: modified}, domain.ErrNotModified},
+		{"If-Unmodified-Since", &domain.Conditions{IfUnmodifiedSince: modified.Add(-time.Second)}, domain.ErrPreconditionFailed},
+	} {
+		params := &domain.ObjectParams{StoreName: "files", Key: "a.txt", Conditions: test.conditions}
+		if _, err := service.GetObject("mem", params); !errors.Is(err, test.want) {
+			t.Errorf("%v: GetObject returned %v, want %v", test.name, err, test.want)
+		}
+		content, err := service.Download("mem", params, nil)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%v: Download returned %v, want %v", test.name, err, test.want)
+		}
+		if err == nil {
+			content.Body.Close()
+		}
+	}
+}
+
+func TestDownloadRange(t *testing.T) {
+	service := newTestService(t)
+	put(t, service, "files", "a.txt", "hello world")
+	content, err := service.Download("mem", &domain.ObjectParams{StoreName: "files", Key: "a.txt"}, &domain.ByteRange{Offset: 6, Length: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Body.Close()
+	data, _ := io.ReadAll(content.Body)
+	if string(data) != "world" || content.Object.Size != 11 {
+		t.Fatalf("read %q of %v bytes", data, content.Object.Size)
+	}
+}
+
+func TestObjectsPages(t *testing.T) {
+	service := newTestService(t)
+	for _, key := range []string{"c.txt", "a.txt", "b/1.txt", "b/2.txt", "d.txt"} {
+		put(t, service, "files", key, key)
+	}
+	var keys []string
+	token := ""
+	for pages := 1; ; pages++ {
+		page, err := service.Objects("mem", "files", 2, token, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Objects) > 2 {
+			t.Fatalf("page %v has %v objects", pages, len(page.Objects))
+		}
+		for _, object := range page.Objects {
+			keys = append(keys, object.Key)
+		}
+		if !page.IsTruncated {
+			if pages != 3 {
+				t.Fatalf("got %v pages, want 3", pages)
+			}
+			break
+		}
+		token = page.NextToken
+	}
+	if strings.Join(keys, ",") != "a.txt,b/1.txt,b/2.txt,c.txt,d.txt" {
+		t.Fatalf("got keys %v", keys)
+	}
+
+	page, err := service.Browse("mem", "files", 0, "", "", "/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Objects) != 3 || len(page.Folders) != 1 || page.Folders[0].Prefix != "b/" || page.Folders[0].Size != 14 {
+		t.Fatalf("unexpected browse page %+v", page)
+	}
+	if _, err = service.Objects("missing", "files", 2, "", ""); err == nil {
+		t.Fatal("listed a connection which isn't configured")
+	}
+}
+
+func TestPresignedLinks(t *testing.T) {
+	service := newTestService(t)
+	put(t, service, "files", "a.txt", "hello")
+	// without an expiration time links last 15 minutes
+	link, err := service.PresignDownloadLinkWithExpTime("mem", &domain.ObjectParams{StoreName: "files", Key: "a.txt"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	expires, _ := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if lifetime := time.Until(time.Unix(expires, 0)); lifetime < 14*time.Minute || lifetime > 16*time.Minute {
+		t.Fatalf("link expires in %v, want 15 minutes", lifetime)
+	}
+	content, err := service.OpenPresigned("mem", query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(content.Body)
+	content.Body.Close()
+	if string(data) != "hello" {
+		t.Fatalf("read %q", data)
+	}
+	query.Set("expires", strconv.FormatInt(expires+60, 10))
+	if _, err = service.OpenPresigned("mem", query); !errors.Is(err, domain.ErrPresignInvalid) {
+		t.Fatalf("got %v for a prolonged link, want ErrPresignInvalid", err)
+	}
+
+	link, err = service.PresignUploadLink("mem", &domain.ObjectParams{StoreName: "files", Key: "b.bin"}, " ", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ = url.Parse(link)
+	if got := parsed.Query().Get("contentType"); got != "application/octet-stream" {
+		t.Fatalf("link has content type %q, want application/octet-stream", got)
+	}
+	if _, err = service.UploadPresigned("mem", parsed.Query(), strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+	if object, err := service.GetObject("mem", &domain.ObjectParams{StoreName: "files", Key: "b.bin"}); err != nil || object.Size != 4 {
+		t.Fatalf("got %+v, %v after the presigned upload", object, err)
+	}
+}
+
+// serverSideCopies copies server side from the connections in sources and counts the copies.
+type serverSideCopies struct {
+	domain.StorageRepository
+	sources map[domain.StorageRepository]bool
+	copies  int
+}
+
+func (c *serverSideCopies) CopyFrom(source domain.StorageRepository, current *domain.ObjectParams, destination *domain.ObjectParams) (domain.StorageObject, error) {
+	if !c.sources[source] {
+		return domain.StorageObject{}, domain.ErrNotSupported
+	}
+	c.copies++
+	content, err := source.Open(current, nil)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	defer content.Body.Close()
+	copied := *destination
+	copied.ContentType = content.ContentType
+	return c.StorageRepository.Upload(&copied, content.Object.Metadata, content.Body)
+}
+
+func TestCopyBetween(t *testing.T) {
+	newMemory := func() domain.StorageRepository {
+		return repository.NewMemoryRepository([]string{"files"}, "http://hub.test/api/mem/presigned", []byte("test key"))
+	}
+	shared, other := newMemory(), newMemory()
+	target := &serverSideCopies{StorageRepository: newMemory(), sources: map[domain.StorageRepository]bool{shared: true}}
+	service := NewSmartService([]domain.Connection{
+		{Name: "shared", Type: repository.MEMORY, Repository: shared},
+		{Name: "other", Type: repository.MEMORY, Repository: other},
+		{Name: "target", Type: repository.MEMORY, Repository: target},
+	}, t.TempDir(), t.TempDir())
+	for _, connection := range []string{"shared", "other"} {
+		params := &domain.ObjectParams{StoreName: "files", Key: connection + ".dat", ContentType: "text/x-" + connection}
+		if _, err := service.Upload(connection, params, map[string]string{"origin": connection}, strings.NewReader(connection)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		connection string
+		copies     int
+	}{
+		{"shared", 1},
+		{"other", 1},
+	} {
+		key := test.connection + ".dat"
+		object, err := service.CopyBetween(test.connection, &domain.ObjectParams{StoreName: "files", Key: key},
+			"target", &domain.ObjectParams{StoreName: "files", Key: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if object.Size != int64(len(test.connection)) || target.copies != test.copies {
+			t.Fatalf("copy from %v returned %+v after %v server side copies", test.connection, object, target.copies)
+		}
+		content, err := service.Download("target", &domain.ObjectParams{StoreName: "files", Key: key}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		content.Body.Close()
+		if content.ContentType != "text/x-"+test.connection || content.Object.Metadata["origin"] != test.connection {
+			t.Fatalf("copy from %v has content type %q and metadata %v", test.connection, content.ContentType, content.Object.Metadata)
+		}
+	}
+}
+
+func TestDeleteAllRequiresPrefix(t *testing.T) {
+	service := newTestService(t)
+	for _, key := range []string{"logs/a.txt", "logs/b.txt", "keep.txt"} {
+		put(t, service, "files", key, key)
+	}
+	for _, prefix := range []string{"", "/"} {
+		if _, err := service.DeleteAll("mem", "files", prefix, false, false); !errors.Is(err, domain.ErrPrefixRequired) {
+			t.Fatalf("prefix %q: got %v, want ErrPrefixRequired", prefix, err)
+		}
+	}
+	report, err := service.DeleteAll("mem", "files", "/logs/", false, false)
+	if err != nil || report.Deleted != 2 || report.Prefix != "logs/" {
+		t.Fatalf("got %+v, %v", report, err)
+	}
+	if report, err = service.DeleteAll("mem", "files", "", true, false); err != nil || report.Deleted != 1 {
+		t.Fatalf("deleting the whole store returned %+v, %v", report, err)
+	}
+}
+
+// presignedParts uploads in parts with the hub's staging and presigns links to the parts, failing
+// for the part number failPart. It counts the uploads created and aborted.
+type presignedParts struct {
+	domain.StorageRepository
+	domain.MultipartUploader
+	failPart int32
+	created  int
+	aborted  int
+}
+
+func newPresignedPartsService(t *testing.T, failPart int32) (SmartService, *presignedParts) {
+	t.Helper()
+	memory := repository.NewMemoryRepository([]string{"files"}, "http://hub.test/api/mem/presigned", []byte("test key"))
+	parts := &presignedParts{StorageRepository: memory, MultipartUploader: newStagedUploader(memory, t.TempDir()), failPart: failPart}
+	return NewSmartService([]domain.Connection{{Name: "parts", Type: repository.MEMORY, Repository: parts}}, t.TempDir(), t.TempDir()), parts
+}
+
+func (p *presignedParts) CreateMultipartUpload(params *domain.ObjectParams, metadata map[string]string) (domain.MultipartUpload, error) {
+	p.created++
+	return p.MultipartUploader.CreateMultipartUpload(params, metadata)
+}
+
+func (p *presignedParts) AbortMultipartUpload(params *domain.ObjectParams, uploadID string) error {
+	p.aborted++
+	return p.MultipartUploader.AbortMultipartUpload(params, uploadID)
+}
+
+func (p *presignedParts) PresignUploadPart(params *domain.ObjectParams, uploadID string, partNumber int32, exp uint) (string, error) {
+	if partNumber == p.failPart {
+		return "", errors.New("presigning failed")
+	}
+	return "http://storage.test/" + params.Key + "?uploadId=" + uploadID + "&partNumber=" + strconv.Itoa(int(partNumber)), nil
+}
+
+func TestPresignMultipartUploadPartSizes(t *testing.T) {
+	const mib = 1 << 20
+	for _, test := range []struct {
+		name     string
+		size     int64
+		partSize int64
+		want     int64
+		count    int
+		last     int64
+	}{
+		{"single byte", 1, 0, 16 * mib, 1, 1},
+		{"one default part", 16 * mib, 0, 16 * mib, 1, 16 * mib},
+		{"short last part", 16*mib + 1, 0, 16 * mib, 2, 1},
+		{"most default parts", domain.MaxPartNumber * 16 * mib, 0, 16 * mib, domain.MaxPartNumber, 16 * mib},
+		{"grown default parts", domain.MaxPartNumber*16*mib + 1, 0, 32 * mib, 5001, 1},
+		{"minimum part size", 12 * mib, 5 * mib, 5 * mib, 3, 2 * mib},
+		{"most minimum parts", domain.MaxPartNumber * 5 * mib, 5 * mib, 5 * mib, domain.MaxPartNumber, 5 * mib},
+		{"maximum part size", 6 << 30, 5 << 30, 5 << 30, 2, 1 << 30},
+		{"zero size", 0, 0, 0, 0, 0},
+		{"negative size", -1, 5 * mib, 0, 0, 0},
+		{"part size below the minimum", 12 * mib, 5*mib - 1, 0, 0, 0},
+		{"part size above the maximum", 12 * mib, 5<<30 + 1, 0, 0, 0},
+		{"negative part size", 12 * mib, -1, 0, 0, 0},
+		{"too many parts", domain.MaxPartNumber*5*mib + 1, 5 * mib, 0, 0, 0},
+	} {
+		service, parts := newPresignedPartsService(t, 0)
+		upload, err := service.PresignMultipartUpload("parts", &domain.ObjectParams{StoreName: "files", Key: "a.bin"}, nil, test.size, test.partSize, 0)
+		if test.count == 0 {
+			if err == nil || parts.created != 0 {
+				t.Errorf("%v: got %v after creating %v uploads, want an error before any", test.name, err, parts.created)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if upload.PartSize != test.want || len(upload.Parts) != test.count {
+			t.Errorf("%v: got %v parts of %v bytes, want %v of %v", test.name, len(upload.Parts), upload.PartSize, test.count, test.want)
+			continue
+		}
+		var total int64
+		for i, part := range upload.Parts {
+			if part.PartNumber != int32(i+1) || !strings.HasSuffix(part.URL, "&partNumber="+strconv.Itoa(i+1)) {
+				t.Errorf("%v: part %v is %+v", test.name, i+1, part)
+				break
+			}
+			if i < len(upload.Parts)-1 && part.Size != test.want {
+				t.Errorf("%v: part %v has %v bytes, want %v", test.name, i+1, part.Size, test.want)
+				break
+			}
+			total += part.Size
+		}
+		if last := upload.Parts[len(upload.Parts)-1].Size; last != test.last || total != test.size {
+			t.Errorf("%v: the last part has %v bytes and all parts %v, want %v and %v", test.name, last, total, test.last, test.size)
+		}
+	}
+}
+
+func TestPresignMultipartUploadCompletesAndAborts(t *testing.T) {
+	service, parts := newPresignedPartsService(t, 0)
+	params := &domain.ObjectParams{StoreName: "files", Key: "a.bin"}
+	content := strings.Repeat("a", 5<<20) + "tail"
+	upload, err := service.PresignMultipartUpload("parts", params, map[string]string{"origin": "presigned"}, int64(len(content)), 5<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded []domain.UploadedPart
+	for _, part := range upload.Parts {
+		offset := int64(part.PartNumber-1) * upload.PartSize
+		body := content[offset : offset+part.Size]
+		uploadedPart, err := service.UploadPart("parts", params, upload.UploadID, part.PartNumber, strings.NewReader(body), part.Size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		uploaded = append(uploaded, uploadedPart)
+	}
+	object, err := service.CompleteMultipartUpload("parts", params, upload.UploadID, uploaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Size != int64(len(content)) {
+		t.Fatalf("completed %+v", object)
+	}
+	download, err := service.Download("parts", params, &domain.ByteRange{Offset: 5 << 20, Length: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(download.Body)
+	download.Body.Close()
+	if string(data) != "tail" || download.Object.Metadata["origin"] != "presigned" {
+		t.Fatalf("assembled %q with metadata %v", data, download.Object.Metadata)
+	}
+
+	upload, err = service.PresignMultipartUpload("parts", params, nil, 12<<20, 5<<20, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = service.AbortMultipartUpload("parts", params, upload.UploadID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = service.ListParts("parts", params, upload.UploadID); !errors.Is(err, domain.ErrUploadNotFound) {
+		t.Fatalf("got %v listing an aborted upload, want ErrUploadNotFound", err)
+	}
+
+	// a part which can't be presigned aborts the upload
+	service, parts = newPresignedPartsService(t, 2)
+	if _, err = service.PresignMultipartUpload("parts", params, nil, 12<<20, 5<<20, 0); err == nil {
+		t.Fatal("presigned an upload without all of its parts")
+	}
+	if parts.created != 1 || parts.aborted != 1 {
+		t.Fatalf("created %v and aborted %v uploads, want one each", parts.created, parts.aborted)
+	}
+
+	if _, err = newTestService(t).PresignMultipartUpload("mem", params, nil, 12<<20, 5<<20, 0); !errors.Is(err, domain.ErrNotSupported) {
+		t.Fatalf("got %v presigning parts of the memory connection, want ErrNotSupported", err)
+	}
+}
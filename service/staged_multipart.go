@@ -0,0 +1,268 @@
+package service
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const stagedManifestName = "manifest.json"
+
+var (
+	// the ids the hub hands out for uploads and moves
+	hexID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	// a staged part is named after its number and the MD5 of its content, e.g. 00001-<md5>.part
+	stagedPartName = regexp.MustCompile(`^(\d{5})-([0-9a-f]{32})\.part$`)
+)
+
+// stagedUploader emulates multipart uploads for repositories without native support. The parts
+// are staged as files below dir and streamed into a single upload of the repository on completion.
+type stagedUploader struct {
+	repository domain.StorageRepository
+	dir        string
+}
+
+// stagedManifest remembers what a staged upload is going to create.
+type stagedManifest struct {
+	StoreName string            `json:"store_name"`
+	Key       string            `json:"key"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+}
+
+func newStagedUploader(repository domain.StorageRepository, dir string) domain.MultipartUploader {
+	return &stagedUploader{
+		repository: repository,
+		dir:        dir,
+	}
+}
+
+func (u *stagedUploader) CreateMultipartUpload(params *domain.ObjectParams, metadata map[string]string) (domain.MultipartUpload, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return domain.MultipartUpload{}, err
+	}
+	uploadID := hex.EncodeToString(id)
+	uploadDir := filepath.Join(u.dir, uploadID)
+	if err := os.MkdirAll(uploadDir, 0700); err != nil {
+		return domain.MultipartUpload{}, err
+	}
+	manifest, err := json.Marshal(stagedManifest{
+		StoreName: params.StoreName,
+		Key:       params.Key,
+		Metadata:  metadata,
+	})
+	if err != nil {
+		return domain.MultipartUpload{}, err
+	}
+	if err = os.WriteFile(filepath.Join(uploadDir, stagedManifestName), manifest, 0600); err != nil {
+		os.RemoveAll(uploadDir)
+		return domain.MultipartUpload{}, err
+	}
+	return domain.MultipartUpload{
+		UploadID:  uploadID,
+		StoreName: params.StoreName,
+		Key:       params.Key,
+	}, nil
+}
+
+// UploadPart stages the part under a temporary name first, so a part broken off by the client
+// never replaces one uploaded before.
+func (u *stagedUploader) UploadPart(params *domain.ObjectParams, uploadID string, partNumber int32, body io.Reader, size int64) (domain.UploadedPart, error) {
+	uploadDir, _, err := u.open(params, uploadID)
+	if err != nil {
+		return domain.UploadedPart{}, err
+	}
+	file, err := os.CreateTemp(uploadDir, "upload-*.tmp")
+	if err != nil {
+		return domain.UploadedPart{}, err
+	}
+	hash := md5.New()
+	written, err := io.Copy(io.MultiWriter(file, hash), body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("part %v has %v bytes instead of %v", partNumber, written, size)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return domain.UploadedPart{}, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	previous, _ := u.parts(uploadDir)
+	if err = os.Rename(file.Name(), filepath.Join(uploadDir, fmt.Sprintf("%05d-%v.part", partNumber, sum))); err != nil {
+		os.Remove(file.Name())
+		return domain.UploadedPart{}, err
+	}
+	for _, part := range previous {
+		if part.PartNumber == partNumber && part.ETag != `"`+sum+`"` {
+			os.Remove(u.partPath(uploadDir, part))
+		}
+	}
+	return domain.UploadedPart{
+		PartNumber: partNumber,
+		ETag:       `"` + sum + `"`,
+		Size:       written,
+	}, nil
+}
+
+func (u *stagedUploader) ListParts(params *domain.ObjectParams, uploadID string) ([]domain.UploadedPart, error) {
+	uploadDir, _, err := u.open(params, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return u.parts(uploadDir)
+}
+
+// CompleteMultipartUpload streams the staged parts one after another into the repository.
+// As with S3, the parts have to be listed in ascending order.
+func (u *stagedUploader) CompleteMultipartUpload(params *domain.ObjectParams, uploadID string, parts []domain.UploadedPart) (domain.StorageObject, error) {
+	uploadDir, manifest, err := u.open(params, uploadID)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	staged, err := u.parts(uploadDir)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	stagedByNumber := map[int32]domain.UploadedPart{}
+	for _, part := range staged {
+		stagedByNumber[part.PartNumber] = part
+	}
+	paths := make([]string, 0, len(parts))
+	for i, part := range parts {
+		if i > 0 && part.PartNumber <= parts[i-1].PartNumber {
+			return domain.StorageObject{}, errors.New("parts have to be listed in ascending order")
+		}
+		stagedPart, ok := stagedByNumber[part.PartNumber]
+		if !ok || strings.Trim(part.ETag, `"`) != strings.Trim(stagedPart.ETag, `"`) {
+			return domain.StorageObject{}, fmt.Errorf("part %v has not been uploaded with etag %v", part.PartNumber, part.ETag)
+		}
+		paths = append(paths, u.partPath(uploadDir, stagedPart))
+	}
+	body := &partsReader{paths: paths}
+	defer body.Close()
+	object, err := u.repository.Upload(&domain.ObjectParams{StoreName: manifest.StoreName, Key: manifest.Key}, manifest.Metadata, body)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	os.RemoveAll(uploadDir)
+	return object, nil
+}
+
+func (u *stagedUploader) AbortMultipartUpload(params *domain.ObjectParams, uploadID string) error {
+	uploadDir, _, err := u.open(params, uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(uploadDir)
+}
+
+// open returns the directory and manifest of an upload, which has to create params.
+func (u *stagedUploader) open(params *domain.ObjectParams, uploadID string) (string, stagedManifest, error) {
+	var manifest stagedManifest
+	if !hexID.MatchString(uploadID) {
+		return "", manifest, domain.ErrUploadNotFound
+	}
+	uploadDir := filepath.Join(u.dir, uploadID)
+	content, err := os.ReadFile(filepath.Join(uploadDir, stagedManifestName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", manifest, domain.ErrUploadNotFound
+	}
+	if err != nil {
+		return "", manifest, err
+	}
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return "", manifest, err
+	}
+	if manifest.StoreName != params.StoreName || manifest.Key != params.Key {
+		return "", manifest, domain.ErrUploadNotFound
+	}
+	return uploadDir, manifest, nil
+}
+
+// parts lists the staged parts of an upload by part number.
+func (u *stagedUploader) parts(uploadDir string) ([]domain.UploadedPart, error) {
+	entries, err := os.ReadDir(uploadDir)
+	if err != nil {
+		return nil, err
+	}
+	parts := []domain.UploadedPart{}
+	for _, entry := range entries {
+		match := stagedPartName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		partNumber, _ := strconv.ParseInt(match[1], 10, 32)
+		parts = append(parts, domain.UploadedPart{
+			PartNumber:   int32(partNumber),
+			ETag:         `"` + match[2] + `"`,
+			Size:         info.Size(),
+			LastModified: info.ModTime().UnixMilli(),
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+func (u *stagedUploader) partPath(uploadDir string, part domain.UploadedPart) string {
+	return filepath.Join(uploadDir, fmt.Sprintf("%05d-%v.part", part.PartNumber, strings.Trim(part.ETag, `"`)))
+}
+
+// partsReader reads the files at paths one after another, keeping only one of them open.
+type partsReader struct {
+	paths   []string
+	current *os.File
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(r.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			r.current = file
+			r.paths = r.paths[1:]
+		}
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
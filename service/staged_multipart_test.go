@@ -0,0 +1,126 @@
+package service
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newTestStagedUploader(t *testing.T) (domain.MultipartUploader, domain.StorageRepository) {
+	t.Helper()
+	memory := repository.NewMemoryRepository([]string{"files"}, "http://hub.test/api/mem/presigned", []byte("test key"))
+	return newStagedUploader(memory, t.TempDir()), memory
+}
+
+func uploadPart(t *testing.T, uploader domain.MultipartUploader, params *domain.ObjectParams, uploadID string, partNumber int32, content string) domain.UploadedPart {
+	t.Helper()
+	part, err := uploader.UploadPart(params, uploadID, partNumber, strings.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatalf("UploadPart(%v) failed: %v", partNumber, err)
+	}
+	return part
+}
+
+func TestStagedMultipartAssemblesParts(t *testing.T) {
+	uploader, memory := newTestStagedUploader(t)
+	params := &domain.ObjectParams{StoreName: "files", Key: "a.txt"}
+	upload, err := uploader.CreateMultipartUpload(params, map[string]string{"origin": "staged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the parts arrive out of order, part 2 is uploaded again and part 4 is left out
+	uploadPart(t, uploader, params, upload.UploadID, 3, "three ")
+	uploadPart(t, uploader, params, upload.UploadID, 1, "one ")
+	uploadPart(t, uploader, params, upload.UploadID, 2, "draft ")
+	uploadPart(t, uploader, params, upload.UploadID, 2, "two ")
+	uploadPart(t, uploader, params, upload.UploadID, 4, "four")
+	if _, err = uploader.UploadPart(params, upload.UploadID, 2, strings.NewReader("broken"), 10); err == nil {
+		t.Fatal("staged a part shorter than announced")
+	}
+	parts, err := uploader.ListParts(params, upload.UploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 4 || parts[1].Size != 4 {
+		t.Fatalf("listed parts %+v", parts)
+	}
+	for i, part := range parts {
+		if part.PartNumber != int32(i+1) {
+			t.Fatalf("parts aren't listed by number: %+v", parts)
+		}
+	}
+
+	// unquoted entity tags are accepted as well
+	parts[1].ETag = strings.Trim(parts[1].ETag, `"`)
+	object, err := uploader.CompleteMultipartUpload(params, upload.UploadID, parts[:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Size != 14 {
+		t.Fatalf("completed %+v", object)
+	}
+	content, err := memory.Open(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer content.Body.Close()
+	data, _ := io.ReadAll(content.Body)
+	if string(data) != "one two three " || content.Object.Metadata["origin"] != "staged" {
+		t.Fatalf("assembled %q with metadata %v", data, content.Object.Metadata)
+	}
+	if _, err = uploader.ListParts(params, upload.UploadID); !errors.Is(err, domain.ErrUploadNotFound) {
+		t.Fatalf("got %v listing a completed upload, want ErrUploadNotFound", err)
+	}
+}
+
+func TestStagedMultipartRejectsParts(t *testing.T) {
+	uploader, memory := newTestStagedUploader(t)
+	params := &domain.ObjectParams{StoreName: "files", Key: "a.txt"}
+	upload, err := uploader.CreateMultipartUpload(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	one := uploadPart(t, uploader, params, upload.UploadID, 1, "one ")
+	two := uploadPart(t, uploader, params, upload.UploadID, 2, "two")
+	for _, test := range []struct {
+		name  string
+		parts []domain.UploadedPart
+	}{
+		{"descending", []domain.UploadedPart{two, one}},
+		{"duplicate", []domain.UploadedPart{one, one}},
+		{"missing part", []domain.UploadedPart{one, {PartNumber: 3, ETag: two.ETag}}},
+		{"other etag", []domain.UploadedPart{one, {PartNumber: 2, ETag: one.ETag}}},
+	} {
+		if _, err = uploader.CompleteMultipartUpload(params, upload.UploadID, test.parts); err == nil {
+			t.Errorf("%v: completed the upload", test.name)
+		}
+	}
+	if _, err = memory.GetObject(params); err == nil {
+		t.Fatal("a rejected completion created the object")
+	}
+
+	for _, test := range []struct {
+		name     string
+		params   *domain.ObjectParams
+		uploadID string
+	}{
+		{"other key", &domain.ObjectParams{StoreName: "files", Key: "b.txt"}, upload.UploadID},
+		{"other store", &domain.ObjectParams{StoreName: "other", Key: "a.txt"}, upload.UploadID},
+		{"unknown id", params, strings.Repeat("0", 32)},
+		{"invalid id", params, "../" + upload.UploadID},
+	} {
+		if _, err = uploader.ListParts(test.params, test.uploadID); !errors.Is(err, domain.ErrUploadNotFound) {
+			t.Errorf("%v: got %v, want ErrUploadNotFound", test.name, err)
+		}
+	}
+
+	if err = uploader.AbortMultipartUpload(params, upload.UploadID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = uploader.CompleteMultipartUpload(params, upload.UploadID, []domain.UploadedPart{one, two}); !errors.Is(err, domain.ErrUploadNotFound) {
+		t.Fatalf("got %v completing an aborted upload, want ErrUploadNotFound", err)
+	}
+}
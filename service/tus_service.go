@@ -0,0 +1,377 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/nevcodia/smarthub/domain"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTusExpiration = 24 * time.Hour
+	tusInfoName          = "info.json"
+	tusDataName          = "data"
+	tusSweepInterval     = time.Hour
+)
+
+// TusService keeps the state of tus uploads. The chunks of an upload are appended to a file
+// below the service's directory until the upload is complete, then the file is streamed into
+// the storage through SmartService.Upload.
+type TusService interface {
+	CreateUpload(connection string, length int64, metadata map[string]string) (domain.TusUpload, error)
+	GetUpload(connection string, id string) (domain.TusUpload, error)
+	WriteUpload(connection string, id string, offset int64, body io.Reader, checksum *domain.TusChecksum) (domain.TusUpload, error)
+	TerminateUpload(connection string, id string) error
+	// Close stops sweeping expired uploads. The uploads themselves are kept.
+	Close() error
+}
+
+type tusService struct {
+	smartService SmartService
+	dir          string
+	expiration   time.Duration
+	locksMutex   sync.Mutex
+	locks        map[string]*uploadLock
+	// stop ends the sweep, which closes swept once it has returned
+	stop     chan struct{}
+	stopOnce sync.Once
+	swept    chan struct{}
+}
+
+// uploadLock serializes the requests for an upload. It's removed from the locks once nobody holds
+// or waits for it any more.
+type uploadLock struct {
+	sync.Mutex
+	// holders counts the requests holding or waiting for the lock, guarded by locksMutex.
+	holders int
+}
+
+// NewTusService stores uploads below dir, which defaults to a directory in the system's temp dir.
+// Uploads expire after they haven't received data for the given time, 24 hours by default, and
+// are swept every hour or after the expiration time if that's shorter.
+func NewTusService(smartService SmartService, dir string, expiration time.Duration) TusService {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "smarthub-tus")
+	}
+	if expiration <= 0 {
+		expiration = defaultTusExpiration
+	}
+	s := &tusService{
+		smartService: smartService,
+		dir:          dir,
+		expiration:   expiration,
+		locks:        map[string]*uploadLock{},
+		stop:         make(chan struct{}),
+		swept:        make(chan struct{}),
+	}
+	go s.sweep(min(expiration, tusSweepInterval))
+	return s
+}
+
+// CreateUpload takes the target of the upload from the storeName and key metadata. Clients which
+// can't set a key, e.g. Uppy, upload to their filename instead. The metadata but storeName and key
+// becomes the metadata of the object.
+func (s *tusService) CreateUpload(connection string, length int64, metadata map[string]string) (domain.TusUpload, error) {
+	if !s.configured(connection) {
+		return domain.TusUpload{}, errors.New(fmt.Sprintf("connection %v is not configured", connection))
+	}
+	if length < 0 {
+		return domain.TusUpload{}, errors.New("upload length must not be negative")
+	}
+	key := metadata["key"]
+	if key == "" {
+		key = metadata["filename"]
+	}
+	if metadata["storeName"] == "" || key == "" {
+		return domain.TusUpload{}, errors.New("upload metadata has to name the storeName and key")
+	}
+	s.removeExpired(connection)
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return domain.TusUpload{}, err
+	}
+	upload := domain.TusUpload{
+		ID:        hex.EncodeToString(id),
+		StoreName: metadata["storeName"],
+		Key:       key,
+		Length:    length,
+		Metadata:  metadata,
+		Expires:   time.Now().Add(s.expiration).Truncate(time.Second),
+	}
+	uploadDir := s.uploadDir(connection, upload.ID)
+	if err := os.MkdirAll(uploadDir, 0700); err != nil {
+		return domain.TusUpload{}, err
+	}
+	if err := os.WriteFile(filepath.Join(uploadDir, tusDataName), nil, 0600); err != nil {
+		os.RemoveAll(uploadDir)
+		return domain.TusUpload{}, err
+	}
+	if err := s.save(connection, upload); err != nil {
+		os.RemoveAll(uploadDir)
+		return domain.TusUpload{}, err
+	}
+	if length == 0 {
+		return s.commit(connection, upload)
+	}
+	return upload, nil
+}
+
+func (s *tusService) GetUpload(connection string, id string) (domain.TusUpload, error) {
+	defer s.lock(connection, id)()
+	return s.read(connection, id)
+}
+
+// WriteUpload appends body to the upload, which has to have received offset bytes so far. A chunk
+// broken off is kept as far as it has arrived, unless it comes with a checksum. The upload is
+// committed once complete, a commit which failed is repeated by writing an empty chunk.
+func (s *tusService) WriteUpload(connection string, id string, offset int64, body io.Reader, checksum *domain.TusChecksum) (domain.TusUpload, error) {
+	defer s.lock(connection, id)()
+	upload, err := s.read(connection, id)
+	if err != nil {
+		return domain.TusUpload{}, err
+	}
+	if offset != upload.Offset {
+		return domain.TusUpload{}, domain.ErrOffsetMismatch
+	}
+	if upload.Object != nil {
+		// the upload is complete already
+		if n, _ := body.Read(make([]byte, 1)); n > 0 {
+			return domain.TusUpload{}, domain.ErrUploadTooLarge
+		}
+		return upload, nil
+	}
+	var newHash func() []byte
+	writers := []io.Writer{}
+	if checksum != nil {
+		algorithm, ok := domain.TusChecksumAlgorithms[checksum.Algorithm]
+		if !ok {
+			return domain.TusUpload{}, fmt.Errorf("checksum algorithm %v is not supported", checksum.Algorithm)
+		}
+		hash := algorithm()
+		writers = append(writers, hash)
+		newHash = func() []byte { return hash.Sum(nil) }
+	}
+
+	path := filepath.Join(s.uploadDir(connection, id), tusDataName)
+	file, err := os.OpenFile(path, os.O_WRONLY, 0600)
+	if err != nil {
+		return domain.TusUpload{}, err
+	}
+	// bytes written past the recorded offset by a request which broke off aren't part of the upload
+	if err = file.Truncate(upload.Offset); err == nil {
+		_, err = file.Seek(upload.Offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return domain.TusUpload{}, err
+	}
+	writers = append(writers, file)
+	written, err := io.Copy(io.MultiWriter(writers...), io.LimitReader(body, upload.Length-upload.Offset))
+	if err == nil {
+		if n, _ := body.Read(make([]byte, 1)); n > 0 {
+			err = domain.ErrUploadTooLarge
+		}
+	}
+	if err == nil && checksum != nil && !bytes.Equal(newHash(), checksum.Sum) {
+		err = domain.ErrChecksumMismatch
+	}
+	if err != nil && (checksum != nil || errors.Is(err, domain.ErrUploadTooLarge)) {
+		// the chunk is discarded as a whole
+		file.Truncate(upload.Offset)
+		written = 0
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if written > 0 {
+		upload.Offset += written
+		upload.Expires = time.Now().Add(s.expiration).Truncate(time.Second)
+		if saveErr := s.save(connection, upload); err == nil {
+			err = saveErr
+		}
+	}
+	if err != nil {
+		return domain.TusUpload{}, err
+	}
+	if upload.Offset == upload.Length {
+		return s.commit(connection, upload)
+	}
+	return upload, nil
+}
+
+func (s *tusService) TerminateUpload(connection string, id string) error {
+	defer s.lock(connection, id)()
+	if _, err := s.read(connection, id); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.uploadDir(connection, id))
+}
+
+// commit uploads the data of a complete upload to the storage. The upload itself is kept until it
+// expires, so clients asking for its offset learn that it's complete.
+func (s *tusService) commit(connection string, upload domain.TusUpload) (domain.TusUpload, error) {
+	path := filepath.Join(s.uploadDir(connection, upload.ID), tusDataName)
+	file, err := os.Open(path)
+	if err != nil {
+		return domain.TusUpload{}, err
+	}
+	defer file.Close()
+	metadata := make(map[string]string, len(upload.Metadata))
+	for key, value := range upload.Metadata {
+		if key != "storeName" && key != "key" {
+			metadata[key] = value
+		}
+	}
+	params := &domain.ObjectParams{
+		StoreName: upload.StoreName,
+		Key:       upload.Key,
+	}
+	object, err := s.smartService.Upload(connection, params, metadata, file)
+	if err != nil {
+		return domain.TusUpload{}, err
+	}
+	upload.Object = &object
+	if err = s.save(connection, upload); err != nil {
+		return domain.TusUpload{}, err
+	}
+	os.Remove(path)
+	return upload, nil
+}
+
+// read returns the upload with the given id, removing it if it has expired.
+func (s *tusService) read(connection string, id string) (domain.TusUpload, error) {
+	var upload domain.TusUpload
+	if !hexID.MatchString(id) {
+		return upload, domain.ErrUploadNotFound
+	}
+	content, err := os.ReadFile(filepath.Join(s.uploadDir(connection, id), tusInfoName))
+	if errors.Is(err, os.ErrNotExist) {
+		return upload, domain.ErrUploadNotFound
+	}
+	if err != nil {
+		return upload, err
+	}
+	if err = json.Unmarshal(content, &upload); err != nil {
+		return upload, err
+	}
+	if time.Now().After(upload.Expires) {
+		os.RemoveAll(s.uploadDir(connection, id))
+		return domain.TusUpload{}, domain.ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// save writes the state of the upload through a temporary file, so it's never read half-written.
+func (s *tusService) save(connection string, upload domain.TusUpload) error {
+	content, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.uploadDir(connection, upload.ID), tusInfoName)
+	if err = os.WriteFile(path+".tmp", content, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Close waits for a sweep which is under way to finish.
+func (s *tusService) Close() error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	<-s.swept
+	return nil
+}
+
+// sweep removes the expired uploads of all connections every interval until the service is closed.
+func (s *tusService) sweep(interval time.Duration) {
+	defer close(s.swept)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			for _, connection := range s.smartService.Connections() {
+				s.removeExpired(connection.Name)
+			}
+		}
+	}
+}
+
+// removeExpired cleans up the uploads of the connection which have expired. Uploads busy with
+// a request haven't.
+func (s *tusService) removeExpired(connection string) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, connection))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || !hexID.MatchString(entry.Name()) {
+			continue
+		}
+		if unlock, ok := s.tryLock(connection, entry.Name()); ok {
+			s.read(connection, entry.Name())
+			unlock()
+		}
+	}
+}
+
+// lock serializes the requests for an upload and returns the function unlocking it.
+func (s *tusService) lock(connection string, id string) func() {
+	key := connection + "/" + id
+	s.locksMutex.Lock()
+	lock, ok := s.locks[key]
+	if !ok {
+		lock = &uploadLock{}
+		s.locks[key] = lock
+	}
+	lock.holders++
+	s.locksMutex.Unlock()
+	lock.Lock()
+	return func() { s.unlock(key, lock) }
+}
+
+// tryLock locks the upload unless a request holds or waits for it.
+func (s *tusService) tryLock(connection string, id string) (func(), bool) {
+	key := connection + "/" + id
+	s.locksMutex.Lock()
+	defer s.locksMutex.Unlock()
+	if _, ok := s.locks[key]; ok {
+		return nil, false
+	}
+	lock := &uploadLock{holders: 1}
+	lock.Lock()
+	s.locks[key] = lock
+	return func() { s.unlock(key, lock) }, true
+}
+
+func (s *tusService) unlock(key string, lock *uploadLock) {
+	lock.Unlock()
+	s.locksMutex.Lock()
+	defer s.locksMutex.Unlock()
+	if lock.holders--; lock.holders == 0 {
+		delete(s.locks, key)
+	}
+}
+
+func (s *tusService) uploadDir(connection string, id string) string {
+	return filepath.Join(s.dir, connection, id)
+}
+
+func (s *tusService) configured(connection string) bool {
+	for _, configured := range s.smartService.Connections() {
+		if configured.Name == connection {
+			return true
+		}
+	}
+	return false
+}
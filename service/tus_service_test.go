@@ -0,0 +1,124 @@
+package service
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestTusService(t *testing.T, expiration time.Duration) *tusService {
+	t.Helper()
+	s := NewTusService(newTestService(t), t.TempDir(), expiration).(*tusService)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func lockCount(s *tusService) int {
+	s.locksMutex.Lock()
+	defer s.locksMutex.Unlock()
+	return len(s.locks)
+}
+
+func TestTusLocksAreReleased(t *testing.T) {
+	s := newTestTusService(t, 0)
+	upload, err := s.CreateUpload("mem", 4, map[string]string{"storeName": "files", "key": "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// requests arriving after the upload is terminated wait for the lock of those queued before
+	var wg sync.WaitGroup
+	var holders, overlaps atomic.Int32
+	request := func(delay time.Duration) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			time.Sleep(delay)
+			unlock := s.lock("mem", upload.ID)
+			if holders.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(time.Millisecond)
+			holders.Add(-1)
+			unlock()
+		}()
+	}
+	unlock := s.lock("mem", upload.ID)
+	for i := 0; i < 4; i++ {
+		request(0)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		time.Sleep(2 * time.Millisecond)
+		s.TerminateUpload("mem", upload.ID)
+	}()
+	time.Sleep(4 * time.Millisecond)
+	for i := 0; i < 4; i++ {
+		request(0)
+	}
+	unlock()
+	for i := 0; i < 8; i++ {
+		request(time.Duration(i) * time.Millisecond)
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n > 0 {
+		t.Fatalf("the lock was held by two requests %v times", n)
+	}
+	if _, err = s.GetUpload("mem", upload.ID); !errors.Is(err, domain.ErrUploadNotFound) {
+		t.Fatalf("got %v for a terminated upload, want ErrUploadNotFound", err)
+	}
+	if n := lockCount(s); n != 0 {
+		t.Fatalf("%v locks are left", n)
+	}
+}
+
+func TestTusSweepRemovesExpiredUploads(t *testing.T) {
+	s := newTestTusService(t, time.Millisecond)
+	upload, err := s.CreateUpload("mem", 4, map[string]string{"storeName": "files", "key": "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the sweep releases its lock right after it removed the upload
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		_, err = os.Stat(s.uploadDir("mem", upload.ID))
+		if errors.Is(err, os.ErrNotExist) && lockCount(s) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the expired upload wasn't swept, %v locks are left", lockCount(s))
+		}
+	}
+
+	// uploads busy with a request aren't swept
+	s = newTestTusService(t, time.Millisecond)
+	upload, err = s.CreateUpload("mem", 4, map[string]string{"storeName": "files", "key": "a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock := s.lock("mem", upload.ID)
+	time.Sleep(20 * time.Millisecond)
+	if _, err = os.Stat(s.uploadDir("mem", upload.ID)); err != nil {
+		t.Fatalf("a busy upload was swept: %v", err)
+	}
+	unlock()
+
+	// a closed service doesn't sweep any more
+	s = newTestTusService(t, time.Millisecond)
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if upload, err = s.CreateUpload("mem", 4, map[string]string{"storeName": "files", "key": "a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err = os.Stat(s.uploadDir("mem", upload.ID)); err != nil {
+		t.Fatalf("a closed service swept the upload: %v", err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatalf("closing twice failed: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+)
+
+// Versions lists the versions and delete markers of the objects below prefix, or of the object
+// key if one is given.
+func (s *smartService) Versions(connection string, storeName string, maxVersionsPerPage int32, token string, prefix string, key string) (domain.VersionPage, error) {
+	versioner, err := s.GetVersioner(connection)
+	if err != nil {
+		return domain.VersionPage{}, err
+	}
+	if key == "" {
+		return versioner.Versions(storeName, maxVersionsPerPage, token, prefix)
+	}
+	page, err := versioner.Versions(storeName, maxVersionsPerPage, token, key)
+	if err != nil {
+		return domain.VersionPage{}, err
+	}
+	versions := page.Versions[:0]
+	for _, version := range page.Versions {
+		if version.Key == key {
+			versions = append(versions, version)
+			continue
+		}
+		// versions are listed by key, so the versions of key are all listed once another key shows up
+		page.IsTruncated = false
+		page.NextToken = ""
+	}
+	page.Versions = versions
+	return page, nil
+}
+
+// RestoreVersion makes a copy of the version the current version of its object. The version
+// itself and all versions after it are kept.
+func (s *smartService) RestoreVersion(connection string, params *domain.ObjectParams) (domain.StorageObject, error) {
+	if _, err := s.GetVersioner(connection); err != nil {
+		return domain.StorageObject{}, err
+	}
+	if params.VersionID == "" {
+		return domain.StorageObject{}, errors.New("version id has to be given")
+	}
+	repository, err := s.GetRepository(connection)
+	if err != nil {
+		return domain.StorageObject{}, err
+	}
+	return repository.Copy(params, &domain.ObjectParams{StoreName: params.StoreName, Key: params.Key})
+}
+
+// DeleteVersion deletes a version or delete marker for good. Deleting the delete marker which is
+// the latest version brings back the object.
+func (s *smartService) DeleteVersion(connection string, params *domain.ObjectParams) error {
+	if _, err := s.GetVersioner(connection); err != nil {
+		return err
+	}
+	if params.VersionID == "" {
+		return errors.New("version id has to be given")
+	}
+	repository, err := s.GetRepository(connection)
+	if err != nil {
+		return err
+	}
+	_, err = repository.Delete(params)
+	return err
+}
+
+func (s *smartService) Versioning(connection string, storeName string) (domain.Versioning, error) {
+	versioner, err := s.GetVersioner(connection)
+	if err != nil {
+		return domain.Versioning{}, err
+	}
+	return versioner.Versioning(storeName)
+}
+
+// SetVersioning enables or suspends the versioning of the store. Once enabled, versioning can't
+// be turned off anymore, only suspended.
+func (s *smartService) SetVersioning(connection string, storeName string, enabled bool) (domain.Versioning, error) {
+	versioner, err := s.GetVersioner(connection)
+	if err != nil {
+		return domain.Versioning{}, err
+	}
+	return versioner.SetVersioning(storeName, enabled)
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"errors"
+	"github.com/nevcodia/smarthub/domain"
+	"github.com/nevcodia/smarthub/repository"
+	"strings"
+	"testing"
+)
+
+// listedVersions answers Versions with page and records the prefix it was asked for.
+type listedVersions struct {
+	domain.StorageRepository
+	page   domain.VersionPage
+	prefix string
+}
+
+func (v *listedVersions) Versions(storeName string, maxVersionsPerPage int32, token string, prefix string) (domain.VersionPage, error) {
+	v.prefix = prefix
+	page := v.page
+	page.Versions = append([]domain.ObjectVersion{}, v.page.Versions...)
+	return page, nil
+}
+
+func (v *listedVersions) Versioning(storeName string) (domain.Versioning, error) {
+	return domain.Versioning{StoreName: storeName, Status: domain.VersioningEnabled}, nil
+}
+
+func (v *listedVersions) SetVersioning(storeName string, enabled bool) (domain.Versioning, error) {
+	return v.Versioning(storeName)
+}
+
+func versionsOf(keys ...string) []domain.ObjectVersion {
+	versions := make([]domain.ObjectVersion, 0, len(keys))
+	for _, key := range keys {
+		versions = append(versions, domain.ObjectVersion{StorageObject: domain.StorageObject{Key: key, VersionID: "v-" + key}})
+	}
+	return versions
+}
+
+func TestVersionsFiltersKey(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		prefix    string
+		key       string
+		page      domain.VersionPage
+		want      string
+		truncated bool
+	}{
+		{"prefix", "a", "", domain.VersionPage{Versions: versionsOf("a.txt", "a.txt.bak", "a/b.txt"), IsTruncated: true, NextToken: "next"}, "a.txt,a.txt.bak,a/b.txt", true},
+		{"key", "", "a.txt", domain.VersionPage{Versions: versionsOf("a.txt", "a.txt", "a.txt.bak", "a.txt/b.txt")}, "a.txt,a.txt", false},
+		{"key over prefix", "b", "a.txt", domain.VersionPage{Versions: versionsOf("a.txt")}, "a.txt", false},
+		{"key continued on the next page", "", "a.txt", domain.VersionPage{Versions: versionsOf("a.txt", "a.txt"), IsTruncated: true, NextToken: "next"}, "a.txt,a.txt", true},
+		{"key followed by another key", "", "a.txt", domain.VersionPage{Versions: versionsOf("a.txt", "a.txt.bak"), IsTruncated: true, NextToken: "next"}, "a.txt", false},
+		{"key without versions", "", "a.txt", domain.VersionPage{Versions: versionsOf("a.txt.bak"), IsTruncated: true, NextToken: "next"}, "", false},
+	} {
+		versioner := &listedVersions{
+			StorageRepository: repository.NewMemoryRepository([]string{"files"}, "http://hub.test/api/mem/presigned", []byte("test key")),
+			page:              test.page,
+		}
+		service := NewSmartService([]domain.Connection{{Name: "versioned", Type: repository.MEMORY, Repository: versioner}}, t.TempDir(), t.TempDir())
+		page, err := service.Versions("versioned", "files", 10, "", test.prefix, test.key)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		keys := make([]string, 0, len(page.Versions))
+		for _, version := range page.Versions {
+			keys = append(keys, version.Key)
+		}
+		wantPrefix := test.prefix
+		if test.key != "" {
+			wantPrefix = test.key
+		}
+		if got := strings.Join(keys, ","); got != test.want || versioner.prefix != wantPrefix {
+			t.Errorf("%v: listed %q below %q, want %q below %q", test.name, got, versioner.prefix, test.want, wantPrefix)
+		}
+		if page.IsTruncated != test.truncated || (page.NextToken != "") != test.truncated {
+			t.Errorf("%v: got truncated %v with token %q, want truncated %v", test.name, page.IsTruncated, page.NextToken, test.truncated)
+		}
+	}
+
+	if _, err := newTestService(t).Versions("mem", "files", 10, "", "", "a.txt"); !errors.Is(err, domain.ErrNotSupported) {
+		t.Fatalf("got %v listing versions of the memory connection, want ErrNotSupported", err)
+	}
+}